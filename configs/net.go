@@ -1,5 +1,7 @@
 package configs
 
+import "crypto/tls"
+
 // 网络处理相关常量
 const (
 	// LocalSend 默认的 IPv4 组播地址
@@ -20,8 +22,28 @@ const (
 	TCPAcceptTimeout = 30 // 秒
 	// TCP 连接心跳间隔时间
 	TCPConnHeartbeatInterval = 15 // 秒
-	// TCP 连接心跳发送间隔时间
+	// TCP 连接心跳发送间隔时间 (对端不支持 ping 时使用)
 	TCPConnHeartbeatSendInterval = 8 // 秒
+	// 链路 ping 的发送间隔时间
+	LinkPingInterval = 5 // 秒
+	// 平滑往返时间超过该值时，链路被视为降级
+	LinkDegradedRTT = 500 // 毫秒
+	// 连续丢失的 ping 数达到该值时，链路被视为降级
+	LinkDegradedMissedPings = 1
+	// 连续丢失的 ping 数达到该值时，链路被视为断开并关闭
+	LinkDeadMissedPings = 3
+	// TCP 连接认证握手的超时时间，超时未完成认证的连接会被关闭
+	TCPHandshakeTimeout = 5 // 秒
+	// 认证握手数据帧的最大长度
+	TCPHandshakeFrameMaxSize = 1024 // 字节
+	// 链路协议版本，在 HELLO 帧中告知对端
+	LinkProtocolVersion = 5
+	// 能兼容的对端最低链路协议版本 (版本 5 起数据帧头部带有密钥 ID，和之前的版本不兼容)
+	LinkProtocolMinVersion = 5
+	// 连接会话密钥的最长使用时间，超过后会轮换
+	SessionKeyRotationInterval = 10 * 60 // 秒
+	// 连接会话密钥最多加密的数据数，超过后会轮换
+	SessionKeyRotationMaxUses = 1 << 20
 	// TCP 服务重启间隔时间
 	TCPServerRestartInterval = 3 // 秒
 	// 读取 TCP 数据时字节缓冲区大小
@@ -30,10 +52,38 @@ const (
 	SwitchDataReceiveChanSize = 128
 	// 发现信息最大跳数
 	MaxDiscoveryMessageTTL = 255
-	// 和对端 switch 建立 TCP 连接的重试间隔
-	SwitchPeerConnectRetryInterval = 3 // 秒
-	// TCP 发送通道缓冲区大小
+	// 和对端 switch 建立 TCP 连接的初始重试间隔，之后按指数退避增长
+	SwitchPeerConnectRetryBaseInterval = 3 // 秒
+	// 和对端 switch 建立 TCP 连接的最大重试间隔
+	SwitchPeerConnectRetryMaxInterval = 120 // 秒
+	// 和对端 switch 建立 TCP 连接的超时时间 (每个解析出的地址)
+	SwitchPeerDialTimeout = 5 // 秒
+	// 已连接时重新解析对端 switch 域名的间隔
+	SwitchPeerResolveInterval = 300 // 秒
+	// 解析对端 switch 域名的超时时间
+	SwitchPeerResolveTimeout = 5 // 秒
+	// 向对端交换已知节点地址的间隔
+	PeerExchangeInterval = 60 // 秒
+	// 每次对端交换最多新记录的节点数
+	PeerExchangeMaxLearnedPerExchange = 16
+	// 最多记录的由同一对端告知的节点数
+	PeerExchangeMaxLearnedPerSource = 64
+	// 尝试建立网状链路的间隔
+	MeshConnectInterval = 30 // 秒
+	// 网状链路连接失败后，再次尝试连接同一节点前的等待时间
+	MeshDialRetryInterval = 300 // 秒
+	// 定时生成本节点链路状态的间隔 (链路变化时会立即生成)
+	LinkStateInterval = 30 // 秒
+	// 链路状态的最大存活时间，超过该时间没有更新的节点会被视为离开
+	LinkStateMaxAge = 90 // 秒
+	// 链路状态变化后，要稳定多久才按转发树转发，之前仍然泛洪
+	LinkStateSettleTime = 10 // 秒
+	// 链路状态发送通道缓冲区大小
+	LinkStateChanSize = 16
+	// TCP 发送通道缓冲区大小，即每条链路发送队列的长度
 	TCPSocketSendChanSize = 32
+	// 记录链路发送队列丢弃情况的时间间隔
+	SendQueueDropLogInterval = 30 // 秒
 	// 写入 TCP 数据的超时时间
 	TCPSocketWriteTimeout = 3 // 秒
 	// HTTP 请求超时时间
@@ -44,9 +94,51 @@ const (
 	HTTPClientWorkerCount = 8
 )
 
+// 连接多个对端 switch 时的模式
+const (
+	// 同时连接所有对端 switch
+	PeerConnectModeAll = "all"
+	// 按优先级只连接第一个可用的对端 switch，断开后故障转移到下一个
+	PeerConnectModeFailover = "failover"
+)
+
+// switch 之间链路的安全模式
+const (
+	// 使用预共享密钥 (--secret-key) 认证和加密链路
+	LinkSecurityModePSK = "psk"
+	// 使用 TLS 1.3 双向证书认证 (mTLS)
+	LinkSecurityModeTLS = "tls"
+	// 接受两种模式的入站连接 (用于迁移)，出站连接仍使用预共享密钥
+	LinkSecurityModeBoth = "both"
+)
+
+// 链路发送队列已满时的处理策略
+const (
+	// 丢弃队列中最旧的消息，为新消息腾出位置
+	SendQueuePolicyDropOldest = "drop-oldest"
+	// 丢弃新消息
+	SendQueuePolicyDropNewest = "drop-newest"
+	// 断开过慢的对端
+	SendQueuePolicyDisconnect = "disconnect"
+)
+
 var (
 	// 和对端 switch 建立 TCP 连接的最大重试次数
 	switchPeerConnectMaxRetries = 10
+	// 连接多个对端 switch 时的模式
+	peerConnectMode = PeerConnectModeAll
+	// switch 之间链路的安全模式
+	linkSecurityMode = LinkSecurityModePSK
+	// switch 之间链路的 TLS 配置，仅在 tls / both 模式下使用
+	linkTLSConfig *tls.Config
+	// 本节点 TCP 服务监听的端口，0 表示不启动 TCP 服务
+	servicePort = 0
+	// 自动和已知节点建立的网状链路的最大数量，0 表示不建立
+	meshLinks = 0
+	// 链路发送队列已满时的处理策略
+	sendQueuePolicy = SendQueuePolicyDropOldest
+	// 同时进行中 (尚未完成认证和 HELLO) 的入站握手的最大数量，超过后新连接会被立即关闭
+	maxPendingHandshakes = 64
 )
 
 // SetSwitchPeerConnectMaxRetries 设置和对端 switch 建立 TCP 连接的最大重试次数
@@ -58,3 +150,73 @@ func SetSwitchPeerConnectMaxRetries(retries int) {
 func GetSwitchPeerConnectMaxRetries() int {
 	return switchPeerConnectMaxRetries
 }
+
+// SetPeerConnectMode 设置连接多个对端 switch 时的模式
+func SetPeerConnectMode(mode string) {
+	peerConnectMode = mode
+}
+
+// GetPeerConnectMode 获取连接多个对端 switch 时的模式
+func GetPeerConnectMode() string {
+	return peerConnectMode
+}
+
+// SetLinkSecurityMode 设置 switch 之间链路的安全模式
+func SetLinkSecurityMode(mode string) {
+	linkSecurityMode = mode
+}
+
+// GetLinkSecurityMode 获取 switch 之间链路的安全模式
+func GetLinkSecurityMode() string {
+	return linkSecurityMode
+}
+
+// SetLinkTLSConfig 设置 switch 之间链路的 TLS 配置
+func SetLinkTLSConfig(config *tls.Config) {
+	linkTLSConfig = config
+}
+
+// GetLinkTLSConfig 获取 switch 之间链路的 TLS 配置
+func GetLinkTLSConfig() *tls.Config {
+	return linkTLSConfig
+}
+
+// SetServicePort 设置本节点 TCP 服务监听的端口
+func SetServicePort(port int) {
+	servicePort = port
+}
+
+// GetServicePort 获取本节点 TCP 服务监听的端口
+func GetServicePort() int {
+	return servicePort
+}
+
+// SetMeshLinks 设置自动和已知节点建立的网状链路的最大数量
+func SetMeshLinks(links int) {
+	meshLinks = links
+}
+
+// GetMeshLinks 获取自动和已知节点建立的网状链路的最大数量
+func GetMeshLinks() int {
+	return meshLinks
+}
+
+// SetSendQueuePolicy 设置链路发送队列已满时的处理策略
+func SetSendQueuePolicy(policy string) {
+	sendQueuePolicy = policy
+}
+
+// GetSendQueuePolicy 获取链路发送队列已满时的处理策略
+func GetSendQueuePolicy() string {
+	return sendQueuePolicy
+}
+
+// SetMaxPendingHandshakes 设置同时进行中的入站握手的最大数量
+func SetMaxPendingHandshakes(max int) {
+	maxPendingHandshakes = max
+}
+
+// GetMaxPendingHandshakes 获取同时进行中的入站握手的最大数量
+func GetMaxPendingHandshakes() int {
+	return maxPendingHandshakes
+}
@@ -3,12 +3,70 @@ package configs
 // 交换机制相关常量
 
 const (
+	// 软件版本
+	AppVersion = "1.0.0"
 	// 交换消息 ID 缓存的生命周期，单位为秒
 	SwitchIDCacheLifetime = 300
 	// 交换消息 ID 缓存的最大条目数
 	SwitchIDCacheMaxEntries = 65536
 	// 交换数据等候区大小，即本地停留的发现信息最大条目数，多余的会被丢弃
 	SwitchLoungeSize = 255 * 255
+	// 客户端公告存储的最大条目数，即新链路建立时推送的快照中最多包含的远端客户端数
+	AnnouncementStoreMaxEntries = 4096
+	// 过期的本地客户端通知通道缓冲区大小
+	LocalClientExpiredChanSize = 16
+	// 关闭时等待撤回消息发出的最长时间，单位为毫秒
+	ShutdownFlushTimeout = 1000
+	// 关闭时等待交换服务核心完成收尾工作 (撤回本地客户端、保存节点状态等) 的最长时间，单位为毫秒
+	ShutdownCoreTimeout = 5000
+	// 远端客户端注册记录的生命周期，超过该时间没有收到其发现包的远端客户端会被遗忘，单位为秒
+	RemoteClientRegistrationLifetime = 300
+	// 远端客户端登记表中条目的生命周期，超过该时间没有收到其发现包的远端客户端会被移除，单位为秒
+	RemoteClientRegistryLifetime = 180
+	// 远端客户端登记表的最大条目数
+	RemoteClientRegistryMaxEntries = 4096
+	// 每个远端客户端最多记录的历史地址数
+	RemoteClientAddressHistorySize = 8
+	// 响应同一远端客户端征求 (SOLICIT) 的最短间隔，防止用户连续刷新时反复注册，单位为毫秒
+	SolicitResponseMinInterval = 2000
+	// 节点状态文件名 (位于工作目录下)
+	StateFileName = "localsend-switch-state"
+	// 定时保存节点状态的时间间隔，单位为秒
+	StateSaveInterval = 60
+	// 最多记录的已知对端节点数
+	MaxKnownPeers = 256
+	// 已知对端节点的生命周期，超过该时间没有和其建立过链路的对端节点不会再被保存，单位为秒
+	KnownPeerLifetime = 7 * 24 * 3600
+	// 本节点 ID 文件名 (位于工作目录下)
+	NodeIDFileName = "localsend-switch-node-id"
+	// 本节点 Ed25519 身份私钥文件名 (位于工作目录下)
+	NodeIdentityKeyFileName = "localsend-switch-node.key"
+	// 首次信任 (TOFU) 模式下记录已知节点公钥的文件名 (位于工作目录下)
+	KnownNodesFileName = "localsend-switch-known-nodes"
+	// 从 systemd 凭据目录 ($CREDENTIALS_DIRECTORY) 读取密钥时使用的凭据名称
+	SecretKeyCredentialName = "localsend-switch-secret-key"
+	// 交换数据加密密钥的最短长度 (字符)，密钥 ID 和握手证明都可以被用来离线猜测密钥，密钥必须有足够的熵
+	MinSecretKeyLength = 16
+	// 每个发起节点的发现包序列号滑动窗口大小，窗口外过旧的序列号会被拒绝 (不能超过 64)
+	SwitchSeqWindowSize = 64
+)
+
+// 转发发现包的方式
+const (
+	// 泛洪: 转发给除来源以外的所有链路，靠发现包 ID 去重打破环路
+	ForwardingModeFlood = "flood"
+	// 转发树: 根据各节点交换的链路状态，按以发起节点为根的最短路径树转发，不发送多余的副本
+	ForwardingModeTree = "tree"
+)
+
+// 校验发现包签名时信任发起节点公钥的模式
+const (
+	// 首次信任: 第一次见到某个节点时记住其公钥，之后该节点的发现包必须用同一公钥签名
+	NodeTrustModeTOFU = "tofu"
+	// 只信任预先配置的节点公钥
+	NodeTrustModePinned = "pinned"
+	// 不校验签名
+	NodeTrustModeOff = "off"
 )
 
 var (
@@ -18,8 +76,30 @@ var (
 	localClientAliveCheckInterval = 10
 	// 本地客户端信息缓存时间，单位为秒
 	localClientInfoCacheLifetime = 60
+	// 远端客户端信息没有变化时，重新向其注册本地客户端的时间间隔，单位为秒，0 表示不重新注册
+	clientReregisterInterval = 120
 	// 交换数据加密密钥
 	switchDataSecret = ""
+	// 除主密钥外额外接受的交换数据加密密钥，用于密钥轮换期间
+	acceptedSwitchDataSecrets []string
+	// 发现包的最大存活时间 (按发起节点的时间戳计算)，单位为秒
+	switchMessageMaxAge = 120
+	// 信任发起节点公钥的模式
+	nodeTrustMode = NodeTrustModeTOFU
+	// 预先配置的受信任节点公钥 (Base64 编码)
+	trustedNodeKeys []string
+	// 首次信任模式下最多记住的节点公钥数，达到后不再信任新的节点
+	maxKnownNodes = 1024
+	// 本节点名称，随发现包和 HELLO 帧告知其他节点
+	nodeName = ""
+	// 本节点所在站点的标签，随发现包和 HELLO 帧告知其他节点
+	nodeSite = ""
+	// 是否把节点状态保存到工作目录，以便重启后快速恢复
+	persistState = false
+	// 转发发现包的方式
+	forwardingMode = ForwardingModeFlood
+	// 转发发现包时是否在转发路径中记录时间
+	pathTimestamps = false
 )
 
 // SetLocalClientBroadcastInterval 设置定时广播本地客户端信息的时间间隔，单位为秒
@@ -49,6 +129,16 @@ func GetLocalClientInfoCacheLifetime() int {
 	return localClientInfoCacheLifetime
 }
 
+// SetClientReregisterInterval 设置远端客户端信息没有变化时，重新向其注册本地客户端的时间间隔，单位为秒
+func SetClientReregisterInterval(seconds int) {
+	clientReregisterInterval = seconds
+}
+
+// GetClientReregisterInterval 获取远端客户端信息没有变化时，重新向其注册本地客户端的时间间隔，单位为秒
+func GetClientReregisterInterval() int {
+	return clientReregisterInterval
+}
+
 // SetSwitchDataSecret 设置交换数据加密密钥
 func SetSwitchDataSecret(secret string) {
 	switchDataSecret = secret
@@ -57,4 +147,111 @@ func SetSwitchDataSecret(secret string) {
 // GetSwitchDataSecret 获取交换数据加密密钥
 func GetSwitchDataSecret() string {
 	return switchDataSecret
+}
+
+// SetAcceptedSwitchDataSecrets 设置除主密钥外额外接受的交换数据加密密钥
+func SetAcceptedSwitchDataSecrets(secrets []string) {
+	acceptedSwitchDataSecrets = secrets
+}
+
+// GetAcceptedSwitchDataSecrets 获取除主密钥外额外接受的交换数据加密密钥
+func GetAcceptedSwitchDataSecrets() []string {
+	return acceptedSwitchDataSecrets
+}
+
+// SetSwitchMessageMaxAge 设置发现包的最大存活时间，单位为秒
+func SetSwitchMessageMaxAge(seconds int) {
+	switchMessageMaxAge = seconds
+}
+
+// GetSwitchMessageMaxAge 获取发现包的最大存活时间，单位为秒
+func GetSwitchMessageMaxAge() int {
+	return switchMessageMaxAge
+}
+
+// GetFullAnnouncementInterval 获取信息没有变化的本地客户端重新发出完整公告的时间间隔，单位为秒
+//
+// 取发现包最大存活时间的一半，保证各节点保存的完整公告在推送快照时总是新鲜的
+func GetFullAnnouncementInterval() int {
+	return max(switchMessageMaxAge/2, 1)
+}
+
+// SetNodeTrustMode 设置信任发起节点公钥的模式
+func SetNodeTrustMode(mode string) {
+	nodeTrustMode = mode
+}
+
+// GetNodeTrustMode 获取信任发起节点公钥的模式
+func GetNodeTrustMode() string {
+	return nodeTrustMode
+}
+
+// SetTrustedNodeKeys 设置预先配置的受信任节点公钥 (Base64 编码)
+func SetTrustedNodeKeys(keys []string) {
+	trustedNodeKeys = keys
+}
+
+// GetTrustedNodeKeys 获取预先配置的受信任节点公钥 (Base64 编码)
+func GetTrustedNodeKeys() []string {
+	return trustedNodeKeys
+}
+
+// SetMaxKnownNodes 设置首次信任模式下最多记住的节点公钥数
+func SetMaxKnownNodes(max int) {
+	maxKnownNodes = max
+}
+
+// GetMaxKnownNodes 获取首次信任模式下最多记住的节点公钥数
+func GetMaxKnownNodes() int {
+	return maxKnownNodes
+}
+
+// SetNodeName 设置本节点名称
+func SetNodeName(name string) {
+	nodeName = name
+}
+
+// GetNodeName 获取本节点名称
+func GetNodeName() string {
+	return nodeName
+}
+
+// SetNodeSite 设置本节点所在站点的标签
+func SetNodeSite(site string) {
+	nodeSite = site
+}
+
+// GetNodeSite 获取本节点所在站点的标签
+func GetNodeSite() string {
+	return nodeSite
+}
+
+// SetPersistState 设置是否把节点状态保存到工作目录
+func SetPersistState(persist bool) {
+	persistState = persist
+}
+
+// GetPersistState 获取是否把节点状态保存到工作目录
+func GetPersistState() bool {
+	return persistState
+}
+
+// SetForwardingMode 设置转发发现包的方式
+func SetForwardingMode(mode string) {
+	forwardingMode = mode
+}
+
+// GetForwardingMode 获取转发发现包的方式
+func GetForwardingMode() string {
+	return forwardingMode
+}
+
+// SetPathTimestamps 设置转发发现包时是否在转发路径中记录时间
+func SetPathTimestamps(enabled bool) {
+	pathTimestamps = enabled
+}
+
+// GetPathTimestamps 获取转发发现包时是否在转发路径中记录时间
+func GetPathTimestamps() bool {
+	return pathTimestamps
 }
\ No newline at end of file
@@ -72,3 +72,15 @@ type HTTPJsonRequest struct {
 	JsonBody []byte
 	RespChan chan *HTTPResponse // 可选的响应通道，用于接收响应数据
 }
+
+// PeerEndpoint 表示一个要连接的对端 switch 节点
+type PeerEndpoint struct {
+	Host     string // 对端地址
+	Port     string // 对端端口
+	Priority int    // 优先级，数值越小越优先 (即在列表中的顺序)
+}
+
+// String 返回 host:port 形式的地址
+func (pe PeerEndpoint) String() string {
+	return net.JoinHostPort(pe.Host, pe.Port)
+}
@@ -13,6 +13,10 @@ type SwitchMessage struct {
 	// 数据发送来源地址，可能是中间节点 IP，不一定是发送信息发出的原始地址
 	SourceAddr net.Addr
 	Payload    *switchdata.DiscoveryMessage
+	// 预先编码好的明文数据帧 (数据帧头部 + 序列化后的 Payload)，由多个连接共享，为空时由各连接自行序列化
+	//
+	// 设置后 Payload 和 Frame 都不能再被修改
+	Frame []byte
 }
 
 // LocalSendClientInfo 存储 LocalSend 客户端信息
@@ -23,6 +23,56 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// 发现包类型
+type AnnouncementKind int32
+
+const (
+	AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE AnnouncementKind = 0 // 公告: 客户端新出现或信息有变化，带有完整的客户端信息
+	AnnouncementKind_ANNOUNCEMENT_KIND_REFRESH  AnnouncementKind = 1 // 保活: 客户端信息没有变化，省略了别名、设备信息等字段
+	AnnouncementKind_ANNOUNCEMENT_KIND_WITHDRAW AnnouncementKind = 2 // 撤回: 客户端已经离开 (或发起节点正在关闭)，同样省略了别名、设备信息等字段
+)
+
+// Enum value maps for AnnouncementKind.
+var (
+	AnnouncementKind_name = map[int32]string{
+		0: "ANNOUNCEMENT_KIND_ANNOUNCE",
+		1: "ANNOUNCEMENT_KIND_REFRESH",
+		2: "ANNOUNCEMENT_KIND_WITHDRAW",
+	}
+	AnnouncementKind_value = map[string]int32{
+		"ANNOUNCEMENT_KIND_ANNOUNCE": 0,
+		"ANNOUNCEMENT_KIND_REFRESH":  1,
+		"ANNOUNCEMENT_KIND_WITHDRAW": 2,
+	}
+)
+
+func (x AnnouncementKind) Enum() *AnnouncementKind {
+	p := new(AnnouncementKind)
+	*p = x
+	return p
+}
+
+func (x AnnouncementKind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (AnnouncementKind) Descriptor() protoreflect.EnumDescriptor {
+	return file_switch_data_proto_enumTypes[0].Descriptor()
+}
+
+func (AnnouncementKind) Type() protoreflect.EnumType {
+	return &file_switch_data_proto_enumTypes[0]
+}
+
+func (x AnnouncementKind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use AnnouncementKind.Descriptor instead.
+func (AnnouncementKind) EnumDescriptor() ([]byte, []int) {
+	return file_switch_data_proto_rawDescGZIP(), []int{0}
+}
+
 // 交换的 LocalSend 客户端信息
 // 参考: https://github.com/localsend/protocol?tab=readme-ov-file#31-multicast-udp-default
 type DiscoveryMessage struct {
@@ -40,7 +90,21 @@ type DiscoveryMessage struct {
 	Protocol    string `protobuf:"bytes,10,opt,name=protocol,proto3" json:"protocol,omitempty"`                         // 协议
 	Download    bool   `protobuf:"varint,11,opt,name=download,proto3" json:"download,omitempty"`                        // 是否支持下载
 	// 新增字段，记录原始发送者地址
-	OriginalAddr  string `protobuf:"bytes,12,opt,name=original_addr,json=originalAddr,proto3" json:"original_addr,omitempty"` // 原始发送者地址
+	OriginalAddr    string `protobuf:"bytes,12,opt,name=original_addr,json=originalAddr,proto3" json:"original_addr,omitempty"`           // 原始发送者地址
+	OriginTimestamp int64  `protobuf:"varint,13,opt,name=origin_timestamp,json=originTimestamp,proto3" json:"origin_timestamp,omitempty"` // 发现包在发起节点生成的时间 (Unix 毫秒)
+	// 发起节点的身份签名，覆盖发现包中不会被转发节点修改的字段
+	OriginPublicKey []byte `protobuf:"bytes,14,opt,name=origin_public_key,json=originPublicKey,proto3" json:"origin_public_key,omitempty"` // 发起节点的 Ed25519 公钥
+	OriginSignature []byte `protobuf:"bytes,15,opt,name=origin_signature,json=originSignature,proto3" json:"origin_signature,omitempty"`   // 发起节点的 Ed25519 签名
+	// 发起节点的名称和站点标签，便于运维人员确认客户端信息来自哪里
+	OriginNodeName string `protobuf:"bytes,16,opt,name=origin_node_name,json=originNodeName,proto3" json:"origin_node_name,omitempty"` // 发起节点名称
+	OriginSite     string `protobuf:"bytes,17,opt,name=origin_site,json=originSite,proto3" json:"origin_site,omitempty"`               // 发起节点所在站点
+	// 增量公告: 只有客户端新出现或信息变化时才发出完整的公告，其余时候只发出精简的保活
+	Kind          AnnouncementKind `protobuf:"varint,18,opt,name=kind,proto3,enum=switchdata.AnnouncementKind" json:"kind,omitempty"`       // 发现包类型
+	ClientVersion uint64           `protobuf:"varint,19,opt,name=client_version,json=clientVersion,proto3" json:"client_version,omitempty"` // 客户端信息的版本，信息变化时增大
+	// 征求 (SOLICIT): 即 LocalSend 组播包中的 announce 字段，用户在 LocalSend 中刷新了设备列表，收到的节点应立即回应
+	Solicit bool `protobuf:"varint,20,opt,name=solicit,json=announce,proto3" json:"solicit,omitempty"`
+	// 转发路径: 发出或转发过该发现包的节点依次追加在末尾，途中会被修改，因此不在签名范围内
+	Path          []*DiscoveryHop `protobuf:"bytes,21,rep,name=path,proto3" json:"path,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -159,12 +223,128 @@ func (x *DiscoveryMessage) GetOriginalAddr() string {
 	return ""
 }
 
+func (x *DiscoveryMessage) GetOriginTimestamp() int64 {
+	if x != nil {
+		return x.OriginTimestamp
+	}
+	return 0
+}
+
+func (x *DiscoveryMessage) GetOriginPublicKey() []byte {
+	if x != nil {
+		return x.OriginPublicKey
+	}
+	return nil
+}
+
+func (x *DiscoveryMessage) GetOriginSignature() []byte {
+	if x != nil {
+		return x.OriginSignature
+	}
+	return nil
+}
+
+func (x *DiscoveryMessage) GetOriginNodeName() string {
+	if x != nil {
+		return x.OriginNodeName
+	}
+	return ""
+}
+
+func (x *DiscoveryMessage) GetOriginSite() string {
+	if x != nil {
+		return x.OriginSite
+	}
+	return ""
+}
+
+func (x *DiscoveryMessage) GetKind() AnnouncementKind {
+	if x != nil {
+		return x.Kind
+	}
+	return AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE
+}
+
+func (x *DiscoveryMessage) GetClientVersion() uint64 {
+	if x != nil {
+		return x.ClientVersion
+	}
+	return 0
+}
+
+func (x *DiscoveryMessage) GetSolicit() bool {
+	if x != nil {
+		return x.Solicit
+	}
+	return false
+}
+
+func (x *DiscoveryMessage) GetPath() []*DiscoveryHop {
+	if x != nil {
+		return x.Path
+	}
+	return nil
+}
+
+// 转发路径上的一跳
+type DiscoveryHop struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	NodeId        string                 `protobuf:"bytes,1,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"` // 转发节点 ID
+	Timestamp     int64                  `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`        // 转发时间 (Unix 毫秒)，节点没有启用时为 0
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DiscoveryHop) Reset() {
+	*x = DiscoveryHop{}
+	mi := &file_switch_data_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DiscoveryHop) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DiscoveryHop) ProtoMessage() {}
+
+func (x *DiscoveryHop) ProtoReflect() protoreflect.Message {
+	mi := &file_switch_data_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DiscoveryHop.ProtoReflect.Descriptor instead.
+func (*DiscoveryHop) Descriptor() ([]byte, []int) {
+	return file_switch_data_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *DiscoveryHop) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *DiscoveryHop) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
 var File_switch_data_proto protoreflect.FileDescriptor
 
 const file_switch_data_proto_rawDesc = "" +
 	"\n" +
 	"\x11switch_data.proto\x12\n" +
-	"switchdata\"\x80\x03\n" +
+	"switchdata\"\xef\x05\n" +
 	"\x10DiscoveryMessage\x12\x1b\n" +
 	"\tswitch_id\x18\x01 \x01(\tR\bswitchId\x12#\n" +
 	"\rdiscovery_seq\x18\x02 \x01(\x04R\fdiscoverySeq\x12#\n" +
@@ -179,7 +359,24 @@ const file_switch_data_proto_rawDesc = "" +
 	"\bprotocol\x18\n" +
 	" \x01(\tR\bprotocol\x12\x1a\n" +
 	"\bdownload\x18\v \x01(\bR\bdownload\x12#\n" +
-	"\roriginal_addr\x18\f \x01(\tR\foriginalAddrB\x1aZ\x18switchdata/v1;switchdatab\x06proto3"
+	"\roriginal_addr\x18\f \x01(\tR\foriginalAddr\x12)\n" +
+	"\x10origin_timestamp\x18\r \x01(\x03R\x0foriginTimestamp\x12*\n" +
+	"\x11origin_public_key\x18\x0e \x01(\fR\x0foriginPublicKey\x12)\n" +
+	"\x10origin_signature\x18\x0f \x01(\fR\x0foriginSignature\x12(\n" +
+	"\x10origin_node_name\x18\x10 \x01(\tR\x0eoriginNodeName\x12\x1f\n" +
+	"\vorigin_site\x18\x11 \x01(\tR\n" +
+	"originSite\x120\n" +
+	"\x04kind\x18\x12 \x01(\x0e2\x1c.switchdata.AnnouncementKindR\x04kind\x12%\n" +
+	"\x0eclient_version\x18\x13 \x01(\x04R\rclientVersion\x12\x19\n" +
+	"\asolicit\x18\x14 \x01(\bR\bannounce\x12,\n" +
+	"\x04path\x18\x15 \x03(\v2\x18.switchdata.DiscoveryHopR\x04path\"E\n" +
+	"\fDiscoveryHop\x12\x17\n" +
+	"\anode_id\x18\x01 \x01(\tR\x06nodeId\x12\x1c\n" +
+	"\ttimestamp\x18\x02 \x01(\x03R\ttimestamp*q\n" +
+	"\x10AnnouncementKind\x12\x1e\n" +
+	"\x1aANNOUNCEMENT_KIND_ANNOUNCE\x10\x00\x12\x1d\n" +
+	"\x19ANNOUNCEMENT_KIND_REFRESH\x10\x01\x12\x1e\n" +
+	"\x1aANNOUNCEMENT_KIND_WITHDRAW\x10\x02B\x1aZ\x18switchdata/v1;switchdatab\x06proto3"
 
 var (
 	file_switch_data_proto_rawDescOnce sync.Once
@@ -193,16 +390,21 @@ func file_switch_data_proto_rawDescGZIP() []byte {
 	return file_switch_data_proto_rawDescData
 }
 
-var file_switch_data_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_switch_data_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_switch_data_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
 var file_switch_data_proto_goTypes = []any{
-	(*DiscoveryMessage)(nil), // 0: switchdata.DiscoveryMessage
+	(AnnouncementKind)(0),    // 0: switchdata.AnnouncementKind
+	(*DiscoveryMessage)(nil), // 1: switchdata.DiscoveryMessage
+	(*DiscoveryHop)(nil),     // 2: switchdata.DiscoveryHop
 }
 var file_switch_data_proto_depIdxs = []int32{
-	0, // [0:0] is the sub-list for method output_type
-	0, // [0:0] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	0, // 0: switchdata.DiscoveryMessage.kind:type_name -> switchdata.AnnouncementKind
+	2, // 1: switchdata.DiscoveryMessage.path:type_name -> switchdata.DiscoveryHop
+	2, // [2:2] is the sub-list for method output_type
+	2, // [2:2] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
 }
 
 func init() { file_switch_data_proto_init() }
@@ -215,13 +417,14 @@ func file_switch_data_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_switch_data_proto_rawDesc), len(file_switch_data_proto_rawDesc)),
-			NumEnums:      0,
-			NumMessages:   1,
+			NumEnums:      1,
+			NumMessages:   2,
 			NumExtensions: 0,
 			NumServices:   0,
 		},
 		GoTypes:           file_switch_data_proto_goTypes,
 		DependencyIndexes: file_switch_data_proto_depIdxs,
+		EnumInfos:         file_switch_data_proto_enumTypes,
 		MessageInfos:      file_switch_data_proto_msgTypes,
 	}.Build()
 	File_switch_data_proto = out.File
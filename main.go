@@ -21,7 +21,6 @@ import (
 	"github.com/somebottle/localsend-switch/utils"
 )
 
-const AppVersion = "1.0.0"
 const EnvFileName = "localsend-switch.env"
 
 func main() {
@@ -34,6 +33,17 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Failed to get executable directory: %v\n", err)
 		return
 	}
+	// ------------ 子命令
+	if len(os.Args) > 1 && os.Args[1] == "keygen" {
+		// 生成一个随机的强密钥并输出
+		secret, err := utils.GenerateSecretKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate secret key: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(secret)
+		return
+	}
 	// ------------ 先读取配置
 
 	// 载入环境变量配置文件，读取环境变量
@@ -50,6 +60,7 @@ func main() {
 	localSendPort := os.Getenv("LOCALSEND_SERVER_PORT")             // LocalSend 组播 / HTTP 端口
 	peerAddr := os.Getenv("LOCALSEND_SWITCH_PEER_ADDR")
 	peerPort := os.Getenv("LOCALSEND_SWITCH_PEER_PORT")
+	peerMode := os.Getenv("LOCALSEND_SWITCH_PEER_MODE")
 	servPort := os.Getenv("LOCALSEND_SWITCH_SERV_PORT")
 	logDebugFlag := os.Getenv("LOCALSEND_SWITCH_LOG_DEBUG") // 是否启用调试日志, 1 为启用
 	logDebug := false
@@ -58,28 +69,77 @@ func main() {
 	}
 	clientBroadcastIntervalStr := os.Getenv("LOCALSEND_SWITCH_CLIENT_BROADCAST_INTERVAL")    // 向所有 peer switch 广播本地客户端的间隔
 	clientAliveCheckIntervalStr := os.Getenv("LOCALSEND_SWITCH_CLIENT_ALIVE_CHECK_INTERVAL") // 检测本地客户端存活的间隔
+	clientReregisterIntervalStr := os.Getenv("LOCALSEND_SWITCH_REREGISTER_INTERVAL")         // 远端客户端信息没有变化时重新注册的间隔
 	logFilePath := os.Getenv("LOCALSEND_SWITCH_LOG_FILE_PATH")
 	logFileMaxSize := os.Getenv("LOCALSEND_SWITCH_LOG_FILE_MAX_SIZE")
 	logFileMaxHistorical := os.Getenv("LOCALSEND_SWITCH_LOG_FILE_MAX_HISTORICAL")
 	switchPeerConnectMaxRetriesStr := os.Getenv("LOCALSEND_SWITCH_PEER_CONNECT_MAX_RETRIES")
 	workingDir := os.Getenv("LOCALSEND_SWITCH_WORK_DIR")
 	secretKey:= os.Getenv("LOCALSEND_SWITCH_SECRET_KEY")
+	secretKeyFile := os.Getenv("LOCALSEND_SWITCH_SECRET_KEY_FILE")
+	acceptSecretKeys := os.Getenv("LOCALSEND_SWITCH_ACCEPT_SECRET_KEYS")
+	messageMaxAgeStr := os.Getenv("LOCALSEND_SWITCH_MESSAGE_MAX_AGE")
+	linkSecurityMode := os.Getenv("LOCALSEND_SWITCH_LINK_SECURITY")    // switch 之间链路的安全模式
+	tlsCertFile := os.Getenv("LOCALSEND_SWITCH_TLS_CERT")              // 本节点证书文件
+	tlsKeyFile := os.Getenv("LOCALSEND_SWITCH_TLS_KEY")                // 本节点私钥文件
+	tlsCAFile := os.Getenv("LOCALSEND_SWITCH_TLS_CA")                  // CA 证书文件
+	tlsAllowedPeers := os.Getenv("LOCALSEND_SWITCH_TLS_ALLOWED_PEERS") // 允许的对端证书主题 / SAN，以逗号分隔
+	nodeTrustMode := os.Getenv("LOCALSEND_SWITCH_NODE_TRUST")          // 信任发起节点公钥的模式
+	trustedNodeKeys := os.Getenv("LOCALSEND_SWITCH_TRUSTED_NODE_KEYS") // 受信任的节点公钥，以逗号分隔
+	maxKnownNodesStr := os.Getenv("LOCALSEND_SWITCH_MAX_KNOWN_NODES")  // 首次信任模式下最多记住的节点公钥数
+	nodeName := os.Getenv("LOCALSEND_SWITCH_NODE_NAME")                // 本节点名称
+	nodeSite := os.Getenv("LOCALSEND_SWITCH_SITE")                     // 本节点所在站点
+	meshLinksStr := os.Getenv("LOCALSEND_SWITCH_MESH_LINKS")           // 自动建立的网状链路的最大数量
+	forwardingMode := os.Getenv("LOCALSEND_SWITCH_FORWARDING")         // 转发发现包的方式
+	sendQueuePolicy := os.Getenv("LOCALSEND_SWITCH_SEND_QUEUE_POLICY") // 链路发送队列已满时的处理策略
+	persistStateFlag := os.Getenv("LOCALSEND_SWITCH_PERSIST_STATE")    // 是否把节点状态保存到工作目录, 1 为启用
+	persistState := false
+	if persistStateFlag == "1" {
+		persistState = true
+	}
+	pathTimestampsFlag := os.Getenv("LOCALSEND_SWITCH_PATH_TIMESTAMPS") // 是否在转发路径中记录每一跳的时间, 1 为启用
+	pathTimestamps := false
+	if pathTimestampsFlag == "1" {
+		pathTimestamps = true
+	}
+	maxPendingHandshakesStr := os.Getenv("LOCALSEND_SWITCH_MAX_PENDING_HANDSHAKES") // 同时进行中的入站握手的最大数量
 
 	// 尝试从命令行读取配置
-	flag.StringVar(&peerAddr, "peer-addr", peerAddr, "Peer address")                                      // 另一个 switch 节点的地址
-	flag.StringVar(&peerPort, "peer-port", peerPort, "Peer port (same as service port if not specified)") // 另一个 switch 节点的端口
+	flag.StringVar(&peerAddr, "peer-addr", peerAddr, "Peer address, or a comma-separated list of peer addresses (host or host:port) in order of priority") // 其他 switch 节点的地址
+	flag.StringVar(&peerPort, "peer-port", peerPort, "Peer port (same as service port if not specified)")                                                 // 其他 switch 节点的默认端口
+	flag.StringVar(&peerMode, "peer-mode", peerMode, "How to connect to multiple peers, options: 'all' (connect to all), 'failover' (connect to the first available one by priority)")
 	flag.StringVar(&servPort, "serv-port", servPort, "Service port to listen for incoming TCP connections from peer switch nodes.") // 本地 TCP 服务监听端口
 	flag.StringVar(&localSendMulticastAddr, "ls-addr", localSendMulticastAddr, "LocalSend (Multicast) address")
 	flag.StringVar(&localSendPort, "ls-port", localSendPort, "LocalSend (Multicast / HTTP) port")
 	flag.BoolVar(&logDebug, "debug", logDebug, "Enable debug logging")
 	flag.StringVar(&clientBroadcastIntervalStr, "client-broadcast-interval", clientBroadcastIntervalStr, "The interval in seconds for broadcasting local clients to all peer switches")
 	flag.StringVar(&clientAliveCheckIntervalStr, "client-alive-check-interval", clientAliveCheckIntervalStr, "The interval in seconds for checking local client aliveness")
+	flag.StringVar(&clientReregisterIntervalStr, "reregister-interval", clientReregisterIntervalStr, "The interval in seconds for registering local clients again on remote clients whose information has not changed (0 to only register on changes)")
 	flag.StringVar(&logFilePath, "log-file", logFilePath, "Log file path")
 	flag.StringVar(&logFileMaxSize, "log-file-max-size", logFileMaxSize, "Log file max size in Bytes before rotation")
 	flag.StringVar(&logFileMaxHistorical, "log-file-max-historical", logFileMaxHistorical, "Max number of historical log files to keep")
 	flag.StringVar(&switchPeerConnectMaxRetriesStr, "peer-connect-max-retries", switchPeerConnectMaxRetriesStr, "Max retries to connect to peer switch before giving up (set to negative number for infinite retries)")
 	flag.StringVar(&workingDir, "work-dir", workingDir, "Working directory (default to executable's directory)")
-	flag.StringVar(&secretKey, "secret-key", secretKey, "Switch data encryption secret key")
+	flag.StringVar(&secretKey, "secret-key", secretKey, "Switch data encryption secret key (the primary key, used on outgoing links)")
+	flag.StringVar(&secretKeyFile, "secret-key-file", secretKeyFile, "Read the switch data encryption secret key from this file instead of --secret-key")
+	flag.StringVar(&acceptSecretKeys, "accept-secret-keys", acceptSecretKeys, "Comma-separated list of additional secret keys accepted on incoming links, for rotating the secret key")
+	flag.StringVar(&linkSecurityMode, "link-security", linkSecurityMode, "How to secure links between switches, options: 'psk' (pre-shared --secret-key), 'tls' (mutual TLS with certificates), 'both' (accept both on incoming links, use 'psk' on outgoing links)")
+	flag.StringVar(&tlsCertFile, "tls-cert", tlsCertFile, "Certificate file (PEM) of this switch node for mutual TLS")
+	flag.StringVar(&tlsKeyFile, "tls-key", tlsKeyFile, "Private key file (PEM) of this switch node for mutual TLS")
+	flag.StringVar(&tlsCAFile, "tls-ca", tlsCAFile, "CA certificate file (PEM) used to verify certificates of peer switches")
+	flag.StringVar(&tlsAllowedPeers, "tls-allowed-peers", tlsAllowedPeers, "Comma-separated list of allowed certificate subject common names or SANs of peer switches (allow any certificate signed by the CA if empty)")
+	flag.StringVar(&messageMaxAgeStr, "message-max-age", messageMaxAgeStr, "Max age in seconds of a switch message since it was created on its origin switch, older ones are dropped")
+	flag.StringVar(&nodeTrustMode, "node-trust", nodeTrustMode, "How to trust the public keys of origin switches, options: 'tofu' (trust on first use), 'pinned' (only trust keys in --trusted-node-keys), 'off' (do not verify signatures)")
+	flag.StringVar(&trustedNodeKeys, "trusted-node-keys", trustedNodeKeys, "Comma-separated list of trusted switch node public keys (Base64), used in 'pinned' trust mode")
+	flag.StringVar(&maxKnownNodesStr, "max-known-nodes", maxKnownNodesStr, "Max number of switch node public keys remembered in 'tofu' trust mode, new switch nodes are not trusted once it is reached")
+	flag.StringVar(&nodeName, "node-name", nodeName, "Human-readable name of this switch node shown to other switches (default to hostname)")
+	flag.StringVar(&nodeSite, "site", nodeSite, "Site label of this switch node shown to other switches (e.g. 'home', 'office')")
+	flag.StringVar(&meshLinksStr, "mesh-links", meshLinksStr, "Max number of links opened automatically to switch nodes learned from peers, for a self-healing mesh (0 to disable)")
+	flag.StringVar(&maxPendingHandshakesStr, "max-pending-handshakes", maxPendingHandshakesStr, "Max number of incoming connections that are still in the authentication handshake at the same time, further connections are closed immediately")
+	flag.StringVar(&sendQueuePolicy, "send-queue-policy", sendQueuePolicy, "What to do when the send queue of a link is full, options: 'drop-oldest' (drop the oldest queued message), 'drop-newest' (drop the new message), 'disconnect' (disconnect the slow peer)")
+	flag.StringVar(&forwardingMode, "forwarding", forwardingMode, "How to forward switch messages, options: 'flood' (to all links except the incoming one), 'tree' (only along loop-free trees computed from link state, for mesh topologies)")
+	flag.BoolVar(&pathTimestamps, "path-timestamps", pathTimestamps, "Record the time of each hop in the forwarding path of client information, shown in debug logs")
+	flag.BoolVar(&persistState, "persist-state", persistState, "Save known clients and peers to a state file in the working directory, and restore them on restart")
 	// 开机自启选项
 	var autoStart string
 	flag.StringVar(&autoStart, "autostart", "", "Set auto start on system boot, options: 'enable', 'disable'")
@@ -138,7 +198,7 @@ func main() {
 	slog.SetDefault(logger)
 
 	// ----------- 输出版本信息和工作目录
-	slog.Info("LocalSend Switch starting...", "version", AppVersion)
+	slog.Info("LocalSend Switch starting...", "version", configs.AppVersion)
 	slog.Info("Working directory", "dir", workingDir)
 
 	// ------------ 开机自启设置
@@ -169,11 +229,86 @@ func main() {
 	}
 
 	// ----------- 设置交换数据加密密钥
+	secretKeyOnCommandLine := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "secret-key" {
+			secretKeyOnCommandLine = true
+		}
+	})
+	secretKey, secretKeySource, err := utils.LoadSecretKey(secretKey, secretKeyFile, configs.SecretKeyCredentialName)
+	if err != nil {
+		slog.Error("Failed to load secret key", "error", err)
+		return
+	}
+	switch secretKeySource {
+	case utils.SecretKeySourceFile:
+		slog.Info("Loaded secret key from file", "path", secretKeyFile)
+	case utils.SecretKeySourceCredential:
+		slog.Info("Loaded secret key from systemd credentials", "name", configs.SecretKeyCredentialName)
+	case utils.SecretKeySourceValue:
+		if secretKeyOnCommandLine {
+			slog.Warn("Secret key passed on the command line can be seen by other users (e.g. in 'ps' output) and in shell history, consider using --secret-key-file instead.")
+		}
+	}
+	if secretKey != "" {
+		if err := utils.CheckSecretKeyStrength(secretKey); err != nil {
+			slog.Error("Invalid secret key", "error", err)
+			return
+		}
+	}
 	configs.SetSwitchDataSecret(secretKey)
 	slog.Debug("Switch data secret key set", "keySet", secretKey != "")
+	if acceptSecretKeys != "" {
+		if secretKey == "" {
+			slog.Error("Additional secret keys (--accept-secret-keys) require a primary secret key (--secret-key)")
+			return
+		}
+		acceptedSecrets := utils.SplitCommaSeparated(acceptSecretKeys)
+		for i, secret := range acceptedSecrets {
+			if err := utils.CheckSecretKeyStrength(secret); err != nil {
+				slog.Error("Invalid additional secret key (--accept-secret-keys)", "index", i, "error", err)
+				return
+			}
+		}
+		configs.SetAcceptedSwitchDataSecrets(acceptedSecrets)
+	}
+	if secretKey != "" {
+		// 只打印密钥 ID，便于在轮换密钥时核对各节点的配置
+		acceptedKeyIDs := make([]string, 0)
+		for _, secret := range configs.GetAcceptedSwitchDataSecrets() {
+			acceptedKeyIDs = append(acceptedKeyIDs, utils.DeriveSecretKeyID(secret))
+		}
+		slog.Info("Secret key ring", "primaryKeyID", utils.DeriveSecretKeyID(secretKey), "acceptedKeyIDs", acceptedKeyIDs)
+	}
+
+	// ----------- 设置链路安全模式
+	switch linkSecurityMode {
+	case configs.LinkSecurityModePSK, configs.LinkSecurityModeTLS, configs.LinkSecurityModeBoth:
+		configs.SetLinkSecurityMode(linkSecurityMode)
+	case "":
+		// 使用默认模式
+	default:
+		slog.Error("Invalid value for 'link-security', should be 'psk', 'tls' or 'both'", "input", linkSecurityMode)
+		return
+	}
+	slog.Debug("Link security mode", "mode", configs.GetLinkSecurityMode())
+	if configs.GetLinkSecurityMode() != configs.LinkSecurityModePSK {
+		tlsConfig, err := utils.LoadLinkTLSConfig(tlsCertFile, tlsKeyFile, tlsCAFile, utils.SplitCommaSeparated(tlsAllowedPeers))
+		if err != nil {
+			slog.Error("Failed to set up mutual TLS for links between switches", "error", err)
+			return
+		}
+		configs.SetLinkTLSConfig(tlsConfig)
+	}
+
+	// both 模式只接受 TLS 或预共享密钥握手，必须配置密钥
+	if configs.GetSwitchDataSecret() == "" && configs.GetLinkSecurityMode() == configs.LinkSecurityModeBoth {
+		slog.Error("Link security mode 'both' requires a secret key (--secret-key), plaintext links are not accepted")
+		return
+	}
 
-	// 如果没有配置加密密钥则发出警告
-	if configs.GetSwitchDataSecret() == "" {
+	// 如果没有配置加密密钥则发出警告 (TLS 模式下链路由 TLS 保护)
+	if configs.GetSwitchDataSecret() == "" && configs.GetLinkSecurityMode() != configs.LinkSecurityModeTLS {
 		slog.Warn("Secret key (--secret-key) is NOT SET, switch data will be transmitted in plaintext, exposing it to interception and tampering. It is recommended to set a secret key for better security.")
 	}
 
@@ -186,6 +321,16 @@ func main() {
 		configs.SetSwitchPeerConnectMaxRetries(int(switchPeerConnectMaxRetries))
 	}
 	slog.Debug("Switch peer connect max retries", "maxRetries", configs.GetSwitchPeerConnectMaxRetries())
+	switch peerMode {
+	case configs.PeerConnectModeAll, configs.PeerConnectModeFailover:
+		configs.SetPeerConnectMode(peerMode)
+	case "":
+		// 使用默认模式
+	default:
+		slog.Error("Invalid value for 'peer-mode', should be 'all' or 'failover'", "input", peerMode)
+		return
+	}
+	slog.Debug("Peer connect mode", "mode", configs.GetPeerConnectMode())
 	if clientBroadcastIntervalStr != "" {
 		clientBroadcastInterval, err := strconv.ParseInt(clientBroadcastIntervalStr, 10, 32)
 		if err != nil || clientBroadcastInterval <= 0 {
@@ -204,6 +349,73 @@ func main() {
 	}
 	slog.Debug("Local client alive check interval (seconds)", "interval", configs.GetLocalClientAliveCheckInterval())
 
+	if clientReregisterIntervalStr != "" {
+		clientReregisterInterval, err := strconv.ParseInt(clientReregisterIntervalStr, 10, 32)
+		if err != nil || clientReregisterInterval < 0 {
+			slog.Error("Invalid time interval for 'reregister-interval', should be a non-negative integer", "input", clientReregisterIntervalStr, "error", err)
+			return
+		}
+		configs.SetClientReregisterInterval(int(clientReregisterInterval))
+	}
+	slog.Debug("Remote client re-register interval (seconds)", "interval", configs.GetClientReregisterInterval())
+
+	switch forwardingMode {
+	case configs.ForwardingModeFlood, configs.ForwardingModeTree:
+		configs.SetForwardingMode(forwardingMode)
+	case "":
+		// 使用默认方式
+	default:
+		slog.Error("Invalid value for 'forwarding', should be 'flood' or 'tree'", "input", forwardingMode)
+		return
+	}
+	slog.Debug("Forwarding mode", "mode", configs.GetForwardingMode())
+
+	switch sendQueuePolicy {
+	case configs.SendQueuePolicyDropOldest, configs.SendQueuePolicyDropNewest, configs.SendQueuePolicyDisconnect:
+		configs.SetSendQueuePolicy(sendQueuePolicy)
+	case "":
+		// 使用默认策略
+	default:
+		slog.Error("Invalid value for 'send-queue-policy', should be 'drop-oldest', 'drop-newest' or 'disconnect'", "input", sendQueuePolicy)
+		return
+	}
+	slog.Debug("Send queue policy", "policy", configs.GetSendQueuePolicy())
+
+	if messageMaxAgeStr != "" {
+		messageMaxAge, err := strconv.ParseInt(messageMaxAgeStr, 10, 32)
+		if err != nil || messageMaxAge <= 0 {
+			slog.Error("Invalid value for 'message-max-age', should be a positive integer", "input", messageMaxAgeStr, "error", err)
+			return
+		}
+		configs.SetSwitchMessageMaxAge(int(messageMaxAge))
+	}
+	slog.Debug("Switch message max age (seconds)", "maxAge", configs.GetSwitchMessageMaxAge())
+
+	switch nodeTrustMode {
+	case configs.NodeTrustModeTOFU, configs.NodeTrustModePinned, configs.NodeTrustModeOff:
+		configs.SetNodeTrustMode(nodeTrustMode)
+	case "":
+		// 使用默认模式
+	default:
+		slog.Error("Invalid value for 'node-trust', should be 'tofu', 'pinned' or 'off'", "input", nodeTrustMode)
+		return
+	}
+	if trustedNodeKeys != "" {
+		configs.SetTrustedNodeKeys(utils.SplitCommaSeparated(trustedNodeKeys))
+	}
+	if maxKnownNodesStr != "" {
+		maxKnownNodes, err := strconv.ParseInt(maxKnownNodesStr, 10, 32)
+		if err != nil || maxKnownNodes <= 0 {
+			slog.Error("Invalid value for 'max-known-nodes', should be a positive integer", "input", maxKnownNodesStr, "error", err)
+			return
+		}
+		configs.SetMaxKnownNodes(int(maxKnownNodes))
+	}
+	slog.Debug("Node trust mode", "mode", configs.GetNodeTrustMode(), "numTrustedKeys", len(configs.GetTrustedNodeKeys()), "maxKnownNodes", configs.GetMaxKnownNodes())
+	if configs.GetNodeTrustMode() == configs.NodeTrustModeOff {
+		slog.Warn("Node signature verification (--node-trust) is OFF, relaying switches are able to tamper with switch messages.")
+	}
+
 	if localSendMulticastAddr == "" {
 		localSendMulticastAddr = configs.LocalSendDefaultMulticastIPv4
 		slog.Debug("Multicast address not provided, using default value: " + localSendMulticastAddr)
@@ -219,11 +431,49 @@ func main() {
 		slog.Debug("Peer port not provided, using service port value", "port", peerPort)
 	}
 
+	if servPort != "" {
+		// 在 HELLO 中告知对端本节点的服务端口，便于其他节点直接连接
+		port, err := strconv.ParseUint(servPort, 10, 16)
+		if err != nil {
+			slog.Error("Invalid value for 'serv-port', should be a port number", "input", servPort, "error", err)
+			return
+		}
+		configs.SetServicePort(int(port))
+	}
+
+	if maxPendingHandshakesStr != "" {
+		maxPendingHandshakes, err := strconv.ParseInt(maxPendingHandshakesStr, 10, 32)
+		if err != nil || maxPendingHandshakes <= 0 {
+			slog.Error("Invalid value for 'max-pending-handshakes', should be a positive integer", "input", maxPendingHandshakesStr, "error", err)
+			return
+		}
+		configs.SetMaxPendingHandshakes(int(maxPendingHandshakes))
+	}
+	slog.Debug("Max pending handshakes", "maxPendingHandshakes", configs.GetMaxPendingHandshakes())
+
+	if meshLinksStr != "" {
+		meshLinks, err := strconv.ParseInt(meshLinksStr, 10, 32)
+		if err != nil || meshLinks < 0 {
+			slog.Error("Invalid value for 'mesh-links', should be a non-negative integer", "input", meshLinksStr, "error", err)
+			return
+		}
+		configs.SetMeshLinks(int(meshLinks))
+	}
+	slog.Debug("Max mesh links", "meshLinks", configs.GetMeshLinks())
+
 	if peerPort == "" && servPort == "" {
 		// 没有配置任何端口，只有组播监听服务会启动
 		slog.Warn("Both peer port and service port are not provided, only multicast listener will be set up")
 	}
 
+	// 解析对端 switch 节点列表
+	peers, err := utils.ParsePeerEndpoints(peerAddr, peerPort)
+	if err != nil {
+		slog.Error("Invalid value for 'peer-addr'", "input", peerAddr, "error", err)
+		return
+	}
+	slog.Debug("Peer switches", "peers", peers)
+
 	// 检查是否为 IPv6 地址
 	isIpv6, err := utils.IsIpv6(localSendMulticastAddr)
 	if err != nil {
@@ -258,9 +508,28 @@ func main() {
 		network = "udp4"
 	}
 
-	// ------------ 为节点生成一个唯一标识符
-	nodeId := utils.GenerateRandomSwitchID()
-	slog.Info("Switch Node ID", "nodeId", nodeId)
+	// ------------ 载入 (或生成) 节点唯一标识符，重启后保持不变
+	nodeId, err := utils.LoadOrCreateNodeID(configs.NodeIDFileName)
+	if err != nil {
+		slog.Error("Error loading node ID", "error", err)
+		return
+	}
+	if nodeName == "" {
+		// 默认使用主机名作为节点名称
+		nodeName, _ = os.Hostname()
+	}
+	configs.SetNodeName(nodeName)
+	configs.SetNodeSite(nodeSite)
+	configs.SetPersistState(persistState)
+	configs.SetPathTimestamps(pathTimestamps)
+	slog.Info("Switch Node ID", "nodeId", nodeId, "nodeName", nodeName, "site", nodeSite)
+	// ------------ 载入 (或生成) 节点身份密钥，用于对发现包签名
+	nodeIdentity, err := utils.LoadOrCreateNodeIdentity(configs.NodeIdentityKeyFileName)
+	if err != nil {
+		slog.Error("Error loading node identity", "error", err)
+		return
+	}
+	slog.Info("Switch Node public key", "publicKey", nodeIdentity.PublicKeyString())
 	// ------------ 加入组播组，接收 LocalSend 的发现 UDP 包
 	// 相关协议文档: https://github.com/localsend/protocol
 	// 本地组播数据转交通道
@@ -270,7 +539,12 @@ func main() {
 	go services.ListenLocalSendMulticast(nodeId, network, localSendMulticastAddr, localSendPort, outBoundInterface, sigCtx, multicastChan, errChan)
 
 	// ------------ 启动交换服务核心模块
-	go services.SetUpSwitchCore(nodeId, peerAddr, peerPort, servPort, sigCtx, multicastChan, localSendPort, errChan)
+	// 交换服务核心完成收尾工作后关闭
+	coreDone := make(chan struct{})
+	go func() {
+		defer close(coreDone)
+		services.SetUpSwitchCore(nodeId, nodeIdentity, peers, servPort, sigCtx, multicastChan, localSendPort, errChan)
+	}()
 
 	// 测试接收数据
 	for {
@@ -279,9 +553,16 @@ func main() {
 			panic(fmt.Sprintf("Exited with error: %v", err))
 		case <-sigCtx.Done():
 			slog.Info("Shutting down gracefully...")
-			logFileWriter.Close()
+			// 等待交换服务核心撤回本地客户端、保存节点状态
+			select {
+			case <-coreDone:
+			case <-time.After(configs.ShutdownCoreTimeout * time.Millisecond):
+				slog.Warn("Timed out waiting for switch core to shut down")
+			}
 			// 等待一会儿以确保所有 goroutine 都能退出
 			time.Sleep(2 * time.Second)
+			// 最后再关闭日志文件，之前的收尾工作仍会记录日志
+			logFileWriter.Close()
 			return
 		}
 	}
@@ -0,0 +1,169 @@
+package services
+
+// 客户端公告存储模块
+//
+// 为每个已知的远端客户端 (按发起节点和客户端指纹区分) 保存最新的一条发现包，
+// 新的链路建立时，把其中仍然新鲜的发现包作为快照推送给对端，对端不必等到各节点下一次主动广播就能得知所有客户端
+//
+// 保活 (REFRESH) 不含别名和设备信息，因此客户端信息版本没有变化时，保留之前的完整公告，只记录保活的序列号和时间戳
+
+import (
+	"sync"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// announcementKey 客户端公告的键
+type announcementKey struct {
+	// 发起节点 ID
+	switchId string
+	// 客户端指纹
+	fingerprint string
+}
+
+// storedAnnouncement 一个客户端保存的发现包
+type storedAnnouncement struct {
+	// 发现包，保活时保留的是之前的完整公告
+	msg *switchdata.DiscoveryMessage
+	// 最近一次收到的发现包 (包括保活) 的序列号
+	seq uint64
+	// 最近一次收到的发现包 (包括保活) 在发起节点生成的时间 (Unix 毫秒)
+	timestamp int64
+}
+
+// fresh 判断客户端最近一次收到的发现包是否仍在最大存活时间内
+func (sa *storedAnnouncement) fresh(now time.Time) bool {
+	maxAge := time.Duration(configs.GetSwitchMessageMaxAge()) * time.Second
+	return now.Sub(time.UnixMilli(sa.timestamp)) < maxAge
+}
+
+// AnnouncementStore 保存每个已知客户端最新的发现包
+type AnnouncementStore struct {
+	mutex         sync.Mutex
+	announcements map[announcementKey]*storedAnnouncement
+	closeSignal   chan struct{} // 关闭信号，让相应协程退出
+	closed        bool          // 标记是否关闭
+}
+
+// NewAnnouncementStore 创建一个新的客户端公告存储
+func NewAnnouncementStore() *AnnouncementStore {
+	as := AnnouncementStore{
+		announcements: make(map[announcementKey]*storedAnnouncement),
+		closeSignal:   make(chan struct{}),
+	}
+	// 定时清理不再新鲜的发现包
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				now := time.Now()
+				as.mutex.Lock()
+				for key, stored := range as.announcements {
+					if !stored.fresh(now) {
+						delete(as.announcements, key)
+					}
+				}
+				as.mutex.Unlock()
+			case <-as.closeSignal:
+				return
+			}
+		}
+	}()
+	return &as
+}
+
+// announcementFresh 判断发现包是否仍在最大存活时间内
+func announcementFresh(msg *switchdata.DiscoveryMessage, now time.Time) bool {
+	maxAge := time.Duration(configs.GetSwitchMessageMaxAge()) * time.Second
+	return now.Sub(time.UnixMilli(msg.OriginTimestamp)) < maxAge
+}
+
+// Put 记录一条发现包，只有比已保存的更新 (序列号更大) 时才会记录
+//
+// 同一客户端信息版本的保活不会替换已保存的完整公告，只更新序列号和时间戳；
+// 保存的是发现包的副本，之后修改原发现包不会影响存储
+//
+// 返回发现包是否不比已保存的旧，比如客户端撤回后才经其他路径到达的更旧的公告会返回 false
+//
+// msg: 发现包
+func (as *AnnouncementStore) Put(msg *switchdata.DiscoveryMessage) bool {
+	key := announcementKey{switchId: msg.SwitchId, fingerprint: msg.Fingerprint}
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	if as.closed {
+		return true
+	}
+	existing, exists := as.announcements[key]
+	if exists && existing.seq >= msg.DiscoverySeq {
+		return false
+	}
+	if exists && msg.Kind == switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_REFRESH &&
+		existing.msg.Kind == switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE && existing.msg.ClientVersion == msg.ClientVersion {
+		// 客户端信息没有变化，保留完整公告 (其签名也保持有效)
+		existing.seq = msg.DiscoverySeq
+		existing.timestamp = msg.OriginTimestamp
+		return true
+	}
+	if !exists && len(as.announcements) >= configs.AnnouncementStoreMaxEntries {
+		// 存储已满，忽略新的客户端
+		return true
+	}
+	as.announcements[key] = &storedAnnouncement{
+		msg:       proto.Clone(msg).(*switchdata.DiscoveryMessage),
+		seq:       msg.DiscoverySeq,
+		timestamp: msg.OriginTimestamp,
+	}
+	return true
+}
+
+// Snapshot 返回所有仍然新鲜的发现包的副本
+//
+// 撤回消息只用于防止更旧的公告再次被记录，不会包含在快照中；
+// 保留的完整公告本身过旧时对端会拒绝，也不会包含在快照中，由发起节点定期重新发出的完整公告补上
+//
+// excludeSwitchId: 不包含由该节点发起或经过该节点的发现包 (通常是快照的接收方自己)
+func (as *AnnouncementStore) Snapshot(excludeSwitchId string) []*switchdata.DiscoveryMessage {
+	now := time.Now()
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	snapshot := make([]*switchdata.DiscoveryMessage, 0, len(as.announcements))
+	for _, stored := range as.announcements {
+		msg := stored.msg
+		if msg.SwitchId == excludeSwitchId || utils.DiscoveryPathContains(msg, excludeSwitchId) || msg.Kind == switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_WITHDRAW || !announcementFresh(msg, now) {
+			continue
+		}
+		snapshot = append(snapshot, proto.Clone(msg).(*switchdata.DiscoveryMessage))
+	}
+	return snapshot
+}
+
+// Entries 返回所有仍然新鲜的发现包 (包括撤回消息) 的副本，用于保存节点状态
+func (as *AnnouncementStore) Entries() []*switchdata.DiscoveryMessage {
+	now := time.Now()
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	entries := make([]*switchdata.DiscoveryMessage, 0, len(as.announcements))
+	for _, stored := range as.announcements {
+		if announcementFresh(stored.msg, now) {
+			entries = append(entries, proto.Clone(stored.msg).(*switchdata.DiscoveryMessage))
+		}
+	}
+	return entries
+}
+
+// Close 关闭客户端公告存储
+func (as *AnnouncementStore) Close() {
+	as.mutex.Lock()
+	defer as.mutex.Unlock()
+	if as.closed {
+		return
+	}
+	close(as.closeSignal)
+	as.closed = true
+}
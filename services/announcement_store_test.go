@@ -0,0 +1,107 @@
+package services
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/somebottle/localsend-switch/entities"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+)
+
+// newTestNodeIdentity 在临时目录中生成一个节点身份
+func newTestNodeIdentity(t *testing.T) *utils.NodeIdentity {
+	identity, err := utils.LoadOrCreateNodeIdentity(filepath.Join(t.TempDir(), "node.key"))
+	if err != nil {
+		t.Fatalf("Failed to create node identity: %v", err)
+	}
+	return identity
+}
+
+// TestAnnouncementStoreLateJoiner 保活不会覆盖保存的完整公告，之后加入的节点只收到快照也能得知客户端的别名和设备信息
+func TestAnnouncementStoreLateJoiner(t *testing.T) {
+	origin := newTestNodeIdentity(t)
+	clientInfo := &entities.LocalSendClientInfo{
+		Alias:       "Laptop",
+		Version:     "2.1",
+		DeviceModel: "ThinkPad",
+		DeviceType:  "desktop",
+		Fingerprint: "fingerprint",
+		Port:        53317,
+		Protocol:    "https",
+	}
+	selfIp := net.ParseIP("192.168.1.10")
+	pack := func(seq uint64, kind switchdata.AnnouncementKind) *switchdata.DiscoveryMessage {
+		msg := utils.PackLocalSendClientInfoIntoSwitchMessage(clientInfo, "origin", seq, selfIp, kind, 1).Payload
+		origin.SignDiscoveryMessage(msg)
+		utils.AppendDiscoveryPathHop(msg, "origin")
+		return msg
+	}
+
+	// 已有的节点先收到完整公告，再收到若干保活
+	store := NewAnnouncementStore()
+	defer store.Close()
+	store.Put(pack(1, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE))
+	store.Put(pack(2, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_REFRESH))
+	store.Put(pack(3, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_REFRESH))
+
+	snapshot := store.Snapshot("late")
+	if len(snapshot) != 1 {
+		t.Fatalf("Expected 1 announcement in snapshot, got %d", len(snapshot))
+	}
+	if snapshot[0].Kind != switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE || snapshot[0].Alias != clientInfo.Alias {
+		t.Fatalf("Expected snapshot to keep the full announcement, got kind %s with alias %q", snapshot[0].Kind, snapshot[0].Alias)
+	}
+
+	// 之后加入的节点只收到快照，快照中的发现包要能通过其签名和防重放检查
+	keyring, err := NewNodeKeyring(filepath.Join(t.TempDir(), "known-nodes"), newTestNodeIdentity(t))
+	if err != nil {
+		t.Fatalf("Failed to create node keyring: %v", err)
+	}
+	lounge := NewSwitchLounge(keyring)
+	defer lounge.Close()
+	registry := NewRemoteClientRegistry()
+	defer registry.Close()
+	for _, msg := range snapshot {
+		if err := lounge.Write(&entities.SwitchMessage{Payload: msg}); err != nil {
+			t.Fatalf("Late joiner rejected snapshot announcement: %v", err)
+		}
+		registry.Observe((<-lounge.Read()).Payload)
+	}
+	// 之后的保活不会清除已知的别名
+	registry.Observe(pack(4, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_REFRESH))
+
+	clients := registry.Clients()
+	if len(clients) != 1 {
+		t.Fatalf("Expected 1 known remote client, got %d", len(clients))
+	}
+	if clients[0].Alias != clientInfo.Alias || clients[0].DeviceType != clientInfo.DeviceType {
+		t.Errorf("Late joiner learned alias %q and device type %q, expected %q and %q", clients[0].Alias, clients[0].DeviceType, clientInfo.Alias, clientInfo.DeviceType)
+	}
+}
+
+// TestAnnouncementStoreClientChange 客户端信息版本变化时，新的发现包会替换保存的完整公告
+func TestAnnouncementStoreClientChange(t *testing.T) {
+	store := NewAnnouncementStore()
+	defer store.Close()
+	newMsg := func(seq uint64, kind switchdata.AnnouncementKind, clientVersion uint64, alias string) *switchdata.DiscoveryMessage {
+		return utils.PackLocalSendClientInfoIntoSwitchMessage(&entities.LocalSendClientInfo{Alias: alias, Fingerprint: "fingerprint"}, "origin", seq, net.ParseIP("192.168.1.10"), kind, clientVersion).Payload
+	}
+	store.Put(newMsg(1, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE, 1, "Old"))
+	// 更旧的发现包被忽略
+	store.Put(newMsg(1, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE, 2, "Stale"))
+	store.Put(newMsg(2, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE, 2, "New"))
+	store.Put(newMsg(3, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_REFRESH, 2, ""))
+
+	snapshot := store.Snapshot("late")
+	if len(snapshot) != 1 || snapshot[0].Alias != "New" {
+		t.Fatalf("Expected snapshot to contain the new announcement, got %v", snapshot)
+	}
+
+	// 撤回后不再包含在快照中
+	store.Put(newMsg(4, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_WITHDRAW, 2, ""))
+	if snapshot := store.Snapshot("late"); len(snapshot) != 0 {
+		t.Errorf("Expected withdrawn client to be left out of snapshot, got %v", snapshot)
+	}
+}
@@ -1,8 +1,17 @@
 package services
 
-import "sync/atomic"
+import (
+	"sync/atomic"
+	"time"
+)
 
 // services 包初始化语句
 
 // services 包内全局的发现消息序号，为每个发现消息分配唯一序号
-var globalDiscoverySeq atomic.Uint64
\ No newline at end of file
+var globalDiscoverySeq atomic.Uint64
+
+func init() {
+	// 节点 ID 在重启后保持不变，序号从当前时间 (微秒) 开始，保证重启后的序号比之前发出的都大，
+	// 不会被其他节点的序列号窗口当作重放丢弃
+	globalDiscoverySeq.Store(uint64(time.Now().UnixMicro()))
+}
@@ -0,0 +1,130 @@
+package services
+
+// 链路健康模块
+//
+// 双方定期互发 ping，对端原样带回序号回复 pong，据此测量每条链路的往返时间 (RTT)、抖动和丢失的 ping 数，
+// 并得出链路的健康状态: 正常 (healthy)、降级 (degraded)、断开 (dead)，断开的链路会被关闭
+
+import (
+	"sync"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+)
+
+// 链路健康状态
+const (
+	// 正常
+	LinkHealthHealthy = "healthy"
+	// 降级: 有 ping 丢失或往返时间过长
+	LinkHealthDegraded = "degraded"
+	// 断开: 连续丢失过多 ping
+	LinkHealthDead = "dead"
+)
+
+// LinkHealthStats 链路健康统计信息
+type LinkHealthStats struct {
+	// 健康状态
+	State string
+	// 平滑往返时间
+	RTT time.Duration
+	// 最近一次的往返时间
+	LastRTT time.Duration
+	// 往返时间的抖动
+	Jitter time.Duration
+	// 已发出的 ping 数
+	PingsSent uint64
+	// 收到的 pong 数
+	PongsReceived uint64
+	// 累计丢失的 ping 数
+	MissedPings uint64
+	// 连续丢失的 ping 数
+	ConsecutiveMissedPings int
+}
+
+// LinkHealth 记录单条链路的 ping / pong 情况，可并发访问
+type LinkHealth struct {
+	mutex sync.Mutex
+	stats LinkHealthStats
+	// 等待 pong 的 ping 序号及其发出时间
+	pendingSeq    uint64
+	pendingSentAt time.Time
+	pending       bool
+}
+
+// NewLinkHealth 创建一个新的链路健康记录
+func NewLinkHealth() *LinkHealth {
+	return &LinkHealth{
+		stats: LinkHealthStats{State: LinkHealthHealthy},
+	}
+}
+
+// PingSent 记录发出了一个新的 ping，返回其序号
+//
+// 如果上一个 ping 还没有收到 pong，则记为丢失；健康状态因此改变时 changed 为 true
+//
+// now: 发出时间
+func (lh *LinkHealth) PingSent(now time.Time) (seq uint64, changed bool) {
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+	if lh.pending {
+		lh.stats.MissedPings++
+		lh.stats.ConsecutiveMissedPings++
+	}
+	lh.stats.PingsSent++
+	lh.pendingSeq = lh.stats.PingsSent
+	lh.pendingSentAt = now
+	lh.pending = true
+	return lh.pendingSeq, lh.updateStateLocked()
+}
+
+// PongReceived 记录收到了一个 pong，并据此更新往返时间和抖动
+//
+// 只接受对最近一个 ping 的回复，过期或未知序号的 pong 会被忽略 (ok 为 false)；健康状态因此改变时 changed 为 true
+//
+// seq: pong 带回的 ping 序号
+// now: 收到时间
+func (lh *LinkHealth) PongReceived(seq uint64, now time.Time) (ok bool, changed bool) {
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+	if !lh.pending || seq != lh.pendingSeq {
+		return false, false
+	}
+	lh.pending = false
+	rtt := now.Sub(lh.pendingSentAt)
+	if lh.stats.PongsReceived == 0 {
+		lh.stats.RTT = rtt
+	} else {
+		// 平滑往返时间 (RFC 6298) 和抖动 (RFC 3550)
+		lh.stats.RTT += (rtt - lh.stats.RTT) / 8
+		lh.stats.Jitter += ((rtt - lh.stats.LastRTT).Abs() - lh.stats.Jitter) / 16
+	}
+	lh.stats.LastRTT = rtt
+	lh.stats.PongsReceived++
+	lh.stats.ConsecutiveMissedPings = 0
+	return true, lh.updateStateLocked()
+}
+
+// updateStateLocked 根据统计信息更新健康状态，返回状态是否改变，调用方需持有锁
+func (lh *LinkHealth) updateStateLocked() bool {
+	newState := LinkHealthHealthy
+	switch {
+	case lh.stats.ConsecutiveMissedPings >= configs.LinkDeadMissedPings:
+		newState = LinkHealthDead
+	case lh.stats.ConsecutiveMissedPings >= configs.LinkDegradedMissedPings,
+		lh.stats.RTT > configs.LinkDegradedRTT*time.Millisecond:
+		newState = LinkHealthDegraded
+	}
+	if newState == lh.stats.State {
+		return false
+	}
+	lh.stats.State = newState
+	return true
+}
+
+// Stats 返回当前的链路健康统计信息
+func (lh *LinkHealth) Stats() LinkHealthStats {
+	lh.mutex.Lock()
+	defer lh.mutex.Unlock()
+	return lh.stats
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestLinkHealth 按给定的往返时间和丢失的 pong 驱动链路健康记录，检查健康状态的变化、平滑往返时间、抖动和丢失的 ping 数
+func TestLinkHealth(t *testing.T) {
+	// lost 表示该 ping 没有收到 pong
+	const lost = time.Duration(-1)
+	ms := time.Millisecond
+	tests := []struct {
+		name string
+		// 每个 ping 的往返时间
+		rtts []time.Duration
+		// 健康状态依次变成的值
+		transitions []string
+		rtt         time.Duration
+		jitter      time.Duration
+		missed      uint64
+		consecutive int
+	}{
+		{name: "steady", rtts: []time.Duration{100 * ms, 100 * ms, 100 * ms}, rtt: 100 * ms},
+		{
+			name: "smoothed rtt and jitter",
+			rtts: []time.Duration{100 * ms, 180 * ms, 100 * ms},
+			// 110ms = 100ms + (180ms - 100ms) / 8，108.75ms = 110ms + (100ms - 110ms) / 8
+			rtt: 108750 * time.Microsecond,
+			// 5ms = 80ms / 16，9.6875ms = 5ms + (80ms - 5ms) / 16
+			jitter: 9687500 * time.Nanosecond,
+		},
+		{
+			name: "missed pongs degrade then kill the link",
+			// 丢失的 pong 在发出下一个 ping 时才记为丢失
+			rtts:        []time.Duration{100 * ms, lost, lost, lost, lost},
+			transitions: []string{LinkHealthDegraded, LinkHealthDead},
+			rtt:         100 * ms,
+			missed:      3,
+			consecutive: 3,
+		},
+		{
+			name:        "recovers after a missed pong",
+			rtts:        []time.Duration{100 * ms, lost, 100 * ms},
+			transitions: []string{LinkHealthDegraded, LinkHealthHealthy},
+			rtt:         100 * ms,
+			missed:      1,
+		},
+		{
+			name: "slow link degraded until srtt recovers",
+			rtts: []time.Duration{600 * ms, 100 * ms, 100 * ms},
+			// 537.5ms 仍然超过阈值，482.8125ms 恢复正常
+			transitions: []string{LinkHealthDegraded, LinkHealthHealthy},
+			rtt:         482812500 * time.Nanosecond,
+			// 31.25ms = 500ms / 16，29.296875ms = 31.25ms + (0 - 31.25ms) / 16
+			jitter: 29296875 * time.Nanosecond,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lh := NewLinkHealth()
+			now := time.Now()
+			var transitions []string
+			record := func(changed bool) {
+				if changed {
+					transitions = append(transitions, lh.Stats().State)
+				}
+			}
+			for _, rtt := range tt.rtts {
+				seq, changed := lh.PingSent(now)
+				record(changed)
+				if rtt != lost {
+					ok, changed := lh.PongReceived(seq, now.Add(rtt))
+					if !ok {
+						t.Fatalf("Pong for ping %d was not accepted", seq)
+					}
+					record(changed)
+				}
+				now = now.Add(5 * time.Second)
+			}
+			stats := lh.Stats()
+			if !slices.Equal(transitions, tt.transitions) {
+				t.Errorf("Health transitions %v, expected %v", transitions, tt.transitions)
+			}
+			if stats.RTT != tt.rtt || stats.Jitter != tt.jitter {
+				t.Errorf("SRTT %v and jitter %v, expected %v and %v", stats.RTT, stats.Jitter, tt.rtt, tt.jitter)
+			}
+			if stats.MissedPings != tt.missed || stats.ConsecutiveMissedPings != tt.consecutive {
+				t.Errorf("Missed %d pings (%d consecutive), expected %d (%d consecutive)", stats.MissedPings, stats.ConsecutiveMissedPings, tt.missed, tt.consecutive)
+			}
+			if stats.PingsSent != uint64(len(tt.rtts)) {
+				t.Errorf("Sent %d pings, expected %d", stats.PingsSent, len(tt.rtts))
+			}
+		})
+	}
+}
+
+// TestLinkHealthIgnoresStalePong 只接受对最近一个 ping 的回复，过期、重复和未知序号的 pong 被忽略
+func TestLinkHealthIgnoresStalePong(t *testing.T) {
+	lh := NewLinkHealth()
+	now := time.Now()
+	first, _ := lh.PingSent(now)
+	second, _ := lh.PingSent(now.Add(5 * time.Second))
+	if ok, _ := lh.PongReceived(first, now.Add(6*time.Second)); ok {
+		t.Errorf("Pong for an earlier ping was accepted")
+	}
+	if ok, _ := lh.PongReceived(second+1, now.Add(6*time.Second)); ok {
+		t.Errorf("Pong for an unknown ping was accepted")
+	}
+	if ok, _ := lh.PongReceived(second, now.Add(5*time.Second+50*time.Millisecond)); !ok {
+		t.Fatalf("Pong for the latest ping was not accepted")
+	}
+	if ok, _ := lh.PongReceived(second, now.Add(6*time.Second)); ok {
+		t.Errorf("Duplicate pong was accepted")
+	}
+	if stats := lh.Stats(); stats.PongsReceived != 1 || stats.RTT != 50*time.Millisecond {
+		t.Errorf("Got %d pongs with SRTT %v, expected 1 pong with SRTT 50ms", stats.PongsReceived, stats.RTT)
+	}
+}
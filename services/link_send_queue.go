@@ -0,0 +1,125 @@
+package services
+
+// 链路发送队列模块
+//
+// 每条链路都有自己的有界发送队列，转发器只会以不阻塞的方式放入消息，队列已满时按配置的策略丢弃消息或断开过慢的对端，
+// 这样一条卡住的链路不会拖住整个节点的转发和注册；写入和关闭发送通道只需持有这条链路自己的锁，不会和其他链路互相等待；
+// 各链路丢弃的消息数会定时记录到日志中
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+)
+
+// LinkSendQueue 单条链路发送队列的状态，保护发送通道的写入和关闭，并记录丢弃的消息数，可并发访问
+type LinkSendQueue struct {
+	// 写入方和关闭发送通道时持有，发送协程取出消息时不需要
+	mutex sync.Mutex
+	// 发送通道是否已关闭
+	closed bool
+	// 为新消息腾出位置而丢弃的队列中最旧的消息数
+	numDroppedOldest atomic.Uint64
+	// 没能放入队列而丢弃的新消息数
+	numDroppedNewest atomic.Uint64
+	// 上一次记录日志时的丢弃总数，只由记录日志的协程访问
+	lastLoggedDrops uint64
+}
+
+// droppedOldest 记录丢弃了一条队列中最旧的消息
+func (qs *LinkSendQueue) droppedOldest() {
+	qs.numDroppedOldest.Add(1)
+}
+
+// droppedNewest 记录丢弃了一条新消息
+func (qs *LinkSendQueue) droppedNewest() {
+	qs.numDroppedNewest.Add(1)
+}
+
+// Dropped 返回发送队列丢弃的消息总数
+func (qs *LinkSendQueue) Dropped() uint64 {
+	return qs.numDroppedOldest.Load() + qs.numDroppedNewest.Load()
+}
+
+// push 把消息放入发送通道，不会阻塞，发送通道已满时按配置的策略处理，只持有这条链路自己的锁
+//
+// 返回消息是否进入了发送通道，以及是否需要断开过慢的对端 (由调用方断开)；发送通道已关闭时都返回 false
+//
+// sendChan: 链路的发送通道
+// msg: 交换消息
+func (qs *LinkSendQueue) push(sendChan chan *entities.SwitchMessage, msg *entities.SwitchMessage) (bool, bool) {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+	if qs.closed {
+		return false, false
+	}
+	select {
+	case sendChan <- msg:
+		return true, false
+	default:
+	}
+	switch configs.GetSendQueuePolicy() {
+	case configs.SendQueuePolicyDropNewest:
+		qs.droppedNewest()
+		return false, false
+	case configs.SendQueuePolicyDisconnect:
+		qs.droppedNewest()
+		return false, true
+	default:
+		// 丢弃最旧的消息；写入方都持有锁，发送协程只会从队列中取出消息，因此腾出位置后一定能放入
+		select {
+		case <-sendChan:
+			qs.droppedOldest()
+		default:
+		}
+		select {
+		case sendChan <- msg:
+			return true, false
+		default:
+			qs.droppedNewest()
+			return false, false
+		}
+	}
+}
+
+// close 关闭发送通道，之后放入的消息都会被丢弃
+//
+// sendChan: 链路的发送通道
+func (qs *LinkSendQueue) close(sendChan chan *entities.SwitchMessage) {
+	qs.mutex.Lock()
+	defer qs.mutex.Unlock()
+	if qs.closed {
+		return
+	}
+	qs.closed = true
+	close(sendChan)
+}
+
+// setUpSendQueueMonitor 定时记录各链路发送队列新丢弃的消息数，直到收到退出信号
+//
+// tcpConnHub: 维护 TCP 连接的管理器
+// sigCtx: 中断信号上下文
+func setUpSendQueueMonitor(tcpConnHub *TCPConnectionHub, sigCtx context.Context) {
+	ticker := time.NewTicker(configs.SendQueueDropLogInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-sigCtx.Done():
+			return
+		}
+		for _, cwc := range tcpConnHub.GetAllConnections() {
+			dropped := cwc.SendQueue.Dropped()
+			if dropped == cwc.SendQueue.lastLoggedDrops {
+				continue
+			}
+			slog.Warn("Dropped messages for slow peer switch", "remoteAddr", cwc.Conn.RemoteAddr().String(), "peerNodeName", cwc.Peer.NodeName, "peerSite", cwc.Peer.Site, "policy", configs.GetSendQueuePolicy(), "dropped", dropped-cwc.SendQueue.lastLoggedDrops, "droppedOldest", cwc.SendQueue.numDroppedOldest.Load(), "droppedNewest", cwc.SendQueue.numDroppedNewest.Load(), "queued", len(cwc.SendChan))
+			cwc.SendQueue.lastLoggedDrops = dropped
+		}
+	}
+}
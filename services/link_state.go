@@ -0,0 +1,293 @@
+package services
+
+// 链路状态模块
+//
+// 每个节点定期 (以及链路变化时) 生成自己的链路状态，即当前和哪些节点之间有链路，并在支持的节点之间泛洪；
+// 各节点据此得到整个网络的拓扑，对每个发起节点计算以其为根的最短路径树 (相同距离时取节点 ID 较小者为父节点)，
+// 所有节点算出的树相同，发现包只需沿树转发给树上的子节点，不会再在冗余链路上发送多余的副本
+//
+// 链路状态由生成节点用节点身份签名，收到后按发现包同样的信任规则校验，任何节点都不能伪造其他节点的链路状态
+//
+// 拓扑刚变化、链路状态还没有稳定时，或者涉及到不在拓扑中的节点 (比如不支持链路状态的旧版本节点) 时，仍然泛洪，
+// 链路断开后拓扑随之更新，冗余链路会自动成为新的转发树的一部分
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// linkStateEntry 一个节点的链路状态
+type linkStateEntry struct {
+	state *switchdata.LinkState
+	// 收到的时间
+	receivedAt time.Time
+}
+
+// LinkStateDatabase 保存网络中各节点最新的链路状态，并据此计算转发树，可并发访问
+type LinkStateDatabase struct {
+	mutex sync.Mutex
+	// 本节点唯一标识符
+	nodeId string
+	// 本节点链路状态的序列号
+	seq uint64
+	// 校验收到的链路状态签名的节点公钥信任管理器，未设置时不接受收到的链路状态
+	keyring *NodeKeyring
+	// 节点 ID -> 最新的链路状态
+	entries map[string]*linkStateEntry
+	// 拓扑最近一次变化的时间
+	changedAt time.Time
+	// 发起节点 ID -> (节点 ID -> 转发树上的父节点 ID)，拓扑变化时清空
+	trees       map[string]map[string]string
+	closeSignal chan struct{} // 关闭信号，让相应协程退出
+	closed      bool          // 标记是否关闭
+}
+
+// NewLinkStateDatabase 创建一个新的链路状态数据库
+//
+// nodeId: 本节点唯一标识符
+func NewLinkStateDatabase(nodeId string) *LinkStateDatabase {
+	db := LinkStateDatabase{
+		nodeId: nodeId,
+		// 以当前时间作为初始序列号，重启后生成的链路状态仍然比之前的新
+		seq:         uint64(time.Now().UnixMicro()),
+		entries:     make(map[string]*linkStateEntry),
+		changedAt:   time.Now(),
+		trees:       make(map[string]map[string]string),
+		closeSignal: make(chan struct{}),
+	}
+	// 定时清理过期的链路状态
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				now := time.Now()
+				db.mutex.Lock()
+				for nodeId, entry := range db.entries {
+					if nodeId != db.nodeId && now.Sub(entry.receivedAt) > configs.LinkStateMaxAge*time.Second {
+						delete(db.entries, nodeId)
+						db.topologyChangedLocked(now)
+					}
+				}
+				db.mutex.Unlock()
+			case <-db.closeSignal:
+				return
+			}
+		}
+	}()
+	return &db
+}
+
+// SetNodeKeyring 设置校验收到的链路状态签名的节点公钥信任管理器，需要在建立任何链路之前设置
+//
+// keyring: 节点公钥信任管理器
+func (db *LinkStateDatabase) SetNodeKeyring(keyring *NodeKeyring) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.keyring = keyring
+}
+
+// topologyChangedLocked 记录拓扑发生了变化，调用方需持有锁
+func (db *LinkStateDatabase) topologyChangedLocked(now time.Time) {
+	db.changedAt = now
+	clear(db.trees)
+}
+
+// LocalLinkState 生成本节点新的链路状态，签名后记录下来
+//
+// neighbors: 当前和本节点之间有链路的节点 ID
+// identity: 本节点身份，用于对链路状态签名
+func (db *LinkStateDatabase) LocalLinkState(neighbors []string, identity *utils.NodeIdentity) *switchdata.LinkState {
+	neighbors = slices.Clone(neighbors)
+	slices.Sort(neighbors)
+	now := time.Now()
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	db.seq++
+	state := &switchdata.LinkState{
+		NodeId:    db.nodeId,
+		Seq:       db.seq,
+		Neighbors: neighbors,
+	}
+	identity.SignLinkState(state)
+	if existing, exists := db.entries[db.nodeId]; !exists || !slices.Equal(existing.state.Neighbors, neighbors) {
+		db.topologyChangedLocked(now)
+	}
+	db.entries[db.nodeId] = &linkStateEntry{state: state, receivedAt: now}
+	return proto.Clone(state).(*switchdata.LinkState)
+}
+
+// Update 记录收到的链路状态，只有签名可信且比已记录的更新时才会接受，返回是否接受 (接受的链路状态需要继续泛洪)
+//
+// state: 收到的链路状态
+func (db *LinkStateDatabase) Update(state *switchdata.LinkState) bool {
+	if state.NodeId == "" || state.NodeId == db.nodeId {
+		// 本节点的链路状态只由本节点生成
+		return false
+	}
+	now := time.Now()
+	db.mutex.Lock()
+	if db.closed || db.keyring == nil {
+		db.mutex.Unlock()
+		return false
+	}
+	existing, exists := db.entries[state.NodeId]
+	if exists && existing.state.Seq >= state.Seq {
+		db.mutex.Unlock()
+		return false
+	}
+	keyring := db.keyring
+	db.mutex.Unlock()
+	// 校验签名可能需要写入已知节点文件，不持有锁
+	if err := keyring.VerifyLinkState(state); err != nil {
+		slog.Debug("Rejected link state with untrusted signature", "nodeId", state.NodeId, "seq", state.Seq, "error", err)
+		return false
+	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.closed {
+		return false
+	}
+	existing, exists = db.entries[state.NodeId]
+	if exists && existing.state.Seq >= state.Seq {
+		return false
+	}
+	neighbors := slices.Clone(state.Neighbors)
+	slices.Sort(neighbors)
+	if !exists || !slices.Equal(existing.state.Neighbors, neighbors) {
+		db.topologyChangedLocked(now)
+	}
+	db.entries[state.NodeId] = &linkStateEntry{
+		// 保留签名，推送给新的链路时对端可以同样校验
+		state: &switchdata.LinkState{
+			NodeId:    state.NodeId,
+			Seq:       state.Seq,
+			Neighbors: neighbors,
+			PublicKey: slices.Clone(state.PublicKey),
+			Signature: slices.Clone(state.Signature),
+		},
+		receivedAt: now,
+	}
+	return true
+}
+
+// LinkStates 返回所有仍然有效的链路状态的副本，用于推送给新的链路
+func (db *LinkStateDatabase) LinkStates() []*switchdata.LinkState {
+	now := time.Now()
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	states := make([]*switchdata.LinkState, 0, len(db.entries))
+	for _, entry := range db.entries {
+		if now.Sub(entry.receivedAt) <= configs.LinkStateMaxAge*time.Second {
+			states = append(states, proto.Clone(entry.state).(*switchdata.LinkState))
+		}
+	}
+	return states
+}
+
+// linkedLocked 判断两个节点之间是否有链路，只有双方的链路状态中都有对方时才算，调用方需持有锁
+func (db *LinkStateDatabase) linkedLocked(a string, b string) bool {
+	entryA, existsA := db.entries[a]
+	entryB, existsB := db.entries[b]
+	if !existsA || !existsB {
+		return false
+	}
+	_, foundA := slices.BinarySearch(entryA.state.Neighbors, b)
+	_, foundB := slices.BinarySearch(entryB.state.Neighbors, a)
+	return foundA && foundB
+}
+
+// treeLocked 计算 (或从缓存取得) 以发起节点为根的最短路径树，调用方需持有锁
+//
+// 返回每个可达节点在树上的父节点 ID，根节点的父节点为空字符串
+func (db *LinkStateDatabase) treeLocked(originId string) map[string]string {
+	if tree, exists := db.trees[originId]; exists {
+		return tree
+	}
+	tree := map[string]string{originId: ""}
+	// 广度优先搜索，同一层按节点 ID 排序，保证每个节点都取节点 ID 最小的上一层节点作为父节点
+	level := []string{originId}
+	for len(level) > 0 {
+		var nextLevel []string
+		for _, nodeId := range level {
+			for _, neighborId := range db.entries[nodeId].state.Neighbors {
+				if _, visited := tree[neighborId]; visited || !db.linkedLocked(nodeId, neighborId) {
+					continue
+				}
+				tree[neighborId] = nodeId
+				nextLevel = append(nextLevel, neighborId)
+			}
+		}
+		slices.Sort(nextLevel)
+		level = nextLevel
+	}
+	db.trees[originId] = tree
+	return tree
+}
+
+// ShouldForward 判断由发起节点发出的发现包是否需要转发给某个相邻节点
+//
+// 不使用转发树、拓扑还没有稳定，或者无法从拓扑中确定时，总是需要转发 (即泛洪)
+//
+// originId: 发现包的发起节点 ID
+// neighborId: 相邻节点 ID
+func (db *LinkStateDatabase) ShouldForward(originId string, neighborId string) bool {
+	if configs.GetForwardingMode() != configs.ForwardingModeTree {
+		return true
+	}
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if time.Since(db.changedAt) < configs.LinkStateSettleTime*time.Second {
+		return true
+	}
+	if _, exists := db.entries[originId]; !exists {
+		return true
+	}
+	tree := db.treeLocked(originId)
+	_, selfInTree := tree[db.nodeId]
+	parentId, neighborInTree := tree[neighborId]
+	if !selfInTree || !neighborInTree {
+		return true
+	}
+	return parentId == db.nodeId
+}
+
+// Close 关闭链路状态数据库
+func (db *LinkStateDatabase) Close() {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if db.closed {
+		return
+	}
+	close(db.closeSignal)
+	db.closed = true
+}
+
+// setUpLinkStateAnnouncer 在链路变化时以及定时生成本节点的链路状态，并发给所有对端，直到收到退出信号
+//
+// tcpConnHub: 维护 TCP 连接的管理器
+// nodeIdentity: 本节点身份，用于对链路状态签名
+// sigCtx: 中断信号上下文
+func setUpLinkStateAnnouncer(tcpConnHub *TCPConnectionHub, nodeIdentity *utils.NodeIdentity, sigCtx context.Context) {
+	ticker := time.NewTicker(configs.LinkStateInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-tcpConnHub.LinkChanged():
+		case <-sigCtx.Done():
+			return
+		}
+		state := tcpConnHub.LinkState().LocalLinkState(tcpConnHub.NeighborIds(), nodeIdentity)
+		tcpConnHub.BroadcastLinkState(state, "")
+	}
+}
@@ -0,0 +1,211 @@
+package services
+
+import (
+	"maps"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+)
+
+// testTopology 测试用的带环拓扑: A-B-C-D-A 构成环，E 同时连接 C 和 D
+var testTopology = map[string][]string{
+	"A": {"B", "D"},
+	"B": {"A", "C"},
+	"C": {"B", "D", "E"},
+	"D": {"A", "C", "E"},
+	"E": {"C", "D"},
+}
+
+// newTestLinkStateDatabase 创建一个以首次信任模式校验链路状态签名的链路状态数据库
+func newTestLinkStateDatabase(t *testing.T, nodeId string, identity *utils.NodeIdentity) *LinkStateDatabase {
+	keyring, err := NewNodeKeyring(filepath.Join(t.TempDir(), "known-nodes"), identity)
+	if err != nil {
+		t.Fatalf("Failed to create node keyring: %v", err)
+	}
+	db := NewLinkStateDatabase(nodeId)
+	db.SetNodeKeyring(keyring)
+	t.Cleanup(db.Close)
+	return db
+}
+
+// newTestLinkStateDatabases 为拓扑中的每个节点创建链路状态数据库，并交换所有节点的链路状态
+func newTestLinkStateDatabases(t *testing.T, topology map[string][]string) map[string]*LinkStateDatabase {
+	prevMode := configs.GetForwardingMode()
+	configs.SetForwardingMode(configs.ForwardingModeTree)
+	t.Cleanup(func() { configs.SetForwardingMode(prevMode) })
+
+	dbs := make(map[string]*LinkStateDatabase, len(topology))
+	identities := make(map[string]*utils.NodeIdentity, len(topology))
+	for nodeId := range topology {
+		identities[nodeId] = newTestNodeIdentity(t)
+		dbs[nodeId] = newTestLinkStateDatabase(t, nodeId, identities[nodeId])
+	}
+	for nodeId, neighbors := range topology {
+		state := dbs[nodeId].LocalLinkState(neighbors, identities[nodeId])
+		for otherId, db := range dbs {
+			if otherId != nodeId {
+				db.Update(state)
+			}
+		}
+	}
+	// 跳过拓扑稳定所需的等待时间
+	for _, db := range dbs {
+		db.mutex.Lock()
+		db.changedAt = time.Now().Add(-2 * configs.LinkStateSettleTime * time.Second)
+		db.mutex.Unlock()
+	}
+	return dbs
+}
+
+// testTreeParent 返回节点在以发起节点为根的转发树上的父节点 ID
+func testTreeParent(db *LinkStateDatabase, originId string, nodeId string) string {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return db.treeLocked(originId)[nodeId]
+}
+
+// TestLinkStateTreeReachesEveryNodeOnce 在带环拓扑中按转发树转发时，每个节点恰好收到一次发现包
+func TestLinkStateTreeReachesEveryNodeOnce(t *testing.T) {
+	dbs := newTestLinkStateDatabases(t, testTopology)
+	for originId := range testTopology {
+		received := map[string]int{originId: 1}
+		// 模拟逐跳转发: 每个收到发现包的节点转发给除来源以外、ShouldForward 允许的相邻节点
+		type hop struct{ from, to string }
+		queue := []hop{}
+		for _, neighborId := range testTopology[originId] {
+			if dbs[originId].ShouldForward(originId, neighborId) {
+				queue = append(queue, hop{originId, neighborId})
+			}
+		}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			received[current.to]++
+			if received[current.to] > 1 {
+				// 重复收到的发现包会被去重，不再转发
+				continue
+			}
+			for _, neighborId := range testTopology[current.to] {
+				if neighborId != current.from && dbs[current.to].ShouldForward(originId, neighborId) {
+					queue = append(queue, hop{current.to, neighborId})
+				}
+			}
+		}
+		for nodeId := range testTopology {
+			if received[nodeId] != 1 {
+				t.Errorf("Node %s received the message from %s %d times, expected once", nodeId, originId, received[nodeId])
+			}
+		}
+	}
+}
+
+// TestLinkStateTreeTieBreak 距离相同时所有节点都选节点 ID 较小者作为父节点，算出的转发树相同
+func TestLinkStateTreeTieBreak(t *testing.T) {
+	dbs := newTestLinkStateDatabases(t, testTopology)
+	for originId := range testTopology {
+		var expected map[string]string
+		for nodeId, db := range dbs {
+			db.mutex.Lock()
+			tree := maps.Clone(db.treeLocked(originId))
+			db.mutex.Unlock()
+			if len(tree) != len(testTopology) {
+				t.Errorf("Tree of %s computed by %s has %d nodes, expected %d", originId, nodeId, len(tree), len(testTopology))
+			}
+			if expected == nil {
+				expected = tree
+			} else if !maps.Equal(tree, expected) {
+				t.Errorf("Tree of %s computed by %s is %v, expected %v", originId, nodeId, tree, expected)
+			}
+		}
+	}
+	// A 到 C 经过 B 和经过 D 的距离相同，取 B
+	if parentId := testTreeParent(dbs["E"], "A", "C"); parentId != "B" {
+		t.Errorf("Parent of C in the tree of A is %s, expected B", parentId)
+	}
+	// E 的树上 A 只和 D 相连，只能经过 D 到达
+	if parentId := testTreeParent(dbs["B"], "E", "A"); parentId != "D" {
+		t.Errorf("Parent of A in the tree of E is %s, expected D", parentId)
+	}
+}
+
+// TestLinkStateUnknownNodesFlood 发起节点或相邻节点不在拓扑中时退回泛洪
+func TestLinkStateUnknownNodesFlood(t *testing.T) {
+	dbs := newTestLinkStateDatabases(t, testTopology)
+	if !dbs["C"].ShouldForward("unknown", "B") {
+		t.Errorf("Message from unknown origin was not flooded")
+	}
+	if !dbs["C"].ShouldForward("A", "legacy") {
+		t.Errorf("Message was not flooded to a neighbor outside the topology")
+	}
+	// 拓扑中的节点仍按转发树转发: A 的树上 D 不是 C 的子节点
+	if dbs["C"].ShouldForward("A", "D") {
+		t.Errorf("Message from A was forwarded from C to D, which is not its child in the tree")
+	}
+}
+
+// TestLinkStateUpdateSignature 只接受由生成节点签名且公钥可信的链路状态，转发途中不能伪造或篡改
+func TestLinkStateUpdateSignature(t *testing.T) {
+	origin, neighbor := newTestNodeIdentity(t), newTestNodeIdentity(t)
+	originDb := newTestLinkStateDatabase(t, "origin", origin)
+	// signedBy 生成发起节点的链路状态，并用指定身份签名
+	signedBy := func(identity *utils.NodeIdentity, neighbors ...string) *switchdata.LinkState {
+		state := originDb.LocalLinkState(neighbors, origin)
+		identity.SignLinkState(state)
+		return state
+	}
+
+	receiver := newTestLinkStateDatabase(t, "receiver", newTestNodeIdentity(t))
+	first := signedBy(origin, "neighbor", "receiver")
+	if !receiver.Update(first) {
+		t.Fatalf("Expected link state signed by its origin to be accepted")
+	}
+	tests := []struct {
+		name   string
+		state  func() *switchdata.LinkState
+		accept bool
+	}{
+		{name: "newer state signed by origin", state: func() *switchdata.LinkState { return signedBy(origin, "receiver") }, accept: true},
+		{name: "unsigned", state: func() *switchdata.LinkState {
+			state := signedBy(origin, "receiver")
+			state.PublicKey, state.Signature = nil, nil
+			return state
+		}},
+		{name: "forged by neighbor", state: func() *switchdata.LinkState { return signedBy(neighbor, "neighbor") }},
+		{name: "neighbors tampered after signing", state: func() *switchdata.LinkState {
+			state := signedBy(origin, "receiver")
+			state.Neighbors = append(state.Neighbors, "neighbor")
+			return state
+		}},
+		{name: "seq tampered after signing", state: func() *switchdata.LinkState {
+			state := signedBy(origin, "receiver")
+			state.Seq++
+			return state
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if accepted := receiver.Update(tt.state()); accepted != tt.accept {
+				t.Errorf("Expected link state to be accepted: %v, got %v", tt.accept, accepted)
+			}
+		})
+	}
+
+	// 记录下来的链路状态保留签名，推送给新的链路后仍能通过校验
+	late := newTestLinkStateDatabase(t, "late", newTestNodeIdentity(t))
+	for _, state := range receiver.LinkStates() {
+		if !late.Update(state) {
+			t.Errorf("Link state of %s pushed to a new link was rejected", state.NodeId)
+		}
+	}
+
+	// 没有设置节点公钥信任管理器时不接受任何链路状态
+	unverified := NewLinkStateDatabase("unverified")
+	defer unverified.Close()
+	if unverified.Update(signedBy(origin, "receiver")) {
+		t.Errorf("Expected link state to be rejected without a node keyring")
+	}
+}
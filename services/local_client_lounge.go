@@ -14,12 +14,29 @@ import (
 type LocalClientInfoWithTTL struct {
 	info     *entities.LocalSendClientInfo
 	expireAt time.Time
+	// 客户端信息的版本，信息变化时增大
+	version uint64
+	// 信息变化后是否还没有发出过完整的公告
+	pendingAnnounce bool
+	// 最近一次发出完整公告的时间
+	announcedAt time.Time
+}
+
+// LocalClientAnnouncement 本地客户端信息及其版本，用于构造公告
+type LocalClientAnnouncement struct {
+	Info *entities.LocalSendClientInfo
+	// 客户端信息的版本
+	Version uint64
+	// 是否需要发出完整的公告: 信息变化后还没有发出过，或距上次完整公告已超过完整公告间隔
+	Changed bool
 }
 
 // LocalClientLounge 存放本地 LocalSend 客户端信息
 type LocalClientLounge struct {
 	mutex       sync.Mutex                         // 保护 clientInfos 的并发访问
 	clientInfos map[uint16]*LocalClientInfoWithTTL // key: 本地客户端监听的端口
+	generation  uint64                             // 本地客户端出现或信息变化的次数
+	expired     chan LocalClientAnnouncement       // 过期被移除的本地客户端
 	closeSignal chan struct{}                      // 关闭信号，让相应协程退出
 	closed      bool                               // 标记是否关闭
 }
@@ -28,6 +45,7 @@ type LocalClientLounge struct {
 func NewLocalClientLounge() *LocalClientLounge {
 	lcl := LocalClientLounge{
 		clientInfos: make(map[uint16]*LocalClientInfoWithTTL),
+		expired:     make(chan LocalClientAnnouncement, configs.LocalClientExpiredChanSize),
 		closeSignal: make(chan struct{}),
 		closed:      false,
 	}
@@ -44,6 +62,11 @@ func NewLocalClientLounge() *LocalClientLounge {
 				for port, infoWithTTL := range lcl.clientInfos {
 					if infoWithTTL.expireAt.Before(now) {
 						delete(lcl.clientInfos, port)
+						// 通知客户端已离开，来不及处理时丢弃
+						select {
+						case lcl.expired <- LocalClientAnnouncement{Info: infoWithTTL.info, Version: infoWithTTL.version}:
+						default:
+						}
 					}
 				}
 				lcl.mutex.Unlock()
@@ -56,18 +79,86 @@ func NewLocalClientLounge() *LocalClientLounge {
 }
 
 // Add 添加或更新本地客户端信息，如果已经存在则更新其过期时间
-func (lcl *LocalClientLounge) Add(info *entities.LocalSendClientInfo) {
+//
+// 返回客户端信息的版本，以及客户端是否是新出现的或信息有变化 (此时版本会增大)
+func (lcl *LocalClientLounge) Add(info *entities.LocalSendClientInfo) (uint64, bool) {
 	lcl.mutex.Lock()
 	defer lcl.mutex.Unlock()
 	if lcl.closed {
-		return
+		return 0, false
 	}
+	expireAt := time.Now().Add(time.Duration(configs.GetLocalClientInfoCacheLifetime()) * time.Second) // 更新信息有效期
+	if existing, exists := lcl.clientInfos[info.Port]; exists && *existing.info == *info {
+		// 信息没有变化，只更新有效期
+		existing.expireAt = expireAt
+		return existing.version, false
+	}
+	// 版本取当前时间 (微秒)，重启后也会比之前的版本大
+	version := uint64(time.Now().UnixMicro())
 	lcl.clientInfos[info.Port] = &LocalClientInfoWithTTL{
-		info:     info,
-		expireAt: time.Now().Add(time.Duration(configs.GetLocalClientInfoCacheLifetime()) * time.Second), // 更新信息有效期
+		info:            info,
+		expireAt:        expireAt,
+		version:         version,
+		pendingAnnounce: true,
+	}
+	lcl.generation++
+	return version, true
+}
+
+// MarkAnnounced 标记本地客户端当前版本的信息已经发出过完整的公告
+//
+// port: 本地客户端监听的端口
+// version: 已公告的客户端信息版本
+func (lcl *LocalClientLounge) MarkAnnounced(port uint16, version uint64) {
+	lcl.mutex.Lock()
+	defer lcl.mutex.Unlock()
+	if infoWithTTL, exists := lcl.clientInfos[port]; exists && infoWithTTL.version == version {
+		infoWithTTL.pendingAnnounce = false
+		infoWithTTL.announcedAt = time.Now()
 	}
 }
 
+// Announcements 获取所有现有本地客户端信息及其版本
+//
+// 信息没有变化的客户端每隔完整公告间隔也需要再发出一次完整的公告，让只收到过保活的节点 (例如之后才加入的节点) 也能得知其完整信息
+//
+// markAnnounced: 是否同时把需要发出完整公告的客户端标记为已经发出过完整的公告
+func (lcl *LocalClientLounge) Announcements(markAnnounced bool) []LocalClientAnnouncement {
+	lcl.mutex.Lock()
+	defer lcl.mutex.Unlock()
+	if lcl.closed {
+		return nil
+	}
+	now := time.Now()
+	fullAnnouncementInterval := time.Duration(configs.GetFullAnnouncementInterval()) * time.Second
+	announcements := make([]LocalClientAnnouncement, 0, len(lcl.clientInfos))
+	for _, infoWithTTL := range lcl.clientInfos {
+		changed := infoWithTTL.pendingAnnounce || now.Sub(infoWithTTL.announcedAt) >= fullAnnouncementInterval
+		announcements = append(announcements, LocalClientAnnouncement{
+			Info:    infoWithTTL.info,
+			Version: infoWithTTL.version,
+			Changed: changed,
+		})
+		if markAnnounced && changed {
+			infoWithTTL.pendingAnnounce = false
+			infoWithTTL.announcedAt = now
+		}
+	}
+	return announcements
+}
+
+// Expired 返回一个通道，从中可以读取到因过期而被移除的本地客户端
+func (lcl *LocalClientLounge) Expired() <-chan LocalClientAnnouncement {
+	return lcl.expired
+}
+
+// Generation 返回本地客户端出现或信息变化的次数，变化后需要重新向远端客户端注册
+func (lcl *LocalClientLounge) Generation() uint64 {
+	lcl.mutex.Lock()
+	defer lcl.mutex.Unlock()
+	return lcl.generation
+}
+
 // SyncGet 获取所有现有本地客户端信息，以通道形式返回
 //
 // 注：在读取完毕前会锁住等候室，防止并发修改，因为本地客户端其实往往只有 1 个，这通常不会是很大问题
@@ -0,0 +1,93 @@
+package services
+
+// 网状链路模块
+//
+// 节点之间通过对端交换得知其他节点的服务地址后，定时从中随机挑选当前没有链路的节点主动连接，
+// 直到自动建立的网状链路达到上限；链路断开后空出的名额会在之后的轮次中用于连接其他节点，
+// 这样即使中心节点下线，各节点之间仍然能够互相发现，拓扑也能自行恢复
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+)
+
+// setUpMeshConnector 定时和已知节点建立网状链路，直到收到退出信号
+//
+// 配置的对端节点由对端连接模块负责，不会用于网状链路
+//
+// peers: 配置的对端 switch 节点
+// tcpConnHub: 维护 TCP 连接的管理器
+// switchDataChan: 传递交换数据的通道
+// sigCtx: 中断信号上下文
+func setUpMeshConnector(peers []entities.PeerEndpoint, tcpConnHub *TCPConnectionHub, switchDataChan chan *entities.SwitchMessage, sigCtx context.Context) {
+	maxMeshLinks := configs.GetMeshLinks()
+	if maxMeshLinks <= 0 {
+		return
+	}
+	slog.Info("Mesh links enabled", "maxMeshLinks", maxMeshLinks)
+	// 配置的对端节点地址
+	configuredPeers := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		configuredPeers[peer.String()] = true
+	}
+	var mutex sync.Mutex
+	// 正在维护的网状链路，key: 对端节点 ID
+	meshLinks := make(map[string]bool)
+	// 连接失败的节点及其失败时间
+	failedAt := make(map[string]time.Time)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ticker := time.NewTicker(configs.MeshConnectInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-sigCtx.Done():
+			return
+		}
+		candidates := tcpConnHub.MeshCandidates()
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+		now := time.Now()
+		mutex.Lock()
+		for _, candidate := range candidates {
+			if len(meshLinks) >= maxMeshLinks {
+				break
+			}
+			if configuredPeers[candidate.Address] || meshLinks[candidate.NodeId] || now.Sub(failedAt[candidate.NodeId]) < configs.MeshDialRetryInterval*time.Second {
+				continue
+			}
+			host, port, err := net.SplitHostPort(candidate.Address)
+			if err != nil {
+				continue
+			}
+			meshLinks[candidate.NodeId] = true
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				peer := entities.PeerEndpoint{Host: host, Port: port}
+				slog.Info("Connecting to switch node for mesh link", "peer", peer.String(), "peerNodeId", candidate.NodeId, "peerNodeName", candidate.NodeName, "peerSite", candidate.Site)
+				connected, _, err := connectPeer(peer, tcpConnHub, switchDataChan, sigCtx)
+				if err != nil {
+					slog.Debug("Failed to connect to switch node for mesh link", "peer", peer.String(), "error", err)
+				}
+				mutex.Lock()
+				defer mutex.Unlock()
+				delete(meshLinks, candidate.NodeId)
+				if !connected {
+					// 暂时不再尝试该节点
+					failedAt[candidate.NodeId] = time.Now()
+				}
+			}()
+		}
+		mutex.Unlock()
+	}
+}
@@ -137,8 +137,14 @@ func ListenLocalSendMulticast(nodeId string, networkType string, localSendAddr s
 				// 序号递增并 +1，原子操作
 				discoveryMsg.DiscoverySeq = globalDiscoverySeq.Add(1) - 1
 				discoveryMsg.DiscoveryTtl = configs.MaxDiscoveryMessageTTL
+				discoveryMsg.OriginTimestamp = time.Now().UnixMilli()
 				// 在包中塞入原始发送者 IP 地址
 				discoveryMsg.OriginalAddr = clientIP.String()
+				// 带上本节点的名称和站点，便于其他节点确认客户端信息来自哪里
+				discoveryMsg.OriginNodeName = configs.GetNodeName()
+				discoveryMsg.OriginSite = configs.GetNodeSite()
+				// LocalSend 组播包中的 announce 字段 (用户刷新了设备列表) 已被解析为征求 (SOLICIT) 标记
+				// 发现包类型和客户端信息版本由交换服务核心填写，之后再签名
 				// 包装成 SwitchMessage
 				switchMsg := &entities.SwitchMessage{
 					SourceAddr: remoteAddr,
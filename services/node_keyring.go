@@ -0,0 +1,194 @@
+package services
+
+// 节点公钥信任模块，判断发现包的发起节点公钥，以及链路状态的生成节点公钥是否可信
+//
+// 首次信任 (TOFU) 模式下，第一次见到某个发起节点时记住它的公钥 (并写入文件)，之后该节点的发现包都必须用同一公钥签名；
+// 记住的节点数达到上限后不再信任新的节点，防止持有密钥的节点用随机的节点 ID 无限制地占用内存和磁盘，或抢先占用真实节点的 ID；
+// 固定 (pinned) 模式下，只接受由预先配置的公钥签名的发现包，且每个公钥和第一次用它签名的发起节点 ID 绑定，
+// 防止持有受信任公钥的节点冒充其他节点，占用其序列号窗口或覆盖其客户端信息
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/somebottle/localsend-switch/configs"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+)
+
+// NodeKeyring 维护受信任的发起节点公钥
+type NodeKeyring struct {
+	mutex sync.Mutex
+	// 信任模式
+	mode string
+	// 首次信任模式下已知的节点公钥，key: 发起节点 switch_id
+	knownKeys map[string][]byte
+	// 固定模式下受信任的公钥 (Base64 编码) 集合
+	pinnedKeys map[string]bool
+	// 固定模式下受信任的公钥 (Base64 编码) 绑定的发起节点 switch_id，以及反过来的映射
+	pinnedKeyNodes map[string]string
+	pinnedNodeKeys map[string]string
+	// 记录已知节点公钥的文件路径
+	knownNodesPath string
+	// 本节点的身份公钥，本节点发起的发现包总是可信的
+	selfPublicKey []byte
+}
+
+// NewNodeKeyring 根据配置创建节点公钥信任管理器
+//
+// knownNodesPath: 首次信任模式下记录已知节点公钥的文件路径
+// selfIdentity: 本节点的身份
+func NewNodeKeyring(knownNodesPath string, selfIdentity *utils.NodeIdentity) (*NodeKeyring, error) {
+	nk := &NodeKeyring{
+		mode:           configs.GetNodeTrustMode(),
+		knownKeys:      make(map[string][]byte),
+		pinnedKeys:     make(map[string]bool),
+		pinnedKeyNodes: make(map[string]string),
+		pinnedNodeKeys: make(map[string]string),
+		knownNodesPath: knownNodesPath,
+		selfPublicKey:  selfIdentity.PublicKey(),
+	}
+	switch nk.mode {
+	case configs.NodeTrustModePinned:
+		for _, encoded := range configs.GetTrustedNodeKeys() {
+			publicKey, err := utils.DecodeNodePublicKey(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid trusted node key '%s': %w", encoded, err)
+			}
+			nk.pinnedKeys[utils.EncodeNodePublicKey(publicKey)] = true
+		}
+		if len(nk.pinnedKeys) == 0 {
+			return nil, errors.New("No trusted node keys configured in pinned mode")
+		}
+	case configs.NodeTrustModeTOFU:
+		if err := nk.loadKnownNodes(); err != nil {
+			return nil, err
+		}
+	}
+	return nk, nil
+}
+
+// loadKnownNodes 从文件载入已知节点公钥，每行格式: <switch_id> <Base64 公钥>
+func (nk *NodeKeyring) loadKnownNodes() error {
+	content, err := os.ReadFile(nk.knownNodesPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("Failed to read known nodes file '%s': %w", nk.knownNodesPath, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			slog.Warn("Ignored malformed line in known nodes file", "file", nk.knownNodesPath, "line", line)
+			continue
+		}
+		publicKey, err := utils.DecodeNodePublicKey(fields[1])
+		if err != nil {
+			slog.Warn("Ignored invalid public key in known nodes file", "file", nk.knownNodesPath, "line", line, "error", err)
+			continue
+		}
+		nk.knownKeys[fields[0]] = publicKey
+	}
+	return scanner.Err()
+}
+
+// rememberNode 记住一个新节点的公钥，并原子地重写记录已知节点公钥的文件，调用方需持有锁
+func (nk *NodeKeyring) rememberNode(switchId string, publicKey []byte) {
+	nk.knownKeys[switchId] = publicKey
+	switchIds := make([]string, 0, len(nk.knownKeys))
+	for knownId := range nk.knownKeys {
+		switchIds = append(switchIds, knownId)
+	}
+	slices.Sort(switchIds)
+	var content bytes.Buffer
+	for _, knownId := range switchIds {
+		fmt.Fprintf(&content, "%s %s\n", knownId, utils.EncodeNodePublicKey(nk.knownKeys[knownId]))
+	}
+	if err := utils.WriteFileAtomic(nk.knownNodesPath, content.Bytes(), 0644); err != nil {
+		slog.Warn("Failed to write known nodes file", "file", nk.knownNodesPath, "error", err)
+	}
+}
+
+// Verify 校验发现包的签名，并判断其发起节点公钥是否可信
+func (nk *NodeKeyring) Verify(msg *switchdata.DiscoveryMessage) error {
+	if nk.mode == configs.NodeTrustModeOff {
+		return nil
+	}
+	if err := utils.VerifyDiscoveryMessageSignature(msg); err != nil {
+		return err
+	}
+	return nk.trust(msg.SwitchId, msg.OriginPublicKey)
+}
+
+// VerifyLinkState 校验链路状态的签名，并判断其生成节点公钥是否可信
+//
+// 链路状态和发现包一样在节点之间泛洪，任何相邻节点都能转发，因此同样要求由生成节点签名，防止伪造其他节点的拓扑
+func (nk *NodeKeyring) VerifyLinkState(state *switchdata.LinkState) error {
+	if nk.mode == configs.NodeTrustModeOff {
+		return nil
+	}
+	if err := utils.VerifyLinkStateSignature(state); err != nil {
+		return err
+	}
+	return nk.trust(state.NodeId, state.PublicKey)
+}
+
+// trust 判断节点的公钥是否可信，首次信任模式下会记住新节点的公钥
+//
+// switchId: 节点 ID
+// publicKey: 签名所用的公钥
+func (nk *NodeKeyring) trust(switchId string, publicKey []byte) error {
+	if bytes.Equal(publicKey, nk.selfPublicKey) {
+		// 本节点自己签名的
+		return nil
+	}
+	nk.mutex.Lock()
+	defer nk.mutex.Unlock()
+	switch nk.mode {
+	case configs.NodeTrustModePinned:
+		encodedKey := utils.EncodeNodePublicKey(publicKey)
+		if !nk.pinnedKeys[encodedKey] {
+			return fmt.Errorf("Public key of switch %s is not trusted", switchId)
+		}
+		boundNode, keyBound := nk.pinnedKeyNodes[encodedKey]
+		boundKey, nodeBound := nk.pinnedNodeKeys[switchId]
+		if !keyBound && !nodeBound {
+			// 第一次见到用该公钥签名的数据，绑定公钥和节点
+			nk.pinnedKeyNodes[encodedKey] = switchId
+			nk.pinnedNodeKeys[switchId] = encodedKey
+			slog.Info("Bound trusted public key to switch node", "switchId", switchId, "publicKey", encodedKey)
+			return nil
+		}
+		if boundNode != switchId || boundKey != encodedKey {
+			return fmt.Errorf("Public key of switch %s does not match the trusted key bound to it on first use", switchId)
+		}
+	default:
+		knownKey, exists := nk.knownKeys[switchId]
+		if !exists {
+			if len(nk.knownKeys) >= configs.GetMaxKnownNodes() {
+				return fmt.Errorf("Refused to trust new switch %s on first use, the number of known switch nodes has reached the limit (%d)", switchId, configs.GetMaxKnownNodes())
+			}
+			// 首次见到该节点，信任其公钥
+			nk.rememberNode(switchId, publicKey)
+			slog.Info("Trusted new switch node on first use", "switchId", switchId, "publicKey", utils.EncodeNodePublicKey(publicKey))
+			return nil
+		}
+		if !bytes.Equal(knownKey, publicKey) {
+			return fmt.Errorf("Public key of switch %s does not match the one trusted on first use", switchId)
+		}
+	}
+	return nil
+}
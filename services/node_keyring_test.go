@@ -0,0 +1,111 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+)
+
+// TestNodeKeyringMaxKnownNodes 首次信任模式下记住的节点数达到上限后拒绝新节点，已知节点不受影响，且记住的节点在重启后仍然有效
+func TestNodeKeyringMaxKnownNodes(t *testing.T) {
+	prevMaxKnownNodes := configs.GetMaxKnownNodes()
+	configs.SetMaxKnownNodes(2)
+	t.Cleanup(func() { configs.SetMaxKnownNodes(prevMaxKnownNodes) })
+
+	knownNodesPath := filepath.Join(t.TempDir(), "known-nodes")
+	keyring, err := NewNodeKeyring(knownNodesPath, newTestNodeIdentity(t))
+	if err != nil {
+		t.Fatalf("Failed to create node keyring: %v", err)
+	}
+	identities := make([]*utils.NodeIdentity, 3)
+	signed := func(i int) *switchdata.DiscoveryMessage {
+		msg := utils.PackLocalSendClientInfoIntoSwitchMessage(&entities.LocalSendClientInfo{Fingerprint: "fingerprint"}, fmt.Sprintf("origin-%d", i), 1, net.ParseIP("192.168.1.10"), switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE, 1).Payload
+		identities[i].SignDiscoveryMessage(msg)
+		return msg
+	}
+	for i := range identities {
+		identities[i] = newTestNodeIdentity(t)
+	}
+
+	for i := range 2 {
+		if err := keyring.Verify(signed(i)); err != nil {
+			t.Fatalf("Expected switch %d to be trusted on first use, got %v", i, err)
+		}
+	}
+	if err := keyring.Verify(signed(2)); err == nil {
+		t.Fatalf("Expected new switch to be rejected once the limit is reached")
+	}
+	if err := keyring.Verify(signed(0)); err != nil {
+		t.Errorf("Known switch was rejected once the limit is reached: %v", err)
+	}
+
+	// 重新载入文件后仍然记得之前信任的节点
+	reloaded, err := NewNodeKeyring(knownNodesPath, newTestNodeIdentity(t))
+	if err != nil {
+		t.Fatalf("Failed to reload node keyring: %v", err)
+	}
+	if len(reloaded.knownKeys) != 2 {
+		t.Fatalf("Expected 2 known switches after reload, got %d", len(reloaded.knownKeys))
+	}
+	for i := range 2 {
+		if err := reloaded.Verify(signed(i)); err != nil {
+			t.Errorf("Switch %d was not remembered after reload: %v", i, err)
+		}
+	}
+}
+
+// TestNodeKeyringPinnedBinding 固定模式下每个受信任的公钥只能代表第一次用它签名的发起节点，不能冒充其他节点
+func TestNodeKeyringPinnedBinding(t *testing.T) {
+	honest, impostor, untrusted := newTestNodeIdentity(t), newTestNodeIdentity(t), newTestNodeIdentity(t)
+	prevMode, prevKeys := configs.GetNodeTrustMode(), configs.GetTrustedNodeKeys()
+	configs.SetNodeTrustMode(configs.NodeTrustModePinned)
+	configs.SetTrustedNodeKeys([]string{utils.EncodeNodePublicKey(honest.PublicKey()), utils.EncodeNodePublicKey(impostor.PublicKey())})
+	t.Cleanup(func() {
+		configs.SetNodeTrustMode(prevMode)
+		configs.SetTrustedNodeKeys(prevKeys)
+	})
+	keyring, err := NewNodeKeyring(filepath.Join(t.TempDir(), "known-nodes"), newTestNodeIdentity(t))
+	if err != nil {
+		t.Fatalf("Failed to create node keyring: %v", err)
+	}
+	signed := func(identity *utils.NodeIdentity, switchId string) *switchdata.DiscoveryMessage {
+		msg := utils.PackLocalSendClientInfoIntoSwitchMessage(&entities.LocalSendClientInfo{Fingerprint: "fingerprint"}, switchId, 1, net.ParseIP("192.168.1.10"), switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE, 1).Payload
+		identity.SignDiscoveryMessage(msg)
+		return msg
+	}
+
+	if err := keyring.Verify(signed(honest, "honest")); err != nil {
+		t.Fatalf("Expected pinned key to be trusted, got %v", err)
+	}
+	if err := keyring.Verify(signed(impostor, "impostor")); err != nil {
+		t.Fatalf("Expected pinned key to be trusted, got %v", err)
+	}
+	tests := []struct {
+		name     string
+		identity *utils.NodeIdentity
+		switchId string
+		reject   bool
+	}{
+		{name: "same key and switch again", identity: honest, switchId: "honest"},
+		{name: "pinned key claims another known switch", identity: impostor, switchId: "honest", reject: true},
+		{name: "pinned key claims an unseen switch", identity: impostor, switchId: "unseen", reject: true},
+		{name: "untrusted key", identity: untrusted, switchId: "untrusted", reject: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := keyring.Verify(signed(tt.identity, tt.switchId))
+			if tt.reject && err == nil {
+				t.Errorf("Expected message from switch %s to be rejected", tt.switchId)
+			}
+			if !tt.reject && err != nil {
+				t.Errorf("Expected message from switch %s to be accepted, got %v", tt.switchId, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package services
+
+// 对端 switch 连接模块，负责按模式连接多个对端节点，处理重连退避和故障转移
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+	"github.com/somebottle/localsend-switch/utils"
+)
+
+// sleepWithContext 等待一段时间，如果期间收到中断信号则提前返回 false
+func sleepWithContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// peerRetryBackoff 计算第 retryCount 次重试前的等待时间
+func peerRetryBackoff(retryCount int) time.Duration {
+	return utils.BackoffWithJitter(configs.SwitchPeerConnectRetryBaseInterval*time.Second, configs.SwitchPeerConnectRetryMaxInterval*time.Second, retryCount)
+}
+
+// exceedsPeerMaxRetries 判断重试次数是否超出限制，并打印重试日志
+//
+// target: 重试的目标，用于日志
+// retryCount: 当前重试次数
+// interval: 本次重试前的等待时间
+func exceedsPeerMaxRetries(target string, retryCount int, interval time.Duration) bool {
+	maxRetries := configs.GetSwitchPeerConnectMaxRetries()
+	if maxRetries < 0 {
+		// 如果为负数则无限重试
+		slog.Info("Retrying to connect to peer switch", "peer", target, "interval", interval.String(), "retryCount", retryCount, "maxRetries", "unlimited")
+		return false
+	}
+	if retryCount > maxRetries {
+		return true
+	}
+	slog.Info("Retrying to connect to peer switch", "peer", target, "interval", interval.String(), "retryCount", retryCount, "maxRetries", maxRetries)
+	return false
+}
+
+// maintainPeer 持续连接并维护单个对端 switch 节点，断开后按指数退避重连
+//
+// 返回 true 表示已放弃连接该节点 (重试次数耗尽或出现无法恢复的错误)
+//
+// peer: 对端 switch 节点
+// tcpConnHub: 维护 TCP 连接的管理器
+// switchDataChan: 传递交换数据的通道
+// sigCtx: 中断信号上下文
+func maintainPeer(peer entities.PeerEndpoint, tcpConnHub *TCPConnectionHub, switchDataChan chan *entities.SwitchMessage, sigCtx context.Context) bool {
+	// 建立 TCP 连接重试计数器
+	var retryCount int
+	for {
+		connected, exit, err := connectPeer(peer, tcpConnHub, switchDataChan, sigCtx)
+		if err != nil {
+			slog.Error("Gave up connecting to peer switch", "peer", peer.String(), "error", err)
+			return true
+		}
+		if exit {
+			return sigCtx.Err() == nil
+		}
+		if connected {
+			// 成功建立过连接，重试计数重置
+			retryCount = 0
+		}
+		retryCount++
+		interval := peerRetryBackoff(retryCount)
+		if exceedsPeerMaxRetries(peer.String(), retryCount, interval) {
+			slog.Error("Exceeded maximum retries to connect to peer switch, gave up", "peer", peer.String(), "maxRetries", configs.GetSwitchPeerConnectMaxRetries())
+			return true
+		}
+		if !sleepWithContext(sigCtx, interval) {
+			return false
+		}
+	}
+}
+
+// maintainFailoverPeers 按优先级只连接第一个可用的对端 switch 节点，断开后从最高优先级开始重新尝试
+//
+// 每一轮会按优先级依次尝试所有节点，一轮内都连接失败才计为一次重试
+//
+// 返回 true 表示已放弃连接 (重试次数耗尽或所有节点都出现无法恢复的错误)
+//
+// peers: 按优先级排列的对端 switch 节点
+// tcpConnHub: 维护 TCP 连接的管理器
+// switchDataChan: 传递交换数据的通道
+// sigCtx: 中断信号上下文
+func maintainFailoverPeers(peers []entities.PeerEndpoint, tcpConnHub *TCPConnectionHub, switchDataChan chan *entities.SwitchMessage, sigCtx context.Context) bool {
+	// 不再尝试的节点
+	abandoned := make([]bool, len(peers))
+	var retryCount int
+	for {
+		var connectedInRound bool
+		var numAbandoned int
+		for i, peer := range peers {
+			if abandoned[i] {
+				numAbandoned++
+				continue
+			}
+			connected, exit, err := connectPeer(peer, tcpConnHub, switchDataChan, sigCtx)
+			if sigCtx.Err() != nil {
+				return false
+			}
+			if err != nil || exit {
+				slog.Error("Gave up connecting to peer switch, failing over to the next one", "peer", peer.String(), "error", err)
+				abandoned[i] = true
+				numAbandoned++
+				continue
+			}
+			if connected {
+				// 连接断开，从最高优先级的节点重新开始尝试
+				connectedInRound = true
+				break
+			}
+			slog.Debug("Peer switch unavailable, failing over to the next one", "peer", peer.String())
+		}
+		if numAbandoned == len(peers) {
+			return true
+		}
+		if connectedInRound {
+			retryCount = 0
+		}
+		retryCount++
+		interval := peerRetryBackoff(retryCount)
+		if exceedsPeerMaxRetries(fmt.Sprintf("%d peer(s) in failover mode", len(peers)), retryCount, interval) {
+			slog.Error("Exceeded maximum retries to connect to any peer switch, gave up", "maxRetries", configs.GetSwitchPeerConnectMaxRetries())
+			return true
+		}
+		if !sleepWithContext(sigCtx, interval) {
+			return false
+		}
+	}
+}
+
+// connectPeersOnce 按配置的模式连接到对端 switch 节点，直到放弃连接所有节点或收到退出信号
+//
+// 返回 true 表示已放弃连接所有对端节点
+//
+// peers: 按优先级排列的对端 switch 节点
+// tcpConnHub: 维护 TCP 连接的管理器
+// switchDataChan: 传递交换数据的通道
+// sigCtx: 中断信号上下文
+func connectPeersOnce(peers []entities.PeerEndpoint, tcpConnHub *TCPConnectionHub, switchDataChan chan *entities.SwitchMessage, sigCtx context.Context) bool {
+	if configs.GetPeerConnectMode() == configs.PeerConnectModeFailover {
+		slog.Info("Connecting to peer switches in failover mode", "numPeers", len(peers))
+		return maintainFailoverPeers(peers, tcpConnHub, switchDataChan, sigCtx)
+	}
+	slog.Info("Connecting to all peer switches", "numPeers", len(peers))
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	numGaveUp := 0
+	for _, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if maintainPeer(peer, tcpConnHub, switchDataChan, sigCtx) {
+				mutex.Lock()
+				numGaveUp++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return numGaveUp == len(peers)
+}
+
+// setUpPeerConnector 按配置的模式连接到对端 switch 节点，直到收到退出信号
+//
+// 放弃连接所有对端节点后本节点仍会继续运行 (TCP 服务和网状链路仍然可用)，并在退避等待后重新尝试整个对端节点列表
+//
+// peers: 按优先级排列的对端 switch 节点
+// tcpConnHub: 维护 TCP 连接的管理器
+// switchDataChan: 传递交换数据的通道
+// sigCtx: 中断信号上下文
+func setUpPeerConnector(peers []entities.PeerEndpoint, tcpConnHub *TCPConnectionHub, switchDataChan chan *entities.SwitchMessage, sigCtx context.Context) {
+	// 没有配置对端节点则不启动连接协程
+	if len(peers) == 0 {
+		slog.Info("Peer address not provided, switch forwarder will not be started")
+		return
+	}
+	// 放弃连接所有对端节点的轮数
+	var numGaveUp int
+	for {
+		if !connectPeersOnce(peers, tcpConnHub, switchDataChan, sigCtx) || sigCtx.Err() != nil {
+			return
+		}
+		numGaveUp++
+		interval := peerRetryBackoff(numGaveUp)
+		slog.Warn("Gave up connecting to all peer switches, will try the peer list again later", "numPeers", len(peers), "interval", interval.String())
+		if !sleepWithContext(sigCtx, interval) {
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+// 远端客户端注册记录模块
+//
+// 记录本机客户端在每个远端客户端 (按发起节点和客户端指纹区分) 上注册时的情况，
+// 只有远端客户端是新出现的或信息有变化、本机客户端有变化、远端客户端发出了征求 (SOLICIT)，或者距上次注册已超过重新注册间隔时，才需要再次注册
+
+import (
+	"sync"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+)
+
+// registrationRecord 在一个远端客户端上注册的记录
+type registrationRecord struct {
+	// 注册时远端客户端信息的版本
+	clientVersion uint64
+	// 注册时远端客户端的地址和端口
+	addr string
+	port int32
+	// 注册时本机客户端信息的变化次数
+	localGeneration uint64
+	// 上次注册的时间
+	registeredAt time.Time
+	// 最近一次收到该远端客户端发现包的时间
+	seenAt time.Time
+}
+
+// RegistrationTracker 记录本机客户端在各远端客户端上的注册情况
+type RegistrationTracker struct {
+	mutex       sync.Mutex
+	records     map[announcementKey]*registrationRecord
+	closeSignal chan struct{} // 关闭信号，让相应协程退出
+	closed      bool          // 标记是否关闭
+}
+
+// NewRegistrationTracker 创建一个新的远端客户端注册记录
+func NewRegistrationTracker() *RegistrationTracker {
+	rt := RegistrationTracker{
+		records:     make(map[announcementKey]*registrationRecord),
+		closeSignal: make(chan struct{}),
+	}
+	// 定时清理长时间没有收到发现包的远端客户端
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				now := time.Now()
+				rt.mutex.Lock()
+				for key, record := range rt.records {
+					if now.Sub(record.seenAt) > configs.RemoteClientRegistrationLifetime*time.Second {
+						delete(rt.records, key)
+					}
+				}
+				rt.mutex.Unlock()
+			case <-rt.closeSignal:
+				return
+			}
+		}
+	}()
+	return &rt
+}
+
+// ShouldRegister 判断是否需要在发现包对应的远端客户端上 (重新) 注册本机客户端，需要时会同时记录这次注册
+//
+// 征求 (SOLICIT) 总是需要立即响应，但距上次注册不足最短响应间隔时除外
+//
+// msg: 远端客户端的发现包
+// localGeneration: 本机客户端信息当前的变化次数
+func (rt *RegistrationTracker) ShouldRegister(msg *switchdata.DiscoveryMessage, localGeneration uint64) bool {
+	key := announcementKey{switchId: msg.SwitchId, fingerprint: msg.Fingerprint}
+	now := time.Now()
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if rt.closed {
+		return false
+	}
+	record, exists := rt.records[key]
+	if exists {
+		record.seenAt = now
+		reregisterInterval := time.Duration(configs.GetClientReregisterInterval()) * time.Second
+		unchanged := record.clientVersion == msg.ClientVersion && record.addr == msg.OriginalAddr && record.port == msg.Port && record.localGeneration == localGeneration
+		if msg.Solicit && unchanged {
+			if now.Sub(record.registeredAt) < configs.SolicitResponseMinInterval*time.Millisecond {
+				return false
+			}
+		} else if unchanged && (reregisterInterval <= 0 || now.Sub(record.registeredAt) < reregisterInterval) {
+			return false
+		}
+	}
+	rt.records[key] = &registrationRecord{
+		clientVersion:   msg.ClientVersion,
+		addr:            msg.OriginalAddr,
+		port:            msg.Port,
+		localGeneration: localGeneration,
+		registeredAt:    now,
+		seenAt:          now,
+	}
+	return true
+}
+
+// Forget 忘记远端客户端的注册记录，用于远端客户端撤回时
+//
+// msg: 远端客户端的撤回消息
+func (rt *RegistrationTracker) Forget(msg *switchdata.DiscoveryMessage) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	delete(rt.records, announcementKey{switchId: msg.SwitchId, fingerprint: msg.Fingerprint})
+}
+
+// Close 关闭远端客户端注册记录
+func (rt *RegistrationTracker) Close() {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	if rt.closed {
+		return
+	}
+	close(rt.closeSignal)
+	rt.closed = true
+}
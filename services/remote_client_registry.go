@@ -0,0 +1,216 @@
+package services
+
+// 远端客户端登记表模块
+//
+// 按 LocalSend 客户端指纹记录本节点当前知道的所有远端客户端，包括其别名、设备信息、当前地址、
+// 所属的发起节点、跳数、首次和最近一次见到的时间以及历史地址，长时间没有收到其发现包的客户端会被移除
+
+import (
+	"cmp"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+)
+
+// RemoteClient 一个已知的远端客户端
+type RemoteClient struct {
+	// 客户端指纹
+	Fingerprint string
+	// 别名
+	Alias string
+	// LocalSend 协议版本
+	Version string
+	// 设备型号
+	DeviceModel string
+	// 设备类型
+	DeviceType string
+	// 当前地址 (IP:端口)
+	Address string
+	// 协议 (http / https)
+	Protocol string
+	// 所属发起节点的 ID、名称和站点
+	OriginSwitchId string
+	OriginNodeName string
+	OriginSite     string
+	// 发现包经过的跳数
+	Hops int
+	// 首次见到的时间
+	FirstSeen time.Time
+	// 最近一次见到的时间
+	LastSeen time.Time
+	// 历史地址 (IP:端口)，从旧到新
+	PreviousAddresses []string
+	// 最近一次发现包的序列号，用于忽略经由其他路径到达的更旧的发现包
+	lastSeq uint64
+}
+
+// RemoteClientRegistry 记录本节点当前知道的所有远端客户端，可并发访问
+type RemoteClientRegistry struct {
+	mutex       sync.Mutex
+	clients     map[string]*RemoteClient // key: 客户端指纹
+	closeSignal chan struct{}            // 关闭信号，让相应协程退出
+	closed      bool                     // 标记是否关闭
+}
+
+// NewRemoteClientRegistry 创建一个新的远端客户端登记表
+func NewRemoteClientRegistry() *RemoteClientRegistry {
+	rcr := RemoteClientRegistry{
+		clients:     make(map[string]*RemoteClient),
+		closeSignal: make(chan struct{}),
+	}
+	// 定时移除长时间没有收到发现包的远端客户端
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rcr.removeExpired(time.Now())
+			case <-rcr.closeSignal:
+				return
+			}
+		}
+	}()
+	return &rcr
+}
+
+// removeExpired 移除长时间没有收到发现包的远端客户端
+//
+// now: 当前时间
+func (rcr *RemoteClientRegistry) removeExpired(now time.Time) {
+	rcr.mutex.Lock()
+	defer rcr.mutex.Unlock()
+	for fingerprint, client := range rcr.clients {
+		if now.Sub(client.LastSeen) > configs.RemoteClientRegistryLifetime*time.Second {
+			delete(rcr.clients, fingerprint)
+			slog.Info("Remote client expired", "alias", client.Alias, "address", client.Address, "fingerprint", fingerprint, "originNode", client.OriginNodeName, "originSite", client.OriginSite)
+		}
+	}
+	slog.Debug("Known remote clients", "count", len(rcr.clients))
+}
+
+// Observe 根据收到的发现包更新远端客户端的信息，发现客户端换到了新的地址时记录下来
+//
+// 保活 (REFRESH) 类型的发现包不含别名和设备信息，此时保留之前记录的信息
+//
+// msg: 远端客户端的发现包
+func (rcr *RemoteClientRegistry) Observe(msg *switchdata.DiscoveryMessage) {
+	now := time.Now()
+	address := net.JoinHostPort(msg.OriginalAddr, strconv.FormatInt(int64(msg.Port), 10))
+	rcr.mutex.Lock()
+	defer rcr.mutex.Unlock()
+	if rcr.closed {
+		return
+	}
+	client, exists := rcr.clients[msg.Fingerprint]
+	if !exists {
+		if len(rcr.clients) >= configs.RemoteClientRegistryMaxEntries {
+			// 登记表已满，忽略新的客户端
+			return
+		}
+		client = &RemoteClient{
+			Fingerprint: msg.Fingerprint,
+			Address:     address,
+			FirstSeen:   now,
+		}
+		rcr.clients[msg.Fingerprint] = client
+		slog.Info("New remote client discovered", "alias", msg.Alias, "address", address, "fingerprint", msg.Fingerprint, "originNode", msg.OriginNodeName, "originSite", msg.OriginSite)
+	} else if client.OriginSwitchId == msg.SwitchId && msg.DiscoverySeq <= client.lastSeq {
+		// 同一发起节点更旧的发现包 (例如经由其他路径绕回来的)，只刷新最近见到的时间
+		client.LastSeen = now
+		return
+	}
+	if client.Address != address {
+		// 客户端换到了新的地址
+		slog.Info("Remote client moved to a new address", "alias", client.Alias, "from", client.Address, "to", address, "fingerprint", msg.Fingerprint, "originNode", msg.OriginNodeName, "originSite", msg.OriginSite)
+		client.PreviousAddresses = append(client.PreviousAddresses, client.Address)
+		if len(client.PreviousAddresses) > configs.RemoteClientAddressHistorySize {
+			client.PreviousAddresses = client.PreviousAddresses[len(client.PreviousAddresses)-configs.RemoteClientAddressHistorySize:]
+		}
+		client.Address = address
+	}
+	if msg.Kind == switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE {
+		client.Alias = msg.Alias
+		client.Version = msg.Version
+		client.DeviceModel = msg.DeviceModel
+		client.DeviceType = msg.DeviceType
+	}
+	client.Protocol = msg.Protocol
+	client.OriginSwitchId = msg.SwitchId
+	client.OriginNodeName = msg.OriginNodeName
+	client.OriginSite = msg.OriginSite
+	client.Hops = configs.MaxDiscoveryMessageTTL - int(msg.DiscoveryTtl)
+	client.LastSeen = now
+	client.lastSeq = msg.DiscoverySeq
+}
+
+// Remove 移除远端客户端，用于远端客户端撤回时
+//
+// 只有撤回消息来自客户端当前所属的发起节点时才会移除，避免客户端换到其他节点后被旧节点的撤回误删
+//
+// msg: 远端客户端的撤回消息
+func (rcr *RemoteClientRegistry) Remove(msg *switchdata.DiscoveryMessage) {
+	rcr.mutex.Lock()
+	defer rcr.mutex.Unlock()
+	client, exists := rcr.clients[msg.Fingerprint]
+	if exists && client.OriginSwitchId == msg.SwitchId {
+		delete(rcr.clients, msg.Fingerprint)
+	}
+}
+
+// Clients 返回当前已知的所有远端客户端的副本，按别名和指纹排序
+func (rcr *RemoteClientRegistry) Clients() []RemoteClient {
+	rcr.mutex.Lock()
+	defer rcr.mutex.Unlock()
+	clients := make([]RemoteClient, 0, len(rcr.clients))
+	for _, client := range rcr.clients {
+		clientCopy := *client
+		clientCopy.PreviousAddresses = slices.Clone(client.PreviousAddresses)
+		clients = append(clients, clientCopy)
+	}
+	slices.SortFunc(clients, func(a, b RemoteClient) int {
+		if c := cmp.Compare(a.Alias, b.Alias); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Fingerprint, b.Fingerprint)
+	})
+	return clients
+}
+
+// Restore 恢复之前记录的远端客户端，跳过已经过期的以及已经记录的客户端
+//
+// clients: 之前记录的远端客户端
+func (rcr *RemoteClientRegistry) Restore(clients []RemoteClient) {
+	now := time.Now()
+	rcr.mutex.Lock()
+	defer rcr.mutex.Unlock()
+	for _, client := range clients {
+		if now.Sub(client.LastSeen) > configs.RemoteClientRegistryLifetime*time.Second {
+			continue
+		}
+		if _, exists := rcr.clients[client.Fingerprint]; exists {
+			continue
+		}
+		if len(rcr.clients) >= configs.RemoteClientRegistryMaxEntries {
+			return
+		}
+		rcr.clients[client.Fingerprint] = &client
+	}
+}
+
+// Close 关闭远端客户端登记表
+func (rcr *RemoteClientRegistry) Close() {
+	rcr.mutex.Lock()
+	defer rcr.mutex.Unlock()
+	if rcr.closed {
+		return
+	}
+	close(rcr.closeSignal)
+	rcr.closed = true
+}
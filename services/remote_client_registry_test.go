@@ -0,0 +1,119 @@
+package services
+
+import (
+	"net"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+)
+
+// newTestRemoteClientMessage 构造一个远端客户端的发现包
+//
+// switchId: 发起节点 ID
+// seq: 序列号
+// ip: 客户端 IP 地址
+// alias: 客户端别名
+func newTestRemoteClientMessage(switchId string, seq uint64, ip string, alias string) *switchdata.DiscoveryMessage {
+	clientInfo := &entities.LocalSendClientInfo{Alias: alias, Fingerprint: "fingerprint", Port: 53317, Protocol: "https"}
+	return utils.PackLocalSendClientInfoIntoSwitchMessage(clientInfo, switchId, seq, net.ParseIP(ip), switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE, 1).Payload
+}
+
+// TestRemoteClientRegistryAddressChange 客户端换到新的地址时记录历史地址，历史地址数有上限，只保留最新的
+func TestRemoteClientRegistryAddressChange(t *testing.T) {
+	registry := NewRemoteClientRegistry()
+	defer registry.Close()
+	registry.Observe(newTestRemoteClientMessage("origin", 1, "192.168.1.10", "Laptop"))
+	registry.Observe(newTestRemoteClientMessage("origin", 2, "192.168.1.10", "Laptop"))
+	registry.Observe(newTestRemoteClientMessage("origin", 3, "192.168.1.11", "Laptop"))
+
+	clients := registry.Clients()
+	if len(clients) != 1 {
+		t.Fatalf("Expected 1 known remote client, got %d", len(clients))
+	}
+	if clients[0].Address != "192.168.1.11:53317" {
+		t.Errorf("Expected current address 192.168.1.11:53317, got %s", clients[0].Address)
+	}
+	if !slices.Equal(clients[0].PreviousAddresses, []string{"192.168.1.10:53317"}) {
+		t.Errorf("Expected previous addresses [192.168.1.10:53317], got %v", clients[0].PreviousAddresses)
+	}
+
+	// 频繁更换地址时只保留最新的若干个
+	numMoves := configs.RemoteClientAddressHistorySize + 3
+	for i := range numMoves {
+		registry.Observe(newTestRemoteClientMessage("origin", uint64(4+i), "192.168.2."+strconv.Itoa(i+1), "Laptop"))
+	}
+	previous := registry.Clients()[0].PreviousAddresses
+	if len(previous) != configs.RemoteClientAddressHistorySize {
+		t.Fatalf("Expected %d previous addresses, got %d", configs.RemoteClientAddressHistorySize, len(previous))
+	}
+	if latest := "192.168.2." + strconv.Itoa(numMoves-1) + ":53317"; previous[len(previous)-1] != latest {
+		t.Errorf("Expected latest previous address %s, got %s", latest, previous[len(previous)-1])
+	}
+}
+
+// TestRemoteClientRegistrySeqOrdering 同一发起节点更旧的发现包不会覆盖客户端信息，客户端换到其他发起节点后以其序列号为准
+func TestRemoteClientRegistrySeqOrdering(t *testing.T) {
+	tests := []struct {
+		name         string
+		msg          *switchdata.DiscoveryMessage
+		wantAddress  string
+		wantAlias    string
+		wantOrigin   string
+		wantPrevious int
+	}{
+		{name: "older from same origin", msg: newTestRemoteClientMessage("origin", 3, "192.168.1.11", "Old"), wantAddress: "192.168.1.10:53317", wantAlias: "Laptop", wantOrigin: "origin"},
+		{name: "same seq from same origin", msg: newTestRemoteClientMessage("origin", 5, "192.168.1.11", "Old"), wantAddress: "192.168.1.10:53317", wantAlias: "Laptop", wantOrigin: "origin"},
+		{name: "newer from same origin", msg: newTestRemoteClientMessage("origin", 6, "192.168.1.11", "Renamed"), wantAddress: "192.168.1.11:53317", wantAlias: "Renamed", wantOrigin: "origin", wantPrevious: 1},
+		{name: "lower seq from another origin", msg: newTestRemoteClientMessage("other", 1, "10.0.0.5", "Moved"), wantAddress: "10.0.0.5:53317", wantAlias: "Moved", wantOrigin: "other", wantPrevious: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := NewRemoteClientRegistry()
+			defer registry.Close()
+			registry.Observe(newTestRemoteClientMessage("origin", 5, "192.168.1.10", "Laptop"))
+			registry.Observe(tt.msg)
+			client := registry.Clients()[0]
+			if client.Address != tt.wantAddress || client.Alias != tt.wantAlias || client.OriginSwitchId != tt.wantOrigin {
+				t.Errorf("Got address %s, alias %s and origin %s, expected %s, %s and %s", client.Address, client.Alias, client.OriginSwitchId, tt.wantAddress, tt.wantAlias, tt.wantOrigin)
+			}
+			if len(client.PreviousAddresses) != tt.wantPrevious {
+				t.Errorf("Expected %d previous addresses, got %v", tt.wantPrevious, client.PreviousAddresses)
+			}
+		})
+	}
+}
+
+// TestRemoteClientRegistryExpiry 长时间没有收到发现包的客户端被移除，恢复时也跳过已经过期的客户端
+func TestRemoteClientRegistryExpiry(t *testing.T) {
+	registry := NewRemoteClientRegistry()
+	defer registry.Close()
+	registry.Observe(newTestRemoteClientMessage("origin", 1, "192.168.1.10", "Laptop"))
+	lifetime := configs.RemoteClientRegistryLifetime * time.Second
+
+	registry.removeExpired(time.Now().Add(lifetime - time.Second))
+	if len(registry.Clients()) != 1 {
+		t.Fatalf("Client was removed before its lifetime ended")
+	}
+	// 更旧的发现包也会刷新最近见到的时间
+	registry.Observe(newTestRemoteClientMessage("origin", 1, "192.168.1.10", "Laptop"))
+	registry.removeExpired(time.Now().Add(lifetime + time.Second))
+	if clients := registry.Clients(); len(clients) != 0 {
+		t.Fatalf("Expected expired client to be removed, got %v", clients)
+	}
+
+	now := time.Now()
+	registry.Restore([]RemoteClient{
+		{Fingerprint: "fresh", Address: "192.168.1.20:53317", LastSeen: now},
+		{Fingerprint: "expired", Address: "192.168.1.21:53317", LastSeen: now.Add(-lifetime - time.Second)},
+	})
+	clients := registry.Clients()
+	if len(clients) != 1 || clients[0].Fingerprint != "fresh" {
+		t.Errorf("Expected only the fresh client to be restored, got %v", clients)
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+// 防重放模块
+//
+// 不依赖于发现包 ID 缓存的大小和生命周期:
+//  1. 发现包携带发起节点生成时的时间戳，超过最大存活时间的发现包会被拒绝
+//  2. 对每个发起节点 (switch_id) 维护一个序列号滑动窗口，窗口内已经出现过的、或比窗口更旧的序列号会被拒绝
+//
+// 一个发起节点的窗口在其最后一次更新的 2 倍最大存活时间后才会被清理，
+// 此时在窗口中出现过的发现包必定已经超过最大存活时间，即使再被重放也会因为时间戳被拒绝
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+)
+
+// errReplayedMessage 表示发现包已经出现过或太旧
+var errReplayedMessage = errors.New("Replayed or stale switch message")
+
+// seqWindow 单个发起节点的序列号滑动窗口
+type seqWindow struct {
+	// 窗口内最大的序列号
+	highest uint64
+	// 位图，第 i 位表示序列号 highest-i 是否已出现过
+	bitmap uint64
+	// 最后一次更新的时间
+	updatedAt time.Time
+}
+
+// seen 判断一个序列号是否已出现过或太旧，不修改窗口
+func (sw *seqWindow) seen(seq uint64) bool {
+	if seq > sw.highest {
+		return false
+	}
+	offset := sw.highest - seq
+	if offset >= configs.SwitchSeqWindowSize {
+		// 比窗口更旧
+		return true
+	}
+	// 是否已经出现过
+	return sw.bitmap&(uint64(1)<<offset) != 0
+}
+
+// accept 尝试接受一个序列号，已出现过或太旧则返回 false
+func (sw *seqWindow) accept(seq uint64) bool {
+	if sw.seen(seq) {
+		return false
+	}
+	if seq > sw.highest {
+		// 新的最大序列号，窗口向前滑动
+		shift := seq - sw.highest
+		if shift >= configs.SwitchSeqWindowSize {
+			sw.bitmap = 0
+		} else {
+			sw.bitmap <<= shift
+		}
+		sw.bitmap |= 1
+		sw.highest = seq
+		return true
+	}
+	sw.bitmap |= uint64(1) << (sw.highest - seq)
+	return true
+}
+
+// ReplayGuard 根据时间戳和序列号窗口拒绝重放或过旧的发现包
+type ReplayGuard struct {
+	mutex       sync.Mutex
+	windows     map[string]*seqWindow // key: 发起节点的 switch_id
+	closeSignal chan struct{}         // 关闭信号，让相应协程退出
+	closed      bool                  // 标记是否关闭
+}
+
+// NewReplayGuard 创建一个新的防重放检查器
+func NewReplayGuard() *ReplayGuard {
+	rg := ReplayGuard{
+		windows:     make(map[string]*seqWindow),
+		closeSignal: make(chan struct{}),
+	}
+	// 定时清理长时间没有更新的窗口
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				windowLifetime := 2 * time.Duration(configs.GetSwitchMessageMaxAge()) * time.Second
+				now := time.Now()
+				rg.mutex.Lock()
+				for switchId, window := range rg.windows {
+					if now.Sub(window.updatedAt) > windowLifetime {
+						delete(rg.windows, switchId)
+					}
+				}
+				rg.mutex.Unlock()
+			case <-rg.closeSignal:
+				return
+			}
+		}
+	}()
+	return &rg
+}
+
+// Check 检查发现包是否新鲜且没有出现过，不会记录该发现包
+//
+// 发现包可能在通过检查后因其他原因被丢弃，此时不能占用发起节点的序列号窗口，否则它之后经其他路径到达时会被误判为重放
+func (rg *ReplayGuard) Check(msg *entities.SwitchMessage) error {
+	return rg.check(msg, false)
+}
+
+// Accept 检查发现包是否新鲜且没有出现过，通过检查的发现包会被记录到其发起节点的窗口中
+func (rg *ReplayGuard) Accept(msg *entities.SwitchMessage) error {
+	return rg.check(msg, true)
+}
+
+// check 检查发现包是否新鲜且没有出现过
+//
+// record: 通过检查时是否记录到发起节点的窗口中
+func (rg *ReplayGuard) check(msg *entities.SwitchMessage, record bool) error {
+	maxAge := time.Duration(configs.GetSwitchMessageMaxAge()) * time.Second
+	now := time.Now()
+	originTime := time.UnixMilli(msg.Payload.OriginTimestamp)
+	// 时间戳过旧或超前太多 (考虑到节点间的时钟偏差，超前同样以最大存活时间为限)
+	if age := now.Sub(originTime); age > maxAge || age < -maxAge {
+		return fmt.Errorf("%w: origin timestamp %s is out of the allowed range (max age %s)", errReplayedMessage, originTime.Format(time.RFC3339), maxAge)
+	}
+	rg.mutex.Lock()
+	defer rg.mutex.Unlock()
+	if rg.closed {
+		return errors.New("Replay guard is closed")
+	}
+	window, exists := rg.windows[msg.Payload.SwitchId]
+	if !exists {
+		if record {
+			// 第一次见到该发起节点
+			rg.windows[msg.Payload.SwitchId] = &seqWindow{
+				highest:   msg.Payload.DiscoverySeq,
+				bitmap:    1,
+				updatedAt: now,
+			}
+		}
+		return nil
+	}
+	if window.seen(msg.Payload.DiscoverySeq) {
+		return fmt.Errorf("%w: sequence %d from switch %s is outside the window or already seen", errReplayedMessage, msg.Payload.DiscoverySeq, msg.Payload.SwitchId)
+	}
+	if record {
+		window.accept(msg.Payload.DiscoverySeq)
+		window.updatedAt = now
+	}
+	return nil
+}
+
+// Close 关闭防重放检查器
+func (rg *ReplayGuard) Close() {
+	rg.mutex.Lock()
+	defer rg.mutex.Unlock()
+	if rg.closed {
+		return
+	}
+	close(rg.closeSignal)
+	rg.closed = true
+}
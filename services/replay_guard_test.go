@@ -0,0 +1,112 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+)
+
+// newTestReplayMessage 构造一个指定发起节点、序列号和时间戳的发现包
+func newTestReplayMessage(switchId string, seq uint64, originTime time.Time) *entities.SwitchMessage {
+	return &entities.SwitchMessage{
+		Payload: &switchdata.DiscoveryMessage{
+			SwitchId:        switchId,
+			DiscoverySeq:    seq,
+			OriginTimestamp: originTime.UnixMilli(),
+		},
+	}
+}
+
+// TestReplayGuardAccept 按序列号窗口和时间戳拒绝重放或过旧的发现包
+func TestReplayGuardAccept(t *testing.T) {
+	maxAge := time.Duration(configs.GetSwitchMessageMaxAge()) * time.Second
+	const windowSize = configs.SwitchSeqWindowSize
+	tests := []struct {
+		name string
+		// 之前已经接受的序列号
+		accepted []uint64
+		// 之前只通过了检查、随后被丢弃 (如等候室已满) 的序列号
+		checked []uint64
+		seq     uint64
+		// 发现包时间戳相对当前时间的偏移
+		age    time.Duration
+		reject bool
+	}{
+		{name: "first message", seq: 100},
+		{name: "next seq", accepted: []uint64{100}, seq: 101},
+		{name: "duplicate seq", accepted: []uint64{100}, seq: 100, reject: true},
+		{name: "out of order inside window", accepted: []uint64{100}, seq: 90},
+		{name: "duplicate out of order seq", accepted: []uint64{100, 90}, seq: 90, reject: true},
+		{name: "oldest seq inside window", accepted: []uint64{100}, seq: 100 - windowSize + 1},
+		{name: "seq older than window", accepted: []uint64{100}, seq: 100 - windowSize, reject: true},
+		{name: "jump far ahead", accepted: []uint64{100}, seq: 100 + 10*windowSize},
+		{name: "old seq after jump", accepted: []uint64{100, 100 + 10*windowSize}, seq: 100, reject: true},
+		{name: "unseen seq inside window after jump", accepted: []uint64{100, 100 + 10*windowSize}, seq: 100 + 10*windowSize - 1},
+		{name: "seq dropped after check", accepted: []uint64{100}, checked: []uint64{101}, seq: 101},
+		{name: "first seq dropped after check", checked: []uint64{100}, seq: 100},
+		{name: "check does not slide window", accepted: []uint64{100}, checked: []uint64{100 + 10*windowSize}, seq: 90},
+		{name: "too old timestamp", seq: 100, age: maxAge + time.Minute, reject: true},
+		{name: "timestamp too far ahead", seq: 100, age: -maxAge - time.Minute, reject: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rg := NewReplayGuard()
+			defer rg.Close()
+			now := time.Now()
+			for _, seq := range tt.accepted {
+				if err := rg.Accept(newTestReplayMessage("origin", seq, now)); err != nil {
+					t.Fatalf("Failed to accept seq %d: %v", seq, err)
+				}
+			}
+			for _, seq := range tt.checked {
+				if err := rg.Check(newTestReplayMessage("origin", seq, now)); err != nil {
+					t.Fatalf("Failed to check seq %d: %v", seq, err)
+				}
+			}
+			err := rg.Accept(newTestReplayMessage("origin", tt.seq, now.Add(-tt.age)))
+			if tt.reject && !errors.Is(err, errReplayedMessage) {
+				t.Errorf("Expected seq %d to be rejected as replayed, got %v", tt.seq, err)
+			}
+			if !tt.reject && err != nil {
+				t.Errorf("Expected seq %d to be accepted, got %v", tt.seq, err)
+			}
+			// 各发起节点的窗口互相独立
+			if err := rg.Accept(newTestReplayMessage("other", tt.seq, now)); err != nil {
+				t.Errorf("Seq %d from another switch was rejected: %v", tt.seq, err)
+			}
+		})
+	}
+}
+
+// TestSwitchLoungeFullKeepsReplayWindow 等候室已满时丢弃的发现包不占用序列号窗口，之后经其他路径到达时仍会被接受
+func TestSwitchLoungeFullKeepsReplayWindow(t *testing.T) {
+	prevMode := configs.GetNodeTrustMode()
+	configs.SetNodeTrustMode(configs.NodeTrustModeOff)
+	t.Cleanup(func() { configs.SetNodeTrustMode(prevMode) })
+	keyring, err := NewNodeKeyring(filepath.Join(t.TempDir(), "known-nodes"), newTestNodeIdentity(t))
+	if err != nil {
+		t.Fatalf("Failed to create node keyring: %v", err)
+	}
+	lounge := NewSwitchLounge(keyring)
+	defer lounge.Close()
+
+	now := time.Now()
+	for seq := range uint64(configs.SwitchLoungeSize) {
+		if err := lounge.Write(newTestReplayMessage("filler", seq, now)); err != nil {
+			t.Fatalf("Failed to fill switch lounge: %v", err)
+		}
+	}
+	if err := lounge.Write(newTestReplayMessage("origin", 100, now)); err == nil {
+		t.Fatalf("Expected write to a full switch lounge to fail")
+	}
+	<-lounge.Read()
+	// 同一发现包经其他路径再次到达
+	if err := lounge.Write(newTestReplayMessage("origin", 100, now)); err != nil {
+		t.Errorf("Message dropped by a full switch lounge was rejected later: %v", err)
+	}
+}
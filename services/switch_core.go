@@ -12,18 +12,24 @@ import (
 
 	"github.com/somebottle/localsend-switch/configs"
 	"github.com/somebottle/localsend-switch/entities"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
 	"github.com/somebottle/localsend-switch/utils"
+	"google.golang.org/protobuf/proto"
 )
 
 // setUpPassiveForwarder 启动被动的交换数据转发器，将接收到的交换数据转发给其他节点，并向远端节点注册本机 LocalSend 客户端信息
 //
+// nodeId: 本节点唯一标识符
 // SwitchLounge: 交换数据等候室
 // localClientLounge: 本地客户端信息等候室
+// announcementStore: 客户端公告存储
+// remoteClientRegistry: 远端客户端登记表
+// registrationTracker: 远端客户端注册记录
 // tcpConnHub: TCP 连接管理器
 // httpRequestChan: HTTP 请求发送通道
 // errChan: 致命错误通道
 // sigCtx: 中断信号上下文
-func setUpPassiveForwarder(SwitchLounge *SwitchLounge, localClientLounge *LocalClientLounge, tcpConnHub *TCPConnectionHub, httpRequestChan chan<- *entities.HTTPJsonRequest, errChan chan<- error, sigCtx context.Context) {
+func setUpPassiveForwarder(nodeId string, SwitchLounge *SwitchLounge, localClientLounge *LocalClientLounge, announcementStore *AnnouncementStore, remoteClientRegistry *RemoteClientRegistry, registrationTracker *RegistrationTracker, tcpConnHub *TCPConnectionHub, httpRequestChan chan<- *entities.HTTPJsonRequest, errChan chan<- error, sigCtx context.Context) {
 	// 构建 HTTP 请求对象的方法
 	makeHTTPRequest := func(ip net.IP, port uint16, protocol string, jsonBody []byte) *entities.HTTPJsonRequest {
 		// 拼接成 host:port 形式，会自动用方括号包裹可能的 IPv6 地址
@@ -63,22 +69,27 @@ func setUpPassiveForwarder(SwitchLounge *SwitchLounge, localClientLounge *LocalC
 				slog.Debug("Warning: original address from switch message is not a private IP, ignored", "address", switchMsg.Payload.OriginalAddr)
 				continue
 			}
-			// 对于每个交换信息，转发给所有连接的节点 (除开其来源节点的连接)
-			for _, cwc := range tcpConnHub.GetConnectionsExcept(switchMsg.SourceAddr) {
-				// 交换信息 TTL 减一
-				switchMsg.Payload.DiscoveryTtl--
-				// 如果 TTL 已经为 0，则不再转发，丢弃
-				if switchMsg.Payload.DiscoveryTtl <= 0 {
-					continue
-				}
-				slog.Debug("Forwarding switch message", "message", switchMsg, "to", cwc.Conn.RemoteAddr().String())
-				// 把交换信息发送到对应的发送通道
-				cwc.SendChan <- switchMsg
+			// 已经经过本节点的发现包说明出现了环路，丢弃
+			if utils.DiscoveryPathContains(switchMsg.Payload, nodeId) {
+				slog.Debug("Switch message already passed through this node, dropped", "originNode", switchMsg.Payload.OriginNodeName, "path", utils.FormatDiscoveryPath(switchMsg.Payload))
+				continue
+			}
+			// 本节点转发 (或发出) 该发现包，追加到转发路径中
+			utils.AppendDiscoveryPathHop(switchMsg.Payload, nodeId)
+			// 记录远端客户端最新的发现包 (本机客户端的以及经由环路回来的本节点发现包除外)，用于向新链路推送快照
+			latest := true
+			if switchMsg.Payload.SwitchId != nodeId {
+				latest = announcementStore.Put(switchMsg.Payload)
 			}
+			// 对于每个交换信息，转发给所有连接的节点 (除开其来源节点的连接)
+			fanOutSwitchMessage(switchMsg, tcpConnHub)
 			// 每个交换信息，只要其**发起方**不是本机，就同时对其**发起地址**发送注册请求
 			// 对其发起地址: 发送本机的 LocalSend 客户端信息
 			if !remoteIP.Equal(selfIp) {
-				slog.Debug("Received non-local client info", "message", switchMsg.Payload)
+				slog.Debug("Received non-local client info", "originNode", switchMsg.Payload.OriginNodeName, "originSite", switchMsg.Payload.OriginSite, "path", utils.FormatDiscoveryPath(switchMsg.Payload), "message", switchMsg.Payload)
+				if !observeRemoteClient(switchMsg.Payload, latest, localClientLounge.Generation(), remoteClientRegistry, registrationTracker) {
+					continue
+				}
 				// 转换为 LocalSend 客户端信息
 				remoteClientInfo, err := utils.SwitchMessageToLocalSendClientInfo(switchMsg)
 				if err != nil {
@@ -96,7 +107,7 @@ func setUpPassiveForwarder(SwitchLounge *SwitchLounge, localClientLounge *LocalC
 					}
 					// 在远端客户端注册本地客户端信息
 					remoteHttpReq := makeHTTPRequest(remoteIP, remoteClientInfo.Port, remoteClientInfo.Protocol, localJsonPayload)
-					slog.Info("Register local client on remote node", "url", remoteHttpReq.URL)
+					slog.Info("Register local client on remote node", "url", remoteHttpReq.URL, "solicited", switchMsg.Payload.Solicit, "originNode", switchMsg.Payload.OriginNodeName, "originSite", switchMsg.Payload.OriginSite)
 					// 发送 HTTP 请求
 					select {
 					case httpRequestChan <- remoteHttpReq:
@@ -110,13 +121,91 @@ func setUpPassiveForwarder(SwitchLounge *SwitchLounge, localClientLounge *LocalC
 	}
 }
 
+// observeRemoteClient 根据远端客户端的发现包更新登记表和注册记录，返回是否需要在该远端客户端上注册本机客户端
+//
+// 撤回消息会移除远端客户端并忘记其注册记录；比已记录的更旧的发现包 (比如撤回后才经其他路径到达的公告) 会被忽略，
+// 不会让已撤回的客户端重新出现，也不会再向其注册
+//
+// msg: 远端客户端的发现包
+// latest: 发现包是否不比已记录的旧
+// localGeneration: 本机客户端信息当前的变化次数
+// remoteClientRegistry: 远端客户端登记表
+// registrationTracker: 远端客户端注册记录
+func observeRemoteClient(msg *switchdata.DiscoveryMessage, latest bool, localGeneration uint64, remoteClientRegistry *RemoteClientRegistry, registrationTracker *RegistrationTracker) bool {
+	if !latest {
+		slog.Debug("Stale client info from remote switch, ignored", "fingerprint", msg.Fingerprint, "seq", msg.DiscoverySeq, "originNode", msg.OriginNodeName, "originSite", msg.OriginSite)
+		return false
+	}
+	if msg.Kind == switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_WITHDRAW {
+		// 远端客户端已离开，忘记其注册记录，不再向其注册
+		remoteClientRegistry.Remove(msg)
+		registrationTracker.Forget(msg)
+		slog.Info("Remote client withdrawn", "address", msg.OriginalAddr, "port", msg.Port, "fingerprint", msg.Fingerprint, "originNode", msg.OriginNodeName, "originSite", msg.OriginSite)
+		return false
+	}
+	// 记录到远端客户端登记表
+	remoteClientRegistry.Observe(msg)
+	// 远端客户端和本机客户端都没有变化，且距上次注册还没超过重新注册间隔时，不必再次注册
+	// 远端用户刷新了设备列表 (征求) 时则立即注册，让刷新能马上看到本机客户端
+	return registrationTracker.ShouldRegister(msg, localGeneration)
+}
+
+// nextHopSwitchMessage 生成交换消息发往下一跳的副本，TTL 减一，TTL 耗尽时返回 nil
+//
+// 原消息不会被修改；副本会被多个连接的发送协程共享并同时序列化，放入发送通道后不能再修改
+//
+// switchMsg: 交换消息
+func nextHopSwitchMessage(switchMsg *entities.SwitchMessage) *entities.SwitchMessage {
+	// TTL 减一后为 0，则不再转发，丢弃
+	if switchMsg.Payload.DiscoveryTtl <= 1 {
+		return nil
+	}
+	payload := proto.Clone(switchMsg.Payload).(*switchdata.DiscoveryMessage)
+	payload.DiscoveryTtl--
+	nextHopMsg := &entities.SwitchMessage{
+		SourceAddr: switchMsg.SourceAddr,
+		Payload:    payload,
+	}
+	// 只编码一次，各连接共享编码好的数据帧
+	if err := encodeSwitchMessageFrame(nextHopMsg); err != nil {
+		slog.Debug("Failed to pre-encode switch message, each link will encode it separately", "error", err)
+	}
+	return nextHopMsg
+}
+
+// fanOutSwitchMessage 把交换消息转发给除来源以外的所有连接，返回转发到的连接数
+//
+// 每一跳 TTL 只减一，所有连接共享同一个副本；使用转发树时只转发给以发起节点为根的转发树上本节点的子节点
+//
+// switchMsg: 交换消息，不会被修改
+// tcpConnHub: TCP 连接管理器
+func fanOutSwitchMessage(switchMsg *entities.SwitchMessage, tcpConnHub *TCPConnectionHub) int {
+	nextHopMsg := nextHopSwitchMessage(switchMsg)
+	if nextHopMsg == nil {
+		return 0
+	}
+	numForwarded := 0
+	for _, cwc := range tcpConnHub.GetConnectionsExcept(switchMsg.SourceAddr) {
+		if !tcpConnHub.LinkState().ShouldForward(switchMsg.Payload.SwitchId, cwc.Peer.NodeId) {
+			continue
+		}
+		slog.Debug("Forwarding switch message", "message", nextHopMsg, "path", utils.FormatDiscoveryPath(nextHopMsg.Payload), "to", cwc.Conn.RemoteAddr().String())
+		// 把交换信息放入对应的发送队列，队列已满时按策略处理，不会阻塞
+		if tcpConnHub.Enqueue(cwc, nextHopMsg) {
+			numForwarded++
+		}
+	}
+	return numForwarded
+}
+
 // setUpProactiveBroadcaster 启动定时主动广播，定期向已知节点广播本机 LocalSend 客户端信息
 //
 // nodeId: 本节点唯一标识符
+// nodeIdentity: 本节点身份，用于对发现包签名
 // LocalClientLounge: 本地客户端信息等候室
 // tcpConnHub: TCP 连接管理器
 // sigCtx: 中断信号上下文
-func setUpProactiveBroadcaster(nodeId string, localClientLounge *LocalClientLounge, tcpConnHub *TCPConnectionHub, sigCtx context.Context) {
+func setUpProactiveBroadcaster(nodeId string, nodeIdentity *utils.NodeIdentity, localClientLounge *LocalClientLounge, tcpConnHub *TCPConnectionHub, sigCtx context.Context) {
 	// 获得本机 IP
 	selfIp, err := utils.GetOutboundIP()
 	if err != nil {
@@ -135,20 +224,111 @@ func setUpProactiveBroadcaster(nodeId string, localClientLounge *LocalClientLoun
 			// 定时广播
 			// 先获得本地客户端信息列表
 			var numLocalClients, numConnections int = 0, tcpConnHub.NumConnections()
-			for localClientInfo := range localClientLounge.SyncGet() {
+			for _, announcement := range localClientLounge.Announcements(true) {
 				numLocalClients++
-				localSwitchMsg := utils.PackLocalSendClientInfoIntoSwitchMessage(localClientInfo, nodeId, globalDiscoverySeq.Add(1)-1, selfIp)
-				// 对每个已连接的节点发送交换消息
-				for _, cwc := range tcpConnHub.GetAllConnections() {
-					localSwitchMsg.Payload.DiscoveryTtl--
-					cwc.SendChan <- localSwitchMsg
+				// 信息有变化 (或到了完整公告间隔) 的客户端发出完整的公告，其余的只发出精简的保活
+				kind := switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_REFRESH
+				if announcement.Changed {
+					kind = switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE
 				}
+				localSwitchMsg := utils.PackLocalSendClientInfoIntoSwitchMessage(announcement.Info, nodeId, globalDiscoverySeq.Add(1)-1, selfIp, kind, announcement.Version)
+				nodeIdentity.SignDiscoveryMessage(localSwitchMsg.Payload)
+				utils.AppendDiscoveryPathHop(localSwitchMsg.Payload, nodeId)
+				// 对每个已连接的节点发送交换消息
+				fanOutSwitchMessage(localSwitchMsg, tcpConnHub)
 			}
 			slog.Debug("Proactively broadcasted local client info to connected switch nodes", "numLocalClients", numLocalClients, "numConnections", numConnections)
+		case expired := <-localClientLounge.Expired():
+			// 本地客户端已离开，通知其他节点
+			slog.Info("Local client expired, withdrawing it from peer switches", "alias", expired.Info.Alias, "port", expired.Info.Port)
+			sendLocalClientWithdrawals(nodeId, nodeIdentity, selfIp, []LocalClientAnnouncement{expired}, tcpConnHub)
+		}
+	}
+}
+
+// sendLocalClientWithdrawals 发出本地客户端的撤回消息，和公告一样沿转发树 (或泛洪) 发给已连接的节点，不会阻塞
+//
+// nodeId: 本节点唯一标识符
+// nodeIdentity: 本节点身份，用于对发现包签名
+// selfIp: 本机 IP 地址
+// withdrawn: 要撤回的本地客户端
+// tcpConnHub: TCP 连接管理器
+func sendLocalClientWithdrawals(nodeId string, nodeIdentity *utils.NodeIdentity, selfIp net.IP, withdrawn []LocalClientAnnouncement, tcpConnHub *TCPConnectionHub) {
+	for _, announcement := range withdrawn {
+		withdrawMsg := utils.PackLocalSendClientInfoIntoSwitchMessage(announcement.Info, nodeId, globalDiscoverySeq.Add(1)-1, selfIp, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_WITHDRAW, announcement.Version)
+		nodeIdentity.SignDiscoveryMessage(withdrawMsg.Payload)
+		utils.AppendDiscoveryPathHop(withdrawMsg.Payload, nodeId)
+		if fanOutSwitchMessage(withdrawMsg, tcpConnHub) == 0 {
+			slog.Debug("Withdrawal was not queued for any peer switch", "fingerprint", announcement.Info.Fingerprint)
 		}
 	}
 }
 
+// withdrawLocalClientsOnShutdown 在关闭前向所有已连接的节点撤回本地客户端，并等待撤回消息发出 (最多等待 ShutdownFlushTimeout)
+//
+// nodeId: 本节点唯一标识符
+// nodeIdentity: 本节点身份，用于对发现包签名
+// localClientLounge: 本地客户端信息等候室
+// tcpConnHub: TCP 连接管理器
+func withdrawLocalClientsOnShutdown(nodeId string, nodeIdentity *utils.NodeIdentity, localClientLounge *LocalClientLounge, tcpConnHub *TCPConnectionHub) {
+	announcements := localClientLounge.Announcements(false)
+	if len(announcements) == 0 || tcpConnHub.NumConnections() == 0 {
+		return
+	}
+	selfIp, err := utils.GetOutboundIP()
+	if err != nil {
+		slog.Debug("Error getting outbound IP address for withdrawals on shutdown", "error", err)
+		return
+	}
+	sendLocalClientWithdrawals(nodeId, nodeIdentity, selfIp, announcements, tcpConnHub)
+	// 等待各连接的发送通道清空
+	deadline := time.Now().Add(configs.ShutdownFlushTimeout * time.Millisecond)
+	for {
+		time.Sleep(50 * time.Millisecond)
+		if tcpConnHub.NumQueuedMessages() == 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	slog.Info("Withdrew local clients from peer switches before shutting down", "numLocalClients", len(announcements))
+}
+
+// setUpSnapshotSource 设置新链路建立时推送给对端的快照: 本机所有 LocalSend 客户端的信息，以及所有已知远端客户端最新且仍然新鲜的发现包
+//
+// nodeId: 本节点唯一标识符
+// nodeIdentity: 本节点身份，用于对发现包签名
+// localClientLounge: 本地客户端信息等候室
+// announcementStore: 客户端公告存储
+// tcpConnHub: TCP 连接管理器
+func setUpSnapshotSource(nodeId string, nodeIdentity *utils.NodeIdentity, localClientLounge *LocalClientLounge, announcementStore *AnnouncementStore, tcpConnHub *TCPConnectionHub) {
+	// 获得本机 IP
+	selfIp, err := utils.GetOutboundIP()
+	if err != nil {
+		slog.Error("Error getting outbound IP address for link snapshots", "error", err)
+		return
+	}
+	tcpConnHub.SetSnapshotSource(func(peer *switchdata.Hello) []*entities.SwitchMessage {
+		snapshot := make([]*entities.SwitchMessage, 0)
+		// 本机的客户端信息，每次都用新的序列号重新打包签名
+		for _, announcement := range localClientLounge.Announcements(false) {
+			localSwitchMsg := utils.PackLocalSendClientInfoIntoSwitchMessage(announcement.Info, nodeId, globalDiscoverySeq.Add(1)-1, selfIp, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE, announcement.Version)
+			localSwitchMsg.Payload.DiscoveryTtl--
+			nodeIdentity.SignDiscoveryMessage(localSwitchMsg.Payload)
+			utils.AppendDiscoveryPathHop(localSwitchMsg.Payload, nodeId)
+			snapshot = append(snapshot, localSwitchMsg)
+		}
+		// 已知的远端客户端，不包含对端自己发起的以及经过对端的
+		for _, discoveryMsg := range announcementStore.Snapshot(peer.NodeId) {
+			// 和转发时一样，TTL 减一
+			discoveryMsg.DiscoveryTtl--
+			if discoveryMsg.DiscoveryTtl <= 0 {
+				continue
+			}
+			snapshot = append(snapshot, &entities.SwitchMessage{Payload: discoveryMsg})
+		}
+		return snapshot
+	})
+}
+
 // setUpClientAliveChecker 启动本地客户端存活检查器，定期向本地 LocalSend 客户端发送 HTTP 探测请求，如果存活会自动加入等候室
 //
 // 如果没有这个协程，只有被动等待 LocalSend 客户端发送 UDP 发现包才能探测到并加入等候室
@@ -239,43 +419,87 @@ func setUpClientAliveChecker(localSendPort string, localClientLounge *LocalClien
 // SetUpSwitchCore 设置并启动交换服务核心模块
 //
 // nodeId: 本节点唯一标识符
-// peerAddr: 远端 switch 节点地址
-// peerPort: 远端 switch 节点端口
+// nodeIdentity: 本节点身份，用于对发现包签名和校验
+// peers: 按优先级排列的远端 switch 节点
 // servPort: 本地 switch 服务监听端口
 // sigCtx: 中断信号上下文
 // multicastChan: 来自组播监听器的交换数据通道
 // localSendPort: 本地 LocalSend 监听 (组播 / HTTP) 端口
 // errChan: 致命错误通道
-func SetUpSwitchCore(nodeId string, peerAddr string, peerPort string, servPort string, sigCtx context.Context, multicastChan <-chan *entities.SwitchMessage, localSendPort string, errChan chan<- error) {
+func SetUpSwitchCore(nodeId string, nodeIdentity *utils.NodeIdentity, peers []entities.PeerEndpoint, servPort string, sigCtx context.Context, multicastChan <-chan *entities.SwitchMessage, localSendPort string, errChan chan<- error) {
 	// 通过 TCP 传输的交换数据通道
 	switchDataChan := make(chan *entities.SwitchMessage, configs.SwitchDataReceiveChanSize)
 	// 维护 TCP 连接的管理器
-	var tcpConnHub *TCPConnectionHub = NewTCPConnectionHub()
+	var tcpConnHub *TCPConnectionHub = NewTCPConnectionHub(nodeId)
+	// 校验发现包签名的节点公钥信任管理器
+	nodeKeyring, err := NewNodeKeyring(configs.KnownNodesFileName, nodeIdentity)
+	if err != nil {
+		errChan <- fmt.Errorf("Error setting up node keyring: %w", err)
+		return
+	}
+	// 收到的链路状态和发现包一样需要校验签名
+	tcpConnHub.LinkState().SetNodeKeyring(nodeKeyring)
 	// 维护待转发交换信息的等候室
-	var switchLounge *SwitchLounge = NewSwitchLounge()
+	var switchLounge *SwitchLounge = NewSwitchLounge(nodeKeyring)
 	// 维护本地客户端信息的等候室
 	var localClientLounge *LocalClientLounge = NewLocalClientLounge()
+	// 保存已知远端客户端最新发现包的存储，用于向新链路推送快照
+	var announcementStore *AnnouncementStore = NewAnnouncementStore()
+	// 记录本节点当前知道的所有远端客户端
+	var remoteClientRegistry *RemoteClientRegistry = NewRemoteClientRegistry()
+	// 记录本机客户端在各远端客户端上的注册情况，避免重复注册
+	var registrationTracker *RegistrationTracker = NewRegistrationTracker()
 	// 用来发送 HTTP 请求的通道
 	httpRequestChan := make(chan *entities.HTTPJsonRequest, configs.HTTPClientWorkerCount*2)
 	// 清理
 	defer func() {
 		localClientLounge.Close()
+		announcementStore.Close()
+		remoteClientRegistry.Close()
+		registrationTracker.Close()
 		switchLounge.Close()
 		tcpConnHub.Close()
 	}()
+	// TCP 链路在关闭前还要发出本地客户端的撤回消息，因此使用单独的上下文，撤回消息发出后才取消
+	linkCtx, cancelLinks := context.WithCancel(context.Background())
+	defer cancelLinks()
 
+	// 节点状态涉及的各个组件
+	stateParts := switchStateParts{
+		nodeId:               nodeId,
+		nodeIdentity:         nodeIdentity,
+		announcementStore:    announcementStore,
+		remoteClientRegistry: remoteClientRegistry,
+		tcpConnHub:           tcpConnHub,
+	}
+	if configs.GetPersistState() {
+		// 载入上次保存的节点状态，需要在建立任何链路之前完成；状态文件有问题时不影响启动
+		if err := loadSwitchState(configs.StateFileName, stateParts); err != nil {
+			slog.Warn("Failed to restore switch state, starting fresh", "error", err)
+		}
+		// 启动节点状态定时保存器
+		go setUpStatePersister(configs.StateFileName, stateParts, sigCtx)
+	}
+	// 新链路建立时推送快照，需要在建立任何链路之前设置
+	setUpSnapshotSource(nodeId, nodeIdentity, localClientLounge, announcementStore, tcpConnHub)
 	// 启动 TCP 服务以接收另一端传输过来的交换数据
-	go setUpTCPServer(servPort, tcpConnHub, switchDataChan, errChan, sigCtx)
-	// 连接到另一个 switch 节点
-	go connectPeer(peerAddr, peerPort, tcpConnHub, switchDataChan, errChan, sigCtx)
+	go setUpTCPServer(servPort, tcpConnHub, switchDataChan, errChan, linkCtx)
+	// 连接到对端 switch 节点
+	go setUpPeerConnector(peers, tcpConnHub, switchDataChan, linkCtx)
+	// 生成并发送本节点的链路状态
+	go setUpLinkStateAnnouncer(tcpConnHub, nodeIdentity, linkCtx)
+	// 定时记录各链路发送队列丢弃的消息数
+	go setUpSendQueueMonitor(tcpConnHub, linkCtx)
+	// 和通过对端交换得知的节点建立网状链路
+	go setUpMeshConnector(peers, tcpConnHub, switchDataChan, linkCtx)
 	// 启动 HTTP 请求发送器 (多个 worker)
 	for range configs.HTTPClientWorkerCount {
 		go setUpHTTPSender(httpRequestChan, sigCtx)
 	}
 	// 启动交换数据转发器
-	go setUpPassiveForwarder(switchLounge, localClientLounge, tcpConnHub, httpRequestChan, errChan, sigCtx)
+	go setUpPassiveForwarder(nodeId, switchLounge, localClientLounge, announcementStore, remoteClientRegistry, registrationTracker, tcpConnHub, httpRequestChan, errChan, sigCtx)
 	// 启动定时主动广播器
-	go setUpProactiveBroadcaster(nodeId, localClientLounge, tcpConnHub, sigCtx)
+	go setUpProactiveBroadcaster(nodeId, nodeIdentity, localClientLounge, tcpConnHub, sigCtx)
 	// 启动本地客户端存活探测器
 	go setUpClientAliveChecker(localSendPort, localClientLounge, httpRequestChan, sigCtx)
 
@@ -284,10 +508,6 @@ func SetUpSwitchCore(nodeId string, peerAddr string, peerPort string, servPort s
 		select {
 		case msg := <-multicastChan:
 			// 来自组播监听器的交换数据
-			if err := switchLounge.Write(msg); err != nil {
-				slog.Debug("Warning: failed to write switch message from multicast to lounge, ignored", "message", msg, "error", err)
-				continue
-			}
 			// 交换数据转换为客户端信息存入本地客户端信息等候室
 			// 注意 multicastChan 传递过来的消息一定是本机 LocalSend 客户端发出的
 			localSendClientInfo, err := utils.SwitchMessageToLocalSendClientInfo(msg)
@@ -295,14 +515,37 @@ func SetUpSwitchCore(nodeId string, peerAddr string, peerPort string, servPort s
 				slog.Debug("Warning: failed to convert switch message to local client info, ignored", "message", msg, "error", err)
 				continue
 			}
-			localClientLounge.Add(localSendClientInfo)
+			clientVersion, changed := localClientLounge.Add(localSendClientInfo)
+			if !changed && !msg.Payload.Solicit {
+				// 客户端信息没有变化，定时广播的保活就足够了
+				continue
+			}
+			// 新出现或信息有变化的本地客户端，以及用户刷新了设备列表 (征求) 的本地客户端，立即发出完整的公告
+			msg.Payload.Kind = switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE
+			msg.Payload.ClientVersion = clientVersion
+			// 用本节点身份签名，防止转发途中被篡改
+			nodeIdentity.SignDiscoveryMessage(msg.Payload)
+			if err := switchLounge.Write(msg); err != nil {
+				slog.Debug("Warning: failed to write switch message from multicast to lounge, ignored", "message", msg, "error", err)
+				continue
+			}
+			localClientLounge.MarkAnnounced(localSendClientInfo.Port, clientVersion)
 		case msg := <-switchDataChan:
 			// 来自 TCP 连接的交换数据
 			if err := switchLounge.Write(msg); err != nil {
 				slog.Debug("Warning: failed to write switch message from TCP to lounge, ignored", "message", msg, "error", err)
 			}
 		case <-sigCtx.Done():
-			// 收到退出信号
+			// 收到退出信号，先向其他节点撤回本地客户端，再关闭链路
+			withdrawLocalClientsOnShutdown(nodeId, nodeIdentity, localClientLounge, tcpConnHub)
+			if configs.GetPersistState() {
+				// 最后保存一次节点状态
+				if err := saveSwitchState(configs.StateFileName, stateParts); err != nil {
+					slog.Warn("Failed to save switch state", "error", err)
+				} else {
+					slog.Info("Switch state saved", "file", configs.StateFileName)
+				}
+			}
 			return
 		}
 	}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// addrConn 带有指定远端地址的连接，让管理器能区分各个测试连接
+type addrConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// TestFanOutSwitchMessage 向大量对端转发时，每一跳 TTL 只减一，且发送协程并发序列化时不会出现数据竞争 (需配合 -race 运行)
+func TestFanOutSwitchMessage(t *testing.T) {
+	const numPeers = 64
+	// 不超过发送队列长度，保证不会因为队列已满而丢弃
+	const numMessages = configs.TCPSocketSendChanSize
+
+	hub := NewTCPConnectionHub("self")
+	defer hub.Close()
+	var wg sync.WaitGroup
+	var conns []ConnWithChan
+	for i := range numPeers {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		conn := addrConn{Conn: local, remoteAddr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 10000 + i}}
+		cwc, err := hub.AddConnection(conn, &switchdata.Hello{NodeId: "peer" + strconv.Itoa(i)}, true)
+		if err != nil {
+			t.Fatalf("Failed to add connection %d: %v", i, err)
+		}
+		conns = append(conns, cwc)
+	}
+
+	// 第一个连接作为交换消息的来源，不应收到转发的消息
+	source := conns[0]
+	// 其余每个连接一个发送协程，和实际发送时一样并发地序列化收到的交换消息
+	received := make([][]*switchdata.DiscoveryMessage, numPeers)
+	for i := 1; i < numPeers; i++ {
+		cwc := conns[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range numMessages {
+				msg := <-cwc.SendChan
+				data, err := proto.Marshal(msg.Payload)
+				if err != nil {
+					t.Errorf("Failed to marshal switch message: %v", err)
+					return
+				}
+				decoded := &switchdata.DiscoveryMessage{}
+				if err := proto.Unmarshal(data, decoded); err != nil {
+					t.Errorf("Failed to unmarshal switch message: %v", err)
+					return
+				}
+				received[i] = append(received[i], decoded)
+			}
+		}()
+	}
+
+	for seq := range numMessages {
+		msg := &entities.SwitchMessage{
+			SourceAddr: source.Conn.RemoteAddr(),
+			Payload: &switchdata.DiscoveryMessage{
+				SwitchId:     "origin",
+				DiscoverySeq: uint64(seq),
+				DiscoveryTtl: configs.MaxDiscoveryMessageTTL,
+				Fingerprint:  "fingerprint",
+			},
+		}
+		if numForwarded := fanOutSwitchMessage(msg, hub); numForwarded != numPeers-1 {
+			t.Fatalf("Expected message to be forwarded to %d peers, got %d", numPeers-1, numForwarded)
+		}
+		if msg.Payload.DiscoveryTtl != configs.MaxDiscoveryMessageTTL {
+			t.Fatalf("Original message was modified, TTL %d", msg.Payload.DiscoveryTtl)
+		}
+	}
+	wg.Wait()
+
+	if len(source.SendChan) != 0 {
+		t.Errorf("Source peer received %d forwarded messages", len(source.SendChan))
+	}
+	for i := 1; i < numPeers; i++ {
+		msgs := received[i]
+		if len(msgs) != numMessages {
+			t.Errorf("Peer %d received %d messages, expected %d", i, len(msgs), numMessages)
+		}
+		for seq, msg := range msgs {
+			if msg.DiscoverySeq != uint64(seq) {
+				t.Errorf("Peer %d received message %d out of order (seq %d)", i, seq, msg.DiscoverySeq)
+			}
+			if msg.DiscoveryTtl != configs.MaxDiscoveryMessageTTL-1 {
+				t.Errorf("Peer %d received message %d with TTL %d, expected %d", i, seq, msg.DiscoveryTtl, configs.MaxDiscoveryMessageTTL-1)
+			}
+		}
+	}
+}
+
+// TestFanOutSwitchMessageTTLExhausted TTL 耗尽的交换消息不再转发
+func TestFanOutSwitchMessageTTLExhausted(t *testing.T) {
+	hub := NewTCPConnectionHub("self")
+	defer hub.Close()
+	local, remote := net.Pipe()
+	defer remote.Close()
+	if _, err := hub.AddConnection(local, &switchdata.Hello{NodeId: "peer"}, true); err != nil {
+		t.Fatalf("Failed to add connection: %v", err)
+	}
+	for _, ttl := range []uint32{0, 1} {
+		msg := &entities.SwitchMessage{Payload: &switchdata.DiscoveryMessage{SwitchId: "origin", DiscoveryTtl: ttl}}
+		if numForwarded := fanOutSwitchMessage(msg, hub); numForwarded != 0 {
+			t.Errorf("Message with TTL %d was forwarded to %d peers", ttl, numForwarded)
+		}
+	}
+}
+
+// TestObserveRemoteClientWithdraw 撤回消息移除远端客户端并忘记其注册记录，之后才到达的更旧的公告不会让客户端重新出现，也不会再向其注册
+func TestObserveRemoteClientWithdraw(t *testing.T) {
+	store := NewAnnouncementStore()
+	defer store.Close()
+	registry := NewRemoteClientRegistry()
+	defer registry.Close()
+	tracker := NewRegistrationTracker()
+	defer tracker.Close()
+	newMsg := func(seq uint64, kind switchdata.AnnouncementKind) *switchdata.DiscoveryMessage {
+		return utils.PackLocalSendClientInfoIntoSwitchMessage(&entities.LocalSendClientInfo{Alias: "Laptop", Fingerprint: "fingerprint", Port: 53317}, "origin", seq, net.ParseIP("192.168.1.10"), kind, 1).Payload
+	}
+	// observe 和转发器一样先记录到公告存储，再更新登记表和注册记录
+	observe := func(msg *switchdata.DiscoveryMessage) bool {
+		return observeRemoteClient(msg, store.Put(msg), 1, registry, tracker)
+	}
+
+	if !observe(newMsg(1, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE)) {
+		t.Fatalf("Expected to register on a new remote client")
+	}
+	if observe(newMsg(3, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_WITHDRAW)) {
+		t.Fatalf("Expected not to register on a withdrawn remote client")
+	}
+	if clients := registry.Clients(); len(clients) != 0 {
+		t.Fatalf("Expected withdrawn client to be removed from registry, got %v", clients)
+	}
+	if snapshot := store.Snapshot("late"); len(snapshot) != 0 {
+		t.Fatalf("Expected withdrawn client to be left out of snapshot, got %v", snapshot)
+	}
+
+	// 撤回之前发出、经其他路径绕回来的公告
+	if observe(newMsg(2, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE)) {
+		t.Errorf("Expected not to register again on a stale announcement after withdrawal")
+	}
+	if clients := registry.Clients(); len(clients) != 0 {
+		t.Errorf("Stale announcement brought withdrawn client back: %v", clients)
+	}
+
+	// 客户端之后重新上线
+	if !observe(newMsg(4, switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE)) {
+		t.Errorf("Expected to register on a client that came back")
+	}
+	if clients := registry.Clients(); len(clients) != 1 {
+		t.Errorf("Expected client that came back to be in registry, got %v", clients)
+	}
+}
+
+// TestSendLocalClientWithdrawals 撤回消息和公告一样经 fanOutSwitchMessage 发出: 每个对端收到一份签名有效、TTL 减一的撤回消息
+func TestSendLocalClientWithdrawals(t *testing.T) {
+	hub := NewTCPConnectionHub("self")
+	defer hub.Close()
+	var conns []ConnWithChan
+	for i := range 2 {
+		local, remote := net.Pipe()
+		defer remote.Close()
+		conn := addrConn{Conn: local, remoteAddr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 10000 + i}}
+		cwc, err := hub.AddConnection(conn, &switchdata.Hello{NodeId: "peer" + strconv.Itoa(i)}, true)
+		if err != nil {
+			t.Fatalf("Failed to add connection %d: %v", i, err)
+		}
+		conns = append(conns, cwc)
+	}
+	identity := newTestNodeIdentity(t)
+	withdrawn := []LocalClientAnnouncement{{Info: &entities.LocalSendClientInfo{Fingerprint: "fingerprint", Port: 53317}, Version: 1}}
+	sendLocalClientWithdrawals("self", identity, net.ParseIP("192.168.1.10"), withdrawn, hub)
+
+	for i, cwc := range conns {
+		if len(cwc.SendChan) != 1 {
+			t.Fatalf("Peer %d got %d queued messages, expected 1", i, len(cwc.SendChan))
+		}
+		msg := (<-cwc.SendChan).Payload
+		if msg.Kind != switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_WITHDRAW || msg.Fingerprint != "fingerprint" {
+			t.Errorf("Peer %d got unexpected message %v", i, msg)
+		}
+		if msg.DiscoveryTtl != configs.MaxDiscoveryMessageTTL-1 {
+			t.Errorf("Peer %d got withdrawal with TTL %d, expected %d", i, msg.DiscoveryTtl, configs.MaxDiscoveryMessageTTL-1)
+		}
+		if err := utils.VerifyDiscoveryMessageSignature(msg); err != nil {
+			t.Errorf("Peer %d got withdrawal with invalid signature: %v", i, err)
+		}
+	}
+}
@@ -61,19 +61,27 @@ type SwitchLounge struct {
 	closed bool
 	// 维护发现包 ID 的过期时间的堆
 	ttlHeap *TTLHeap
+	// 校验发现包签名，拒绝发起节点公钥不可信的发现包
+	nodeKeyring *NodeKeyring
+	// 根据时间戳和序列号窗口拒绝重放或过旧的发现包
+	replayGuard *ReplayGuard
 	// 交换数据等候区，这些数据会被转发到其他节点
 	// 每个数据不会被发向其来源节点
 	lounge chan *entities.SwitchMessage
 }
 
 // NewSwitchLounge 创建一个新的交换信息等候室
-func NewSwitchLounge() *SwitchLounge {
+//
+// nodeKeyring: 节点公钥信任管理器
+func NewSwitchLounge(nodeKeyring *NodeKeyring) *SwitchLounge {
 	ttlHeap := &TTLHeap{}
 	heap.Init(ttlHeap)
 	switchLounge := SwitchLounge{
 		closeSignal:  make(chan struct{}),
 		forwardedIds: make(map[string]bool),
 		ttlHeap:      ttlHeap,
+		nodeKeyring:  nodeKeyring,
+		replayGuard:  NewReplayGuard(),
 		lounge:       make(chan *entities.SwitchMessage, configs.SwitchLoungeSize),
 	}
 	// 过期 ID 清理协程
@@ -110,7 +118,7 @@ func NewSwitchLounge() *SwitchLounge {
 }
 
 // Write 将交换信息写入等候室，等待转发
-// 重复的发现包会被忽略
+// 重复的发现包会被忽略，签名无效、重放或过旧的发现包会返回错误
 func (sl *SwitchLounge) Write(msg *entities.SwitchMessage) error {
 	discoveryId := utils.GetDiscoveryId(msg)
 
@@ -125,6 +133,22 @@ func (sl *SwitchLounge) Write(msg *entities.SwitchMessage) error {
 		// 已经转发过，忽略，防止重放和环路
 		return nil
 	}
+	// 先校验签名，防止伪造的发现包占用发起节点的序列号窗口
+	if err := sl.nodeKeyring.Verify(msg.Payload); err != nil {
+		return err
+	}
+	// ID 缓存中没有记录的，还要通过时间戳和序列号窗口的检查，防止 ID 过期后被重放
+	if err := sl.replayGuard.Check(msg); err != nil {
+		return err
+	}
+	// 只有 Write 会写入等候通道且持有锁，通道未满时下面的写入一定成功
+	// 等候通道已满时丢弃发现包，此时不能占用序列号窗口，该发现包之后还可能经其他路径到达
+	if len(sl.lounge) >= cap(sl.lounge) {
+		return errors.New("Switch lounge is full")
+	}
+	if err := sl.replayGuard.Accept(msg); err != nil {
+		return err
+	}
 	// 如果条目过多，删除最早的一个条目
 	if len(sl.forwardedIds) >= configs.SwitchIDCacheMaxEntries {
 		if sl.ttlHeap.Len() == 0 {
@@ -136,19 +160,14 @@ func (sl *SwitchLounge) Write(msg *entities.SwitchMessage) error {
 	}
 
 	// 没有转发过，则把交换信息写入等候通道
-	select {
-	case sl.lounge <- msg:
-		// 记录该发现包 ID，防止重复转发
-		sl.forwardedIds[discoveryId] = true
-		// 加入堆中
-		heap.Push(sl.ttlHeap, &TTLHeapItem{
-			id:       discoveryId,
-			expireAt: time.Now().Add(configs.SwitchIDCacheLifetime * time.Second),
-		})
-	default:
-		// 等候通道已满，忽略写入
-		return errors.New("Switch lounge is full")
-	}
+	sl.lounge <- msg
+	// 记录该发现包 ID，防止重复转发
+	sl.forwardedIds[discoveryId] = true
+	// 加入堆中
+	heap.Push(sl.ttlHeap, &TTLHeapItem{
+		id:       discoveryId,
+		expireAt: time.Now().Add(configs.SwitchIDCacheLifetime * time.Second),
+	})
 	return nil
 }
 
@@ -169,4 +188,5 @@ func (sl *SwitchLounge) Close() {
 	sl.closed = true
 	close(sl.closeSignal)
 	close(sl.lounge)
+	sl.replayGuard.Close()
 }
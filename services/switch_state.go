@@ -0,0 +1,181 @@
+package services
+
+// 节点状态持久化模块
+//
+// 定时以及关闭时把已知的远端客户端、远端客户端最新的发现包、已知的对端节点 (包括通过对端交换得知的) 和本节点的身份保存到工作目录下的状态文件，
+// 重启时载入其中还没有过期的部分，不必等到网络重新收敛就能得知这些信息
+//
+// 状态文件总是原子地写入，写入途中崩溃也不会损坏已有的状态文件
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// switchStateParts 节点状态涉及的各个组件
+type switchStateParts struct {
+	nodeId               string
+	nodeIdentity         *utils.NodeIdentity
+	announcementStore    *AnnouncementStore
+	remoteClientRegistry *RemoteClientRegistry
+	tcpConnHub           *TCPConnectionHub
+}
+
+// saveSwitchState 把节点状态原子地写入状态文件
+//
+// path: 状态文件路径
+// parts: 节点状态涉及的各个组件
+func saveSwitchState(path string, parts switchStateParts) error {
+	now := time.Now()
+	state := &switchdata.SwitchState{
+		NodeId:        parts.nodeId,
+		NodePublicKey: parts.nodeIdentity.PublicKey(),
+		SavedAt:       now.UnixMilli(),
+		Announcements: parts.announcementStore.Entries(),
+	}
+	for _, client := range parts.remoteClientRegistry.Clients() {
+		state.RemoteClients = append(state.RemoteClients, &switchdata.RemoteClientState{
+			Fingerprint:       client.Fingerprint,
+			Alias:             client.Alias,
+			Version:           client.Version,
+			DeviceModel:       client.DeviceModel,
+			DeviceType:        client.DeviceType,
+			Address:           client.Address,
+			Protocol:          client.Protocol,
+			OriginSwitchId:    client.OriginSwitchId,
+			OriginNodeName:    client.OriginNodeName,
+			OriginSite:        client.OriginSite,
+			Hops:              int32(client.Hops),
+			FirstSeen:         client.FirstSeen.UnixMilli(),
+			LastSeen:          client.LastSeen.UnixMilli(),
+			PreviousAddresses: client.PreviousAddresses,
+			LastSeq:           client.lastSeq,
+		})
+	}
+	for _, knownPeer := range parts.tcpConnHub.KnownPeers() {
+		if now.Sub(knownPeer.LastSeen) > configs.KnownPeerLifetime*time.Second {
+			continue
+		}
+		state.Peers = append(state.Peers, &switchdata.PeerState{
+			NodeId:      knownPeer.NodeId,
+			NodeName:    knownPeer.NodeName,
+			Site:        knownPeer.Site,
+			Address:     knownPeer.Address,
+			LastSeen:    knownPeer.LastSeen.UnixMilli(),
+			LearnedFrom: knownPeer.LearnedFrom,
+		})
+	}
+	data, err := proto.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("Failed to serialize switch state: %w", err)
+	}
+	if err := utils.WriteFileAtomic(path, data, 0600); err != nil {
+		return fmt.Errorf("Failed to write state file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// loadSwitchState 从状态文件载入节点状态，只恢复还没有过期的部分
+//
+// 状态文件不存在时什么也不做；状态文件属于其他节点 (节点 ID 或身份公钥不一致) 时忽略
+//
+// path: 状态文件路径
+// parts: 节点状态涉及的各个组件
+func loadSwitchState(path string, parts switchStateParts) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("Failed to read state file '%s': %w", path, err)
+	}
+	state := &switchdata.SwitchState{}
+	if err := proto.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("Failed to parse state file '%s': %w", path, err)
+	}
+	if state.NodeId != parts.nodeId || !bytes.Equal(state.NodePublicKey, parts.nodeIdentity.PublicKey()) {
+		slog.Warn("State file belongs to another switch node, ignored", "file", path, "stateNodeId", state.NodeId, "nodeId", parts.nodeId)
+		return nil
+	}
+	now := time.Now()
+	numAnnouncements := 0
+	for _, msg := range state.Announcements {
+		if announcementFresh(msg, now) {
+			parts.announcementStore.Put(msg)
+			numAnnouncements++
+		}
+	}
+	clients := make([]RemoteClient, 0, len(state.RemoteClients))
+	for _, client := range state.RemoteClients {
+		clients = append(clients, RemoteClient{
+			Fingerprint:       client.Fingerprint,
+			Alias:             client.Alias,
+			Version:           client.Version,
+			DeviceModel:       client.DeviceModel,
+			DeviceType:        client.DeviceType,
+			Address:           client.Address,
+			Protocol:          client.Protocol,
+			OriginSwitchId:    client.OriginSwitchId,
+			OriginNodeName:    client.OriginNodeName,
+			OriginSite:        client.OriginSite,
+			Hops:              int(client.Hops),
+			FirstSeen:         time.UnixMilli(client.FirstSeen),
+			LastSeen:          time.UnixMilli(client.LastSeen),
+			PreviousAddresses: client.PreviousAddresses,
+			lastSeq:           client.LastSeq,
+		})
+	}
+	parts.remoteClientRegistry.Restore(clients)
+	peers := make([]KnownPeer, 0, len(state.Peers))
+	for _, peer := range state.Peers {
+		lastSeen := time.UnixMilli(peer.LastSeen)
+		if now.Sub(lastSeen) > configs.KnownPeerLifetime*time.Second {
+			continue
+		}
+		peers = append(peers, KnownPeer{
+			NodeId:      peer.NodeId,
+			NodeName:    peer.NodeName,
+			Site:        peer.Site,
+			Address:     peer.Address,
+			LastSeen:    lastSeen,
+			LearnedFrom: peer.LearnedFrom,
+		})
+	}
+	numPeers := parts.tcpConnHub.RestoreKnownPeers(peers)
+	slog.Info("Switch state restored", "file", path, "savedAt", time.UnixMilli(state.SavedAt).Format(time.RFC3339), "announcements", numAnnouncements, "remoteClients", len(parts.remoteClientRegistry.Clients()), "peers", numPeers)
+	return nil
+}
+
+// setUpStatePersister 定时保存节点状态，直到收到退出信号
+//
+// 关闭时的最后一次保存由调用方负责
+//
+// path: 状态文件路径
+// parts: 节点状态涉及的各个组件
+// sigCtx: 中断信号上下文
+func setUpStatePersister(path string, parts switchStateParts, sigCtx context.Context) {
+	ticker := time.NewTicker(configs.StateSaveInterval * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := saveSwitchState(path, parts); err != nil {
+				slog.Warn("Failed to save switch state", "error", err)
+				continue
+			}
+			slog.Debug("Switch state saved", "file", path)
+		case <-sigCtx.Done():
+			return
+		}
+	}
+}
@@ -4,66 +4,493 @@ package services
 
 import (
 	"errors"
+	"log/slog"
 	"net"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/somebottle/localsend-switch/configs"
 	"github.com/somebottle/localsend-switch/entities"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
 )
 
+// errDuplicateLink 表示和同一对端节点之间已经有一条更优先的链路
+var errDuplicateLink = errors.New("Duplicate link to the same switch node")
+
 // ConnWithChan 包含 TCP 连接及其发送通道
 type ConnWithChan struct {
-	Conn     *net.TCPConn
+	Conn     net.Conn
 	SendChan chan *entities.SwitchMessage
+	// 对端节点在 HELLO 帧中告知的信息
+	Peer *switchdata.Hello
+	// 是否是本节点主动发起的连接
+	Outbound bool
+	// 连接从管理器中移除时关闭
+	Done chan struct{}
+	// 链路健康情况 (往返时间、丢失的 ping 等)
+	Health *LinkHealth
+	// 发送队列的状态 (写入锁和丢弃情况)
+	SendQueue *LinkSendQueue
+	// 要发给对端的链路状态
+	LinkStateChan chan *switchdata.LinkState
+}
+
+// KnownPeer 曾经建立过链路，或者通过对端交换得知的节点
+type KnownPeer struct {
+	// 节点 ID、名称和站点
+	NodeId   string
+	NodeName string
+	Site     string
+	// 节点 TCP 服务的地址 (IP:端口)，可以主动连接，不知道时为空
+	Address string
+	// 最近一次有链路或被对端告知的时间
+	LastSeen time.Time
+	// 告知该节点的对端节点 ID，建立过链路的节点为空
+	LearnedFrom string
 }
 
 // TCPConnectionHub 管理所有 TCP 连接
 type TCPConnectionHub struct {
-	// 控制对 conns 的并发访问
+	// 控制对 conns 和 nodeLinks 的并发访问
 	mutex sync.Mutex
-	conns map[string]ConnWithChan
+	// 本节点唯一标识符
+	nodeId string
+	conns  map[string]ConnWithChan
+	// 对端节点 ID -> 连接键，同一对端节点只保留一条链路
+	nodeLinks map[string]string
+	// 对端节点 ID -> 已知的节点
+	knownPeers map[string]*KnownPeer
+	// 新链路建立时，生成要推送给对端的快照
+	snapshotSource func(peer *switchdata.Hello) []*entities.SwitchMessage
+	// 网络中各节点的链路状态
+	linkState *LinkStateDatabase
+	// 有链路建立或移除时发出通知
+	linkChanged chan struct{}
 }
 
 // NewTCPConnectionHub 创建一个新的 TCP 连接管理器
-func NewTCPConnectionHub() *TCPConnectionHub {
+//
+// nodeId: 本节点唯一标识符
+func NewTCPConnectionHub(nodeId string) *TCPConnectionHub {
 	return &TCPConnectionHub{
-		conns: make(map[string]ConnWithChan),
+		nodeId:      nodeId,
+		conns:       make(map[string]ConnWithChan),
+		nodeLinks:   make(map[string]string),
+		knownPeers:  make(map[string]*KnownPeer),
+		linkState:   NewLinkStateDatabase(nodeId),
+		linkChanged: make(chan struct{}, 1),
+	}
+}
+
+// NodeID 返回本节点唯一标识符
+func (hub *TCPConnectionHub) NodeID() string {
+	return hub.nodeId
+}
+
+// LinkState 返回网络中各节点的链路状态
+func (hub *TCPConnectionHub) LinkState() *LinkStateDatabase {
+	return hub.linkState
+}
+
+// LinkChanged 返回一个通道，有链路建立或移除时会收到通知
+func (hub *TCPConnectionHub) LinkChanged() <-chan struct{} {
+	return hub.linkChanged
+}
+
+// notifyLinkChanged 通知有链路建立或移除，已有未处理的通知时不再重复通知
+func (hub *TCPConnectionHub) notifyLinkChanged() {
+	select {
+	case hub.linkChanged <- struct{}{}:
+	default:
+	}
+}
+
+// SetSnapshotSource 设置新链路建立时生成快照的方法
+//
+// source: 根据对端的 HELLO 信息生成要推送给对端的交换消息
+func (hub *TCPConnectionHub) SetSnapshotSource(source func(peer *switchdata.Hello) []*entities.SwitchMessage) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	hub.snapshotSource = source
+}
+
+// Snapshot 生成新链路建立时要推送给对端的快照，未设置快照来源时返回 nil
+//
+// peer: 对端的 HELLO 信息
+func (hub *TCPConnectionHub) Snapshot(peer *switchdata.Hello) []*entities.SwitchMessage {
+	hub.mutex.Lock()
+	source := hub.snapshotSource
+	hub.mutex.Unlock()
+	if source == nil {
+		return nil
+	}
+	return source(peer)
+}
+
+// linkInitiator 返回一条链路的发起节点 ID
+func (hub *TCPConnectionHub) linkInitiator(peerNodeId string, outbound bool) string {
+	if outbound {
+		return hub.nodeId
 	}
+	return peerNodeId
 }
 
-// AddConnection 添加一个新的 TCP 连接到管理器，并创建其发送通道
-func (hub *TCPConnectionHub) AddConnection(conn *net.TCPConn) (<-chan *entities.SwitchMessage, error) {
+// AddConnection 添加一个新的 TCP 连接到管理器，并创建其发送通道和健康记录
+//
+// 如果和同一对端节点之间已经有链路 (比如双方互相连接)，只保留由节点 ID 较小的一方发起的链路，
+// 这样两端会独立地做出相同的选择；另一条链路被拒绝时返回 errDuplicateLink
+//
+// conn: 认证完成的连接
+// peer: 对端节点的 HELLO 信息
+// outbound: 是否是本节点主动发起的连接
+func (hub *TCPConnectionHub) AddConnection(conn net.Conn, peer *switchdata.Hello, outbound bool) (ConnWithChan, error) {
 	hub.mutex.Lock()
 	defer hub.mutex.Unlock()
 	// 使用连接发起地址 (含有端口) 作为键 (标记客户端)
 	remoteAddrStr := conn.RemoteAddr().String()
 	if _, exists := hub.conns[remoteAddrStr]; exists {
-		return nil, errors.New("Connection already exists")
+		return ConnWithChan{}, errors.New("Connection already exists")
+	}
+	// 检查和同一对端节点之间是否已有链路
+	if existingKey, exists := hub.nodeLinks[peer.NodeId]; exists {
+		existing := hub.conns[existingKey]
+		newInitiator := hub.linkInitiator(peer.NodeId, outbound)
+		existingInitiator := hub.linkInitiator(peer.NodeId, existing.Outbound)
+		if newInitiator == existingInitiator || existingInitiator == min(hub.nodeId, peer.NodeId) {
+			// 已有的链路更优先
+			return ConnWithChan{}, errDuplicateLink
+		}
+		// 新的链路更优先，替换掉已有的链路
+		hub.removeLocked(existingKey)
 	}
 	// 另外检查连接数是否超过限制
 	if len(hub.conns) >= configs.MaxTCPConnections {
-		return nil, errors.New("Maximum TCP connections reached, ignoring new connection")
+		return ConnWithChan{}, errors.New("Maximum TCP connections reached, ignoring new connection")
 	}
 	// 创建发送通道
-	sendChan := make(chan *entities.SwitchMessage, configs.TCPSocketSendChanSize)
-	hub.conns[remoteAddrStr] = ConnWithChan{
-		Conn:     conn,
-		SendChan: sendChan,
+	cwc := ConnWithChan{
+		Conn:      conn,
+		SendChan:  make(chan *entities.SwitchMessage, configs.TCPSocketSendChanSize),
+		Peer:      peer,
+		Outbound:  outbound,
+		Done:      make(chan struct{}),
+		Health:    NewLinkHealth(),
+		SendQueue: &LinkSendQueue{},
+		// 链路状态通道不会被关闭，发送协程通过 Done 得知连接已被移除
+		LinkStateChan: make(chan *switchdata.LinkState, configs.LinkStateChanSize),
+	}
+	hub.conns[remoteAddrStr] = cwc
+	hub.nodeLinks[peer.NodeId] = remoteAddrStr
+	hub.rememberPeerLocked(peer, peerListenAddress(cwc))
+	hub.notifyLinkChanged()
+	return cwc, nil
+}
+
+// peerListenAddress 返回链路另一端节点 TCP 服务的地址 (IP:端口)，不知道时返回空字符串
+//
+// 本节点主动发起的连接，对端地址就是其服务地址；对端连入的连接用的是临时端口，需要换成对端在 HELLO 中告知的服务端口
+func peerListenAddress(cwc ConnWithChan) string {
+	if cwc.Outbound {
+		return cwc.Conn.RemoteAddr().String()
 	}
-	return sendChan, nil
+	if cwc.Peer.ListenPort == 0 {
+		// 对端不接受连入
+		return ""
+	}
+	host, _, err := net.SplitHostPort(cwc.Conn.RemoteAddr().String())
+	if err != nil {
+		return ""
+	}
+	return net.JoinHostPort(host, strconv.FormatUint(uint64(cwc.Peer.ListenPort), 10))
+}
+
+// rememberPeerLocked 记录建立了链路的对端节点，调用方需持有锁
+//
+// peer: 对端节点的 HELLO 信息
+// address: 对端节点 TCP 服务的地址，不知道时为空
+func (hub *TCPConnectionHub) rememberPeerLocked(peer *switchdata.Hello, address string) {
+	knownPeer, exists := hub.knownPeers[peer.NodeId]
+	if !exists {
+		if len(hub.knownPeers) >= configs.MaxKnownPeers {
+			// 已知对端节点太多，不再记录新的
+			return
+		}
+		knownPeer = &KnownPeer{NodeId: peer.NodeId}
+		hub.knownPeers[peer.NodeId] = knownPeer
+	}
+	knownPeer.NodeName = peer.NodeName
+	knownPeer.Site = peer.Site
+	if address != "" {
+		knownPeer.Address = address
+	}
+	knownPeer.LastSeen = time.Now()
+	knownPeer.LearnedFrom = ""
+}
+
+// ReachablePeers 返回当前各条链路另一端节点中知道服务地址的那些，用于告知其他对端
+//
+// exceptNodeId: 不包含该节点 (通常是接收方自己)
+func (hub *TCPConnectionHub) ReachablePeers(exceptNodeId string) []*switchdata.PeerAddress {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	peers := make([]*switchdata.PeerAddress, 0, len(hub.conns))
+	for _, cwc := range hub.conns {
+		if cwc.Peer.NodeId == exceptNodeId {
+			continue
+		}
+		address := peerListenAddress(cwc)
+		if address == "" {
+			continue
+		}
+		peers = append(peers, &switchdata.PeerAddress{
+			NodeId:   cwc.Peer.NodeId,
+			NodeName: cwc.Peer.NodeName,
+			Site:     cwc.Peer.Site,
+			Address:  address,
+		})
+	}
+	return peers
+}
+
+// LearnPeers 记录对端告知的其他节点的服务地址，返回新知道的节点数
+//
+// 当前已有链路的节点以本节点自己看到的信息为准，不会被覆盖；
+// 回环、未指定和组播地址会被忽略，每次交换以及同一对端告知的新节点数也有上限，避免恶意对端塞满已知节点
+//
+// sourceNodeId: 告知这些节点的对端节点 ID
+// peers: 对端告知的节点
+func (hub *TCPConnectionHub) LearnPeers(sourceNodeId string, peers []*switchdata.PeerAddress) int {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	now := time.Now()
+	numLearned := 0
+	numFromSource := 0
+	for _, knownPeer := range hub.knownPeers {
+		if knownPeer.LearnedFrom == sourceNodeId {
+			numFromSource++
+		}
+	}
+	for _, peer := range peers {
+		if peer.NodeId == "" || peer.NodeId == hub.nodeId {
+			continue
+		}
+		if _, linked := hub.nodeLinks[peer.NodeId]; linked {
+			continue
+		}
+		if !isLearnablePeerAddress(peer.Address) {
+			continue
+		}
+		knownPeer, exists := hub.knownPeers[peer.NodeId]
+		if !exists {
+			if len(hub.knownPeers) >= configs.MaxKnownPeers || numLearned >= configs.PeerExchangeMaxLearnedPerExchange || numFromSource >= configs.PeerExchangeMaxLearnedPerSource {
+				continue
+			}
+			knownPeer = &KnownPeer{NodeId: peer.NodeId, LearnedFrom: sourceNodeId}
+			hub.knownPeers[peer.NodeId] = knownPeer
+			numLearned++
+			numFromSource++
+		}
+		knownPeer.NodeName = peer.NodeName
+		knownPeer.Site = peer.Site
+		knownPeer.Address = peer.Address
+		knownPeer.LastSeen = now
+	}
+	return numLearned
+}
+
+// isLearnablePeerAddress 判断对端告知的节点服务地址能否被记录
+//
+// 地址必须是 IP:端口 的形式，端口不为 0，且不能是回环、未指定或组播地址
+func isLearnablePeerAddress(address string) bool {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if port, err := strconv.ParseUint(portStr, 10, 16); err != nil || port == 0 {
+		return false
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	return !ip.IsLoopback() && !ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+// MeshCandidates 返回知道服务地址、但当前没有链路的已知节点，用于建立网状链路
+func (hub *TCPConnectionHub) MeshCandidates() []KnownPeer {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	candidates := make([]KnownPeer, 0, len(hub.knownPeers))
+	for _, knownPeer := range hub.knownPeers {
+		if _, linked := hub.nodeLinks[knownPeer.NodeId]; linked || knownPeer.Address == "" {
+			continue
+		}
+		candidates = append(candidates, *knownPeer)
+	}
+	return candidates
+}
+
+// KnownPeers 返回所有已知节点的副本
+func (hub *TCPConnectionHub) KnownPeers() []KnownPeer {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	now := time.Now()
+	peers := make([]KnownPeer, 0, len(hub.knownPeers))
+	for _, knownPeer := range hub.knownPeers {
+		if _, linked := hub.nodeLinks[knownPeer.NodeId]; linked {
+			// 当前仍有链路
+			knownPeer.LastSeen = now
+		}
+		peers = append(peers, *knownPeer)
+	}
+	return peers
+}
+
+// RestoreKnownPeers 恢复之前记录的对端节点，已经记录的节点不会被覆盖，返回恢复的节点数
+//
+// 恢复的节点和对端交换得知的节点一样要检查服务地址，由同一对端告知的节点数也有同样的上限
+//
+// peers: 之前记录的对端节点
+func (hub *TCPConnectionHub) RestoreKnownPeers(peers []KnownPeer) int {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	numRestored := 0
+	// 告知节点的对端节点 ID -> 由其告知的节点数
+	numFromSource := make(map[string]int)
+	for _, knownPeer := range hub.knownPeers {
+		if knownPeer.LearnedFrom != "" {
+			numFromSource[knownPeer.LearnedFrom]++
+		}
+	}
+	for _, knownPeer := range peers {
+		if knownPeer.NodeId == "" || knownPeer.NodeId == hub.nodeId {
+			continue
+		}
+		if _, exists := hub.knownPeers[knownPeer.NodeId]; exists {
+			continue
+		}
+		if knownPeer.Address != "" && !isLearnablePeerAddress(knownPeer.Address) {
+			continue
+		}
+		if knownPeer.LearnedFrom != "" {
+			// 通过对端交换得知的节点
+			if knownPeer.Address == "" || numFromSource[knownPeer.LearnedFrom] >= configs.PeerExchangeMaxLearnedPerSource {
+				continue
+			}
+			numFromSource[knownPeer.LearnedFrom]++
+		}
+		if len(hub.knownPeers) >= configs.MaxKnownPeers {
+			break
+		}
+		hub.knownPeers[knownPeer.NodeId] = &knownPeer
+		numRestored++
+	}
+	return numRestored
+}
+
+// removeLocked 移除指定键的连接，调用方需持有锁
+func (hub *TCPConnectionHub) removeLocked(key string) {
+	cwc, exists := hub.conns[key]
+	if !exists {
+		return
+	}
+	// 关闭发送通道
+	cwc.SendQueue.close(cwc.SendChan)
+	close(cwc.Done)
+	cwc.Conn.Close()
+	delete(hub.conns, key)
+	if hub.nodeLinks[cwc.Peer.NodeId] == key {
+		delete(hub.nodeLinks, cwc.Peer.NodeId)
+	}
+	hub.notifyLinkChanged()
 }
 
 // RemoveConnection 从管理器中移除一个 TCP 连接
-func (hub *TCPConnectionHub) RemoveConnection(conn *net.TCPConn) {
+func (hub *TCPConnectionHub) RemoveConnection(conn net.Conn) {
 	hub.mutex.Lock()
 	defer hub.mutex.Unlock()
-	remoteAddrStr := conn.RemoteAddr().String()
-	if cwc, exists := hub.conns[remoteAddrStr]; exists {
-		// 关闭发送通道
-		close(cwc.SendChan)
-		cwc.Conn.Close()
-		delete(hub.conns, remoteAddrStr)
+	hub.removeLocked(conn.RemoteAddr().String())
+}
+
+// NodeLinkDone 返回一个通道，和指定对端节点之间的链路被移除时关闭
+//
+// 如果当前没有和该节点的链路，返回的通道已经关闭
+//
+// nodeId: 对端节点 ID
+func (hub *TCPConnectionHub) NodeLinkDone(nodeId string) <-chan struct{} {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	if key, exists := hub.nodeLinks[nodeId]; exists {
+		return hub.conns[key].Done
+	}
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+// Enqueue 把交换消息放入连接的发送队列，不会阻塞，因此一条过慢的链路不会拖住其他链路
+//
+// 发送队列已满时按配置的策略处理: 丢弃队列中最旧的消息、丢弃新消息，或者断开过慢的对端；
+// 只有断开对端时才需要持有管理器的锁，其他情况只持有这条链路自己的锁
+//
+// 返回消息是否进入了发送队列，连接已被移除时返回 false
+//
+// cwc: 目标连接
+// msg: 交换消息
+func (hub *TCPConnectionHub) Enqueue(cwc ConnWithChan, msg *entities.SwitchMessage) bool {
+	enqueued, disconnect := cwc.SendQueue.push(cwc.SendChan, msg)
+	if disconnect {
+		key := cwc.Conn.RemoteAddr().String()
+		slog.Warn("Send queue of peer switch is full, disconnecting slow peer", "remoteAddr", key, "peerNodeId", cwc.Peer.NodeId, "peerNodeName", cwc.Peer.NodeName, "peerSite", cwc.Peer.Site)
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		// 同一地址可能已经换成了新的连接
+		if current, exists := hub.conns[key]; exists && current.SendChan == cwc.SendChan {
+			hub.removeLocked(key)
+		}
+	}
+	return enqueued
+}
+
+// NeighborIds 返回当前和本节点之间有链路的节点 ID
+func (hub *TCPConnectionHub) NeighborIds() []string {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	neighbors := make([]string, 0, len(hub.nodeLinks))
+	for nodeId := range hub.nodeLinks {
+		neighbors = append(neighbors, nodeId)
+	}
+	return neighbors
+}
+
+// BroadcastLinkState 把链路状态发给所有支持链路状态的对端，不会阻塞，来不及发送时丢弃 (之后会定时重发)
+//
+// state: 链路状态
+// exceptNodeId: 不发给该节点 (通常是链路状态的来源)
+func (hub *TCPConnectionHub) BroadcastLinkState(state *switchdata.LinkState, exceptNodeId string) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	for _, cwc := range hub.conns {
+		if cwc.Peer.NodeId == exceptNodeId || !peerSupportsFeature(cwc.Peer, linkFeatureLinkState) {
+			continue
+		}
+		select {
+		case cwc.LinkStateChan <- state:
+		default:
+		}
+	}
+}
+
+// NumQueuedMessages 返回所有连接的发送通道中还没有发出的交换消息数
+func (hub *TCPConnectionHub) NumQueuedMessages() int {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	numQueued := 0
+	for _, cwc := range hub.conns {
+		numQueued += len(cwc.SendChan)
 	}
+	return numQueued
 }
 
 // NumConnections 返回当前管理的连接数
@@ -101,4 +528,5 @@ func (hub *TCPConnectionHub) Close() {
 		// 连接关闭后，连接 handler 会自动从管理器中移除该连接
 		cwc.Conn.Close()
 	}
+	hub.linkState.Close()
 }
@@ -0,0 +1,325 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+)
+
+// TestEnqueueOverflowPolicy 发送队列已满时按配置的策略处理，且总是不会阻塞
+func TestEnqueueOverflowPolicy(t *testing.T) {
+	defer configs.SetSendQueuePolicy(configs.GetSendQueuePolicy())
+	const numOverflow = 5
+
+	for _, policy := range []string{configs.SendQueuePolicyDropOldest, configs.SendQueuePolicyDropNewest, configs.SendQueuePolicyDisconnect} {
+		t.Run(policy, func(t *testing.T) {
+			configs.SetSendQueuePolicy(policy)
+			hub := NewTCPConnectionHub("self")
+			defer hub.Close()
+			local, remote := net.Pipe()
+			defer remote.Close()
+			cwc, err := hub.AddConnection(local, &switchdata.Hello{NodeId: "peer"}, true)
+			if err != nil {
+				t.Fatalf("Failed to add connection: %v", err)
+			}
+			// 没有发送协程取出消息，队列填满后继续放入
+			numMessages := configs.TCPSocketSendChanSize + numOverflow
+			numEnqueued := 0
+			for seq := range numMessages {
+				if hub.Enqueue(cwc, &entities.SwitchMessage{Payload: &switchdata.DiscoveryMessage{DiscoverySeq: uint64(seq)}}) {
+					numEnqueued++
+				}
+			}
+
+			switch policy {
+			case configs.SendQueuePolicyDropOldest:
+				if numEnqueued != numMessages {
+					t.Errorf("Expected all %d messages to be enqueued, got %d", numMessages, numEnqueued)
+				}
+				// 队列中保留的是最新的消息
+				first := <-cwc.SendChan
+				if first.Payload.DiscoverySeq != numOverflow {
+					t.Errorf("Expected oldest queued message to be %d, got %d", numOverflow, first.Payload.DiscoverySeq)
+				}
+			case configs.SendQueuePolicyDropNewest:
+				if numEnqueued != configs.TCPSocketSendChanSize {
+					t.Errorf("Expected %d messages to be enqueued, got %d", configs.TCPSocketSendChanSize, numEnqueued)
+				}
+				first := <-cwc.SendChan
+				if first.Payload.DiscoverySeq != 0 {
+					t.Errorf("Expected oldest queued message to be 0, got %d", first.Payload.DiscoverySeq)
+				}
+			case configs.SendQueuePolicyDisconnect:
+				if numEnqueued != configs.TCPSocketSendChanSize {
+					t.Errorf("Expected %d messages to be enqueued, got %d", configs.TCPSocketSendChanSize, numEnqueued)
+				}
+				// 过慢的对端被断开，连接从管理器中移除
+				select {
+				case <-cwc.Done:
+				default:
+					t.Errorf("Slow peer was not disconnected")
+				}
+				if hub.NumConnections() != 0 {
+					t.Errorf("Expected slow peer to be removed, %d connections left", hub.NumConnections())
+				}
+			}
+			if dropped := cwc.SendQueue.Dropped(); dropped == 0 {
+				t.Errorf("Expected dropped messages to be counted")
+			}
+		})
+	}
+}
+
+// TestEnqueueStalledLinkDoesNotBlockOthers 一条链路的发送队列卡住 (其锁被长时间持有) 时，放入其他链路的消息不受影响
+func TestEnqueueStalledLinkDoesNotBlockOthers(t *testing.T) {
+	hub := NewTCPConnectionHub("self")
+	defer hub.Close()
+	stalled, err := hub.AddConnection(newTestAddrConn(t, 7001), &switchdata.Hello{NodeId: "stalled"}, true)
+	if err != nil {
+		t.Fatalf("Failed to add stalled connection: %v", err)
+	}
+	other, err := hub.AddConnection(newTestAddrConn(t, 7002), &switchdata.Hello{NodeId: "other"}, true)
+	if err != nil {
+		t.Fatalf("Failed to add connection: %v", err)
+	}
+
+	stalled.SendQueue.mutex.Lock()
+	stalledDone := make(chan bool)
+	go func() {
+		stalledDone <- hub.Enqueue(stalled, &entities.SwitchMessage{Payload: &switchdata.DiscoveryMessage{}})
+	}()
+	otherDone := make(chan struct{})
+	go func() {
+		defer close(otherDone)
+		// 超过队列容量，其他链路也会触发溢出处理
+		for seq := range configs.TCPSocketSendChanSize * 2 {
+			hub.Enqueue(other, &entities.SwitchMessage{Payload: &switchdata.DiscoveryMessage{DiscoverySeq: uint64(seq)}})
+		}
+	}()
+	select {
+	case <-otherDone:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Enqueue to another link was blocked by the stalled link")
+	}
+	if len(other.SendChan) != configs.TCPSocketSendChanSize {
+		t.Errorf("Expected send queue of the other link to be full, got %d messages", len(other.SendChan))
+	}
+
+	stalled.SendQueue.mutex.Unlock()
+	if !<-stalledDone {
+		t.Errorf("Expected message to be enqueued to the stalled link once it recovers")
+	}
+}
+
+// TestLearnPeersRejectsAddresses 对端告知的无效地址和回环、未指定、组播地址不会被记录
+func TestLearnPeersRejectsAddresses(t *testing.T) {
+	hub := NewTCPConnectionHub("self")
+	defer hub.Close()
+	peers := []*switchdata.PeerAddress{
+		{NodeId: "valid", Address: "192.168.1.20:7761"},
+		{NodeId: "public", Address: "203.0.113.7:7761"},
+		{NodeId: "loopback", Address: "127.0.0.1:7761"},
+		{NodeId: "loopback6", Address: "[::1]:7761"},
+		{NodeId: "unspecified", Address: "0.0.0.0:7761"},
+		{NodeId: "unspecified6", Address: "[::]:7761"},
+		{NodeId: "multicast", Address: "224.0.0.167:7761"},
+		{NodeId: "multicast6", Address: "[ff02::1]:7761"},
+		{NodeId: "hostname", Address: "example.com:7761"},
+		{NodeId: "noport", Address: "192.168.1.21"},
+		{NodeId: "zeroport", Address: "192.168.1.22:0"},
+		{NodeId: "badport", Address: "192.168.1.23:http"},
+		{NodeId: "self", Address: "192.168.1.24:7761"},
+		{NodeId: "", Address: "192.168.1.25:7761"},
+	}
+	if numLearned := hub.LearnPeers("source", peers); numLearned != 2 {
+		t.Errorf("Expected 2 peers to be learned, got %d", numLearned)
+	}
+	for _, knownPeer := range hub.KnownPeers() {
+		if knownPeer.NodeId != "valid" && knownPeer.NodeId != "public" {
+			t.Errorf("Peer %s with address %s should not be learned", knownPeer.NodeId, knownPeer.Address)
+		}
+		if knownPeer.LearnedFrom != "source" {
+			t.Errorf("Peer %s learned from %q, expected %q", knownPeer.NodeId, knownPeer.LearnedFrom, "source")
+		}
+	}
+}
+
+// TestLearnPeersLimits 每次交换以及同一对端告知的新节点数都有上限
+func TestLearnPeersLimits(t *testing.T) {
+	hub := NewTCPConnectionHub("self")
+	defer hub.Close()
+	newPeers := func(prefix string, n int) []*switchdata.PeerAddress {
+		peers := make([]*switchdata.PeerAddress, 0, n)
+		for i := range n {
+			peers = append(peers, &switchdata.PeerAddress{NodeId: prefix + strconv.Itoa(i), Address: net.JoinHostPort("10.0.0."+strconv.Itoa(i%250+1), strconv.Itoa(7000+i))})
+		}
+		return peers
+	}
+
+	// 单次交换中多出的节点被忽略
+	if numLearned := hub.LearnPeers("source", newPeers("a", configs.PeerExchangeMaxLearnedPerExchange+5)); numLearned != configs.PeerExchangeMaxLearnedPerExchange {
+		t.Fatalf("Expected %d peers to be learned in one exchange, got %d", configs.PeerExchangeMaxLearnedPerExchange, numLearned)
+	}
+	// 同一对端多次交换告知的节点总数也有上限
+	total := configs.PeerExchangeMaxLearnedPerExchange
+	for round := range configs.PeerExchangeMaxLearnedPerSource/configs.PeerExchangeMaxLearnedPerExchange + 1 {
+		total += hub.LearnPeers("source", newPeers("r"+strconv.Itoa(round)+"-", configs.PeerExchangeMaxLearnedPerExchange))
+	}
+	if total != configs.PeerExchangeMaxLearnedPerSource {
+		t.Fatalf("Expected %d peers to be learned from one source, got %d", configs.PeerExchangeMaxLearnedPerSource, total)
+	}
+	// 其他对端不受影响
+	if numLearned := hub.LearnPeers("other", newPeers("b", 1)); numLearned != 1 {
+		t.Errorf("Expected peer from another source to be learned, got %d", numLearned)
+	}
+}
+
+// TestRestoreKnownPeers 从状态文件恢复的节点和对端交换得知的节点一样检查服务地址，同一对端告知的节点数也有上限
+func TestRestoreKnownPeers(t *testing.T) {
+	hub := NewTCPConnectionHub("self")
+	defer hub.Close()
+	peers := []KnownPeer{
+		{NodeId: "linked", Address: "192.168.1.20:7761"},
+		{NodeId: "inbound"},
+		{NodeId: "learned", Address: "192.168.1.21:7761", LearnedFrom: "source"},
+		{NodeId: "loopback", Address: "127.0.0.1:7761", LearnedFrom: "source"},
+		{NodeId: "multicast", Address: "224.0.0.167:7761"},
+		{NodeId: "hostname", Address: "example.com:7761", LearnedFrom: "source"},
+		{NodeId: "noaddress", LearnedFrom: "source"},
+		{NodeId: "self", Address: "192.168.1.22:7761"},
+	}
+	// 同一对端告知的节点超过上限
+	for i := range configs.PeerExchangeMaxLearnedPerSource {
+		peers = append(peers, KnownPeer{NodeId: "many" + strconv.Itoa(i), Address: net.JoinHostPort("10.0.0."+strconv.Itoa(i%250+1), strconv.Itoa(7000+i)), LearnedFrom: "source"})
+	}
+	// 已经由 "learned" 占用了一个名额
+	expected := 2 + configs.PeerExchangeMaxLearnedPerSource
+	if numRestored := hub.RestoreKnownPeers(peers); numRestored != expected {
+		t.Fatalf("Expected %d peers to be restored, got %d", expected, numRestored)
+	}
+	numFromSource := 0
+	for _, knownPeer := range hub.KnownPeers() {
+		switch knownPeer.NodeId {
+		case "loopback", "multicast", "hostname", "noaddress", "self":
+			t.Errorf("Peer %s with address %q should not be restored", knownPeer.NodeId, knownPeer.Address)
+		}
+		if knownPeer.LearnedFrom == "source" {
+			numFromSource++
+		}
+	}
+	if numFromSource != configs.PeerExchangeMaxLearnedPerSource {
+		t.Errorf("Expected %d restored peers learned from one source, got %d", configs.PeerExchangeMaxLearnedPerSource, numFromSource)
+	}
+	// 恢复的上限和之后对端交换的上限是同一个
+	if numLearned := hub.LearnPeers("source", []*switchdata.PeerAddress{{NodeId: "later", Address: "192.168.1.23:7761"}}); numLearned != 0 {
+		t.Errorf("Expected no more peers to be learned from a source at its limit, got %d", numLearned)
+	}
+}
+
+// newTestAddrConn 创建一条远端地址为指定端口的连接
+func newTestAddrConn(t *testing.T, port int) net.Conn {
+	local, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+	return addrConn{Conn: local, remoteAddr: &net.TCPAddr{IP: net.ParseIP("192.168.1.20"), Port: port}}
+}
+
+// TestAddConnectionDuplicateLink 和同一对端节点之间有两条链路时，只保留由节点 ID 较小的一方发起的链路，同一方发起的两条链路保留先建立的
+func TestAddConnectionDuplicateLink(t *testing.T) {
+	tests := []struct {
+		name string
+		// 对端节点 ID，本节点 ID 为 "m"
+		peerNodeId       string
+		existingOutbound bool
+		newOutbound      bool
+		// 新的链路是否替换已有的链路
+		replace bool
+	}{
+		{name: "smaller peer initiates after us", peerNodeId: "a", existingOutbound: true, newOutbound: false, replace: true},
+		{name: "we initiate after smaller peer", peerNodeId: "a", existingOutbound: false, newOutbound: true},
+		{name: "we initiate after larger peer", peerNodeId: "z", existingOutbound: false, newOutbound: true, replace: true},
+		{name: "larger peer initiates after us", peerNodeId: "z", existingOutbound: true, newOutbound: false},
+		{name: "peer initiates twice", peerNodeId: "a", existingOutbound: false, newOutbound: false},
+		{name: "we initiate twice", peerNodeId: "z", existingOutbound: true, newOutbound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewTCPConnectionHub("m")
+			defer hub.Close()
+			peer := &switchdata.Hello{NodeId: tt.peerNodeId}
+			existing, err := hub.AddConnection(newTestAddrConn(t, 7001), peer, tt.existingOutbound)
+			if err != nil {
+				t.Fatalf("Failed to add existing connection: %v", err)
+			}
+			existingDone := hub.NodeLinkDone(tt.peerNodeId)
+
+			added, err := hub.AddConnection(newTestAddrConn(t, 7002), peer, tt.newOutbound)
+			if !tt.replace {
+				if !errors.Is(err, errDuplicateLink) {
+					t.Fatalf("Expected new link to be rejected as duplicate, got %v", err)
+				}
+				select {
+				case <-existingDone:
+					t.Fatalf("Existing link was removed although it wins")
+				default:
+				}
+				if hub.NumConnections() != 1 {
+					t.Errorf("Expected 1 connection, got %d", hub.NumConnections())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected new link to replace the existing one, got %v", err)
+			}
+			// 被替换的链路被移除，等待它的一方会收到通知
+			select {
+			case <-existingDone:
+			default:
+				t.Fatalf("Waiters were not notified when the existing link was replaced")
+			}
+			select {
+			case <-existing.Done:
+			default:
+				t.Errorf("Existing link was not closed")
+			}
+			if hub.NumConnections() != 1 {
+				t.Errorf("Expected 1 connection, got %d", hub.NumConnections())
+			}
+			if hub.NodeLinkDone(tt.peerNodeId) != added.Done {
+				t.Errorf("Expected the new link to be the link to peer %s", tt.peerNodeId)
+			}
+		})
+	}
+}
+
+// TestNodeLinkDone 没有链路时返回已关闭的通道，链路移除时通道关闭
+func TestNodeLinkDone(t *testing.T) {
+	hub := NewTCPConnectionHub("m")
+	defer hub.Close()
+	select {
+	case <-hub.NodeLinkDone("a"):
+	default:
+		t.Fatalf("Expected closed channel when there is no link")
+	}
+	conn := newTestAddrConn(t, 7001)
+	if _, err := hub.AddConnection(conn, &switchdata.Hello{NodeId: "a"}, true); err != nil {
+		t.Fatalf("Failed to add connection: %v", err)
+	}
+	done := hub.NodeLinkDone("a")
+	select {
+	case <-done:
+		t.Fatalf("Channel closed while the link is alive")
+	default:
+	}
+	hub.RemoveConnection(conn)
+	select {
+	case <-done:
+	default:
+		t.Errorf("Channel was not closed when the link was removed")
+	}
+}
@@ -4,13 +4,14 @@ package services
 
 import (
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
+	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/somebottle/localsend-switch/configs"
@@ -22,11 +23,14 @@ import (
 
 // handleTCPConnectionRecv 处理并维护单个 TCP 连接的接收部分
 //
-// conn: TCP 连接
+// cwc: 连接管理器中的连接 (可能是 TLS 连接)
+// linkCipher: 本连接的加密工具
+// pongChan: 收到 ping 后，把要回复的序号交给发送协程的通道
 // recvDataChan: 传递接收到的交换数据的通道
 // tcpConnHub: 维护 TCP 连接的管理器
 // sigCtx: 中断信号上下文，用于优雅关闭连接
-func handleTCPConnectionRecv(conn *net.TCPConn, recvDataChan chan<- *entities.SwitchMessage, tcpConnHub *TCPConnectionHub, sigCtx context.Context) {
+func handleTCPConnectionRecv(cwc ConnWithChan, linkCipher *utils.LinkCipher, pongChan chan<- uint64, recvDataChan chan<- *entities.SwitchMessage, tcpConnHub *TCPConnectionHub, sigCtx context.Context) {
+	conn := cwc.Conn
 	// 用来向中断信号监听协程发送退出信号的管道
 	handlerDone := make(chan struct{})
 	// 本处理协程终止后的清理
@@ -46,57 +50,91 @@ func handleTCPConnectionRecv(conn *net.TCPConn, recvDataChan chan<- *entities.Sw
 			return
 		}
 	}()
-	// 设置连接的一些传输层属性
-	conn.SetKeepAlive(true)
-	conn.SetKeepAlivePeriod(configs.TCPConnHeartbeatInterval * time.Second)
+	// 对端支持 ping 时由丢失的 ping 数判断链路存活，读取超时只作为兜底
+	readTimeout := configs.TCPConnHeartbeatInterval * time.Second
+	if peerSupportsFeature(cwc.Peer, linkFeaturePing) {
+		readTimeout = (configs.LinkDeadMissedPings + 1) * configs.LinkPingInterval * time.Second
+	}
 	// 接收数据
 	buf := make([]byte, configs.TCPSocketReadBufferSize)
-	// AES 加密工具
-	switchDataCipherUtil := utils.GetSwitchDataCipherUtilInstance()
 	for {
 		// 设置读取超时，超过心跳时间没有数据就断开连接
-		conn.SetReadDeadline(time.Now().Add(configs.TCPConnHeartbeatInterval * time.Second))
-		// 每组数据传输格式: [ 1 字节的数据类型 | 4 字节的大端数据长度 | 数据 ]
-
-		// 1 字节的数据类型
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		// 每组数据传输格式: [ 1 字节的数据类型 | 8 字节的密钥 ID | 4 字节的大端数据长度 | 数据 ]
 		//
+		// 数据类型:
 		// 0x01 - DiscoveryMessage 数据
 		// 0x02 - 心跳包
-		var dataType byte
-		if err := binary.Read(conn, binary.BigEndian, &dataType); err != nil {
-			// 读取类型失败，可能是连接出错 / 超时
+		// 0x03 - 会话密钥轮换通知
+		// 0x05 - ping
+		// 0x06 - pong
+		// 0x07 - 对端交换
+		// 0x08 - 链路状态
+		dataType, keyID, payload, err := readSealedFrame(conn, linkCipher, buf)
+		if err != nil {
+			// 读取失败，可能是连接出错 / 超时，或者数据被篡改、重放，直接丢弃连接
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, os.ErrDeadlineExceeded) {
+				slog.Debug("Failed to read frame over TCP, closing connection", "remoteAddr", conn.RemoteAddr().String(), "error", err)
+			}
 			return
 		}
 		switch dataType {
-		case 0x02:
+		case frameTypeHeartbeat:
 			// 心跳包，什么都不做，继续等待下一个数据
 			continue
-		case 0x01:
-			// DiscoveryMessage 数据
-
-			// 4 字节的数据长度
-			var dataLength uint32
-			if err := binary.Read(conn, binary.BigEndian, &dataLength); err != nil {
-				// 读取长度失败，可能是连接出错
+		case frameTypeRekey:
+			// 对端已切换发送密钥，这里同步切换同一密钥的接收密钥
+			if err := linkCipher.RotateRecvKey(keyID); err != nil {
+				slog.Error("Failed to rotate session key for receiving", "remoteAddr", conn.RemoteAddr().String(), "keyID", keyID, "error", err)
 				return
 			}
-			if dataLength > configs.TCPSocketReadBufferSize {
-				// 数据长度超过缓冲区大小，直接丢弃连接
+			slog.Debug("Rotated session key for receiving", "remoteAddr", conn.RemoteAddr().String(), "keyID", keyID)
+		case frameTypePing, frameTypePong:
+			ping := &switchdata.Ping{}
+			if err := proto.Unmarshal(payload, ping); err != nil {
+				slog.Debug("Failed to unmarshal ping received over TCP, corrupted or invalid.", "error", err)
 				return
 			}
-			// 接下来读取 dataLength 字节的数据
-			payload := buf[:dataLength]
-			if _, err := io.ReadFull(conn, payload); err != nil {
-				// 读取数据失败，可能是连接出错
+			if dataType == frameTypePing {
+				// 交给发送协程回复 pong，来不及回复时丢弃，对端会记为丢失
+				select {
+				case pongChan <- ping.Seq:
+				default:
+				}
+				continue
+			}
+			ok, changed := cwc.Health.PongReceived(ping.Seq, time.Now())
+			if !ok {
+				// 过期的 pong，忽略
+				continue
+			}
+			stats := cwc.Health.Stats()
+			slog.Debug("Received pong from peer switch", "remoteAddr", conn.RemoteAddr().String(), "rtt", stats.LastRTT, "srtt", stats.RTT, "jitter", stats.Jitter)
+			if changed {
+				logLinkHealthChange(cwc, stats)
+			}
+		case frameTypePeerExchange:
+			peerExchange := &switchdata.PeerExchange{}
+			if err := proto.Unmarshal(payload, peerExchange); err != nil {
+				slog.Debug("Failed to unmarshal peer exchange received over TCP, corrupted or invalid.", "error", err)
 				return
 			}
-			// 解密
-			payload, err := switchDataCipherUtil.Decrypt(payload)
-			if err != nil {
-				// 解密失败，可能数据被篡改，直接丢弃连接
-				slog.Debug("Failed to decrypt switch discovery message received over TCP, corrupted or invalid.", "error", err)
+			// 记录对端告知的其他节点，之后可以直接和它们建立链路
+			if numLearned := tcpConnHub.LearnPeers(cwc.Peer.NodeId, peerExchange.Peers); numLearned > 0 {
+				slog.Info("Learned switch nodes from peer switch", "remoteAddr", conn.RemoteAddr().String(), "peerNodeId", cwc.Peer.NodeId, "numLearned", numLearned)
+			}
+		case frameTypeLinkState:
+			linkState := &switchdata.LinkState{}
+			if err := proto.Unmarshal(payload, linkState); err != nil {
+				slog.Debug("Failed to unmarshal link state received over TCP, corrupted or invalid.", "error", err)
 				return
 			}
+			// 更新的链路状态继续泛洪给其他对端
+			if tcpConnHub.LinkState().Update(linkState) {
+				slog.Debug("Received link state", "remoteAddr", conn.RemoteAddr().String(), "nodeId", linkState.NodeId, "seq", linkState.Seq, "neighbors", linkState.Neighbors)
+				tcpConnHub.BroadcastLinkState(linkState, cwc.Peer.NodeId)
+			}
+		case frameTypeDiscovery:
 			// 反序列化数据
 			DiscoveryMessage := &switchdata.DiscoveryMessage{}
 			if err := proto.Unmarshal(payload, DiscoveryMessage); err != nil {
@@ -119,83 +157,189 @@ func handleTCPConnectionRecv(conn *net.TCPConn, recvDataChan chan<- *entities.Sw
 
 // handleTCPConnectionSend 处理并维护单个 TCP 连接的发送部分
 //
-// conn: TCP 连接
-// sendDataChan: 传递要发送的交换数据的通道
+// cwc: 连接管理器中的连接 (可能是 TLS 连接)
+// linkCipher: 本连接的加密工具
+// pongChan: 接收协程交来的要回复 pong 的序号
+// snapshot: 链路建立后首先推送给对端的交换消息快照
 // tcpConnHub: 维护 TCP 连接的管理器
 // sigCtx: 中断信号上下文，用于优雅关闭连接
-func handleTCPConnectionSend(conn *net.TCPConn, sendDataChan <-chan *entities.SwitchMessage, sigCtx context.Context) {
-	// 用于定时发心跳包的定时器
-	heartbeatTicker := time.NewTicker(configs.TCPConnHeartbeatSendInterval * time.Second)
+func handleTCPConnectionSend(cwc ConnWithChan, linkCipher *utils.LinkCipher, pongChan <-chan uint64, snapshot []*entities.SwitchMessage, tcpConnHub *TCPConnectionHub, sigCtx context.Context) {
+	conn := cwc.Conn
+	// 对端支持 ping 时定时发 ping，否则定时发心跳包
+	usePing := peerSupportsFeature(cwc.Peer, linkFeaturePing)
+	heartbeatInterval := configs.TCPConnHeartbeatSendInterval * time.Second
+	if usePing {
+		heartbeatInterval = configs.LinkPingInterval * time.Second
+	}
+	heartbeatTicker := time.NewTicker(heartbeatInterval)
 	defer heartbeatTicker.Stop()
-	// 设置连接的一些传输层属性
-	conn.SetKeepAlive(true)
-	conn.SetKeepAlivePeriod(configs.TCPConnHeartbeatInterval * time.Second)
+	// 对端支持时定时告知其他节点的地址
+	usePeerExchange := peerSupportsFeature(cwc.Peer, linkFeaturePeerExchange)
+	peerExchangeTicker := time.NewTicker(configs.PeerExchangeInterval * time.Second)
+	defer peerExchangeTicker.Stop()
+	// 写入一个完整的数据帧
+	writeFrameBytes := func(frame []byte) error {
+		// 设置写入超时时间
+		conn.SetWriteDeadline(time.Now().Add(configs.TCPSocketWriteTimeout * time.Second))
+		return utils.WriteAllBytes(conn, frame)
+	}
+	// 加密并发送一个数据帧，失败时说明连接已不可用 (帧序列号也已无法同步)
+	sendFrame := func(dataType byte, payload []byte) error {
+		frame, err := sealFrame(linkCipher, dataType, payload)
+		if err != nil {
+			return fmt.Errorf("Failed to seal frame: %w", err)
+		}
+		return writeFrameBytes(frame)
+	}
+	// 序列化 ping / pong 并发送
+	sendPing := func(dataType byte, seq uint64) error {
+		payload, err := proto.Marshal(&switchdata.Ping{Seq: seq})
+		if err != nil {
+			return fmt.Errorf("Failed to marshal ping: %w", err)
+		}
+		return sendFrame(dataType, payload)
+	}
+	// 在需要时轮换发送方向的会话密钥
+	rotateSendKeyIfNeeded := func() error {
+		if !linkCipher.SendKeyNeedsRotation(configs.SessionKeyRotationInterval*time.Second, configs.SessionKeyRotationMaxUses) {
+			return nil
+		}
+		// 轮换通知用旧密钥加密，对端能据此确认通知的真实性
+		if err := sendFrame(frameTypeRekey, nil); err != nil {
+			return err
+		}
+		if err := linkCipher.RotateSendKey(); err != nil {
+			return fmt.Errorf("Failed to rotate session key for sending: %w", err)
+		}
+		slog.Debug("Rotated session key for sending", "remoteAddr", conn.RemoteAddr().String())
+		return nil
+	}
+	// 发送失败时关闭连接，接收协程会随之退出并清理
+	closeOnError := func(err error) {
+		if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+			slog.Debug("Failed to send over TCP connection, closing connection", "remoteAddr", conn.RemoteAddr().String(), "error", err)
+		}
+		conn.Close()
+	}
+	// 序列化交换消息并发送 (已经预先编码的消息直接使用共享的数据帧)
+	sendSwitchMessage := func(msg *entities.SwitchMessage) error {
+		frame, err := sealSwitchMessageFrame(linkCipher, msg)
+		if err != nil {
+			// 序列化失败，忽略该数据
+			slog.Debug("Failed to marshal switch message for sending over TCP", "message", msg.Payload, "error", err)
+			return nil
+		}
+		if err := writeFrameBytes(frame); err != nil {
+			return err
+		}
+		return rotateSendKeyIfNeeded()
+	}
+	// 告知对端本节点其他链路另一端节点的地址
+	sendPeerExchange := func() error {
+		peers := tcpConnHub.ReachablePeers(cwc.Peer.NodeId)
+		if len(peers) == 0 {
+			return nil
+		}
+		payload, err := proto.Marshal(&switchdata.PeerExchange{Peers: peers})
+		if err != nil {
+			return fmt.Errorf("Failed to marshal peer exchange: %w", err)
+		}
+		return sendFrame(frameTypePeerExchange, payload)
+	}
+	// 序列化链路状态并发送
+	sendLinkState := func(state *switchdata.LinkState) error {
+		payload, err := proto.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("Failed to marshal link state: %w", err)
+		}
+		return sendFrame(frameTypeLinkState, payload)
+	}
+	// 先推送快照，让对端立即得知所有已知的客户端
+	for _, msg := range snapshot {
+		if err := sendSwitchMessage(msg); err != nil {
+			closeOnError(err)
+			return
+		}
+	}
+	if len(snapshot) > 0 {
+		slog.Debug("Pushed snapshot of known clients to peer switch", "remoteAddr", conn.RemoteAddr().String(), "numMessages", len(snapshot))
+	}
+	if usePeerExchange {
+		if err := sendPeerExchange(); err != nil {
+			closeOnError(err)
+			return
+		}
+	}
+	// 推送已知的所有链路状态，让对端尽快得知整个网络的拓扑
+	if peerSupportsFeature(cwc.Peer, linkFeatureLinkState) {
+		for _, state := range tcpConnHub.LinkState().LinkStates() {
+			if err := sendLinkState(state); err != nil {
+				closeOnError(err)
+				return
+			}
+		}
+	}
 	// 发送数据
 	for {
 		select {
 		case <-sigCtx.Done():
 			// 收到退出信号
 			return
-		case msg, ok := <-sendDataChan:
+		case msg, ok := <-cwc.SendChan:
 			if !ok {
 				// 通道关闭，退出
 				return
 			}
-			// 把数据序列化
-			payload, err := proto.Marshal(msg.Payload)
-			if err != nil {
-				// 序列化失败，忽略该数据
-				slog.Debug("Failed to marshal switch message for sending over TCP", "message", msg.Payload, "error", err)
-				continue
+			if err := sendSwitchMessage(msg); err != nil {
+				closeOnError(err)
+				return
 			}
-			// 设置写入超时时间
-			conn.SetWriteDeadline(time.Now().Add(configs.TCPSocketWriteTimeout * time.Second))
-
-			// 发送数据格式: [ 1 字节的数据类型 | 4 字节的大端数据长度 | 数据 ]
-
-			// 1 字节的数据类型
-			var dataType byte = 0x01 // DiscoveryMessage 数据
-			if err := binary.Write(conn, binary.BigEndian, dataType); err != nil {
-				if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-					// 连接已关闭，退出协程
-					return
-				}
-				// 发送类型失败，可能是连接出错
-				slog.Debug("Failed to send data type over TCP connection", "remoteAddr", conn.RemoteAddr().String(), "error", err)
-				continue
+		case state := <-cwc.LinkStateChan:
+			if err := sendLinkState(state); err != nil {
+				closeOnError(err)
+				return
 			}
-			// 加密数据
-			payload, err = utils.GetSwitchDataCipherUtilInstance().Encrypt(payload)
-			if err != nil {
-				// 加密失败，忽略该数据
-				slog.Error("Failed to encrypt switch message for sending over TCP", "message", msg.Payload, "error", err)
+		case <-peerExchangeTicker.C:
+			if !usePeerExchange {
 				continue
 			}
-			// 4 字节的大端数据长度
-			dataLength := uint32(len(payload))
-			if err := binary.Write(conn, binary.BigEndian, dataLength); err != nil {
-				// 发送长度失败，可能是连接出错
-				slog.Debug("Failed to send data length over TCP connection", "remoteAddr", conn.RemoteAddr().String(), "error", err)
-				continue
+			if err := sendPeerExchange(); err != nil {
+				closeOnError(err)
+				return
 			}
-			// 发送数据
-			if err := utils.WriteAllBytes(conn, payload); err != nil {
-				// 发送数据失败，可能是连接出错
-				slog.Debug("Failed to send data over TCP connection", "remoteAddr", conn.RemoteAddr().String(), "error", err)
-				continue
+		case seq := <-pongChan:
+			// 回复对端的 ping
+			if err := sendPing(frameTypePong, seq); err != nil {
+				closeOnError(err)
+				return
 			}
 		case <-heartbeatTicker.C:
-			// 发送心跳包
-			conn.SetWriteDeadline(time.Now().Add(configs.TCPSocketWriteTimeout * time.Second))
-			var heartbeatByte byte = 0x02
-			if err := binary.Write(conn, binary.BigEndian, heartbeatByte); err != nil {
-				if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
-					// 连接已关闭，退出协程
+			if !usePing {
+				// 发送心跳包
+				if err := sendFrame(frameTypeHeartbeat, nil); err != nil {
+					closeOnError(err)
+					return
+				}
+			} else {
+				// 发送 ping，上一个 ping 没有收到 pong 时记为丢失
+				seq, changed := cwc.Health.PingSent(time.Now())
+				if changed {
+					stats := cwc.Health.Stats()
+					logLinkHealthChange(cwc, stats)
+					if stats.State == LinkHealthDead {
+						// 链路已断开，关闭连接
+						conn.Close()
+						return
+					}
+				}
+				if err := sendPing(frameTypePing, seq); err != nil {
+					closeOnError(err)
 					return
 				}
-				// 发送心跳包失败，可能是连接出错
-				slog.Debug("Failed to send heartbeat over TCP connection", "remoteAddr", conn.RemoteAddr().String(), "error", err)
-				continue
+			}
+			if err := rotateSendKeyIfNeeded(); err != nil {
+				closeOnError(err)
+				return
 			}
 		}
 	}
@@ -203,112 +347,167 @@ func handleTCPConnectionSend(conn *net.TCPConn, sendDataChan <-chan *entities.Sw
 
 // handleTCPConnection 处理并维护单个 TCP 连接
 //
-// conn: TCP 连接
-// sendDataChan: 传递要发送的交换数据的通道
+// cwc: 连接管理器中的连接 (可能是 TLS 连接)
+// linkCipher: 本连接的加密工具 (由认证握手得到)
 // recvDataChan: 传递接收到的交换数据的通道
 // tcpConnHub: 维护 TCP 连接的管理器
 // sigCtx: 中断信号上下文，用于优雅关闭连接
-func handleTCPConnection(conn *net.TCPConn, sendDataChan <-chan *entities.SwitchMessage, recvDataChan chan<- *entities.SwitchMessage, tcpConnHub *TCPConnectionHub, sigCtx context.Context) {
+func handleTCPConnection(cwc ConnWithChan, linkCipher *utils.LinkCipher, recvDataChan chan<- *entities.SwitchMessage, tcpConnHub *TCPConnectionHub, sigCtx context.Context) {
+	// 接收协程把要回复的 pong 交给发送协程，保证只有发送协程写连接
+	pongChan := make(chan uint64, 4)
 	// 启动接收协程
-	go handleTCPConnectionRecv(conn, recvDataChan, tcpConnHub, sigCtx)
+	go handleTCPConnectionRecv(cwc, linkCipher, pongChan, recvDataChan, tcpConnHub, sigCtx)
 	// 启动发送协程
-	handleTCPConnectionSend(conn, sendDataChan, sigCtx)
+	handleTCPConnectionSend(cwc, linkCipher, pongChan, tcpConnHub.Snapshot(cwc.Peer), tcpConnHub, sigCtx)
 }
 
-// connectPeer 连接到另一个 switch 节点并维护该连接
+// logLinkHealthChange 记录链路健康状态的变化
+func logLinkHealthChange(cwc ConnWithChan, stats LinkHealthStats) {
+	attrs := []any{"remoteAddr", cwc.Conn.RemoteAddr().String(), "peerNodeId", cwc.Peer.NodeId, "peerNodeName", cwc.Peer.NodeName, "state", stats.State, "srtt", stats.RTT, "jitter", stats.Jitter, "missedPings", stats.ConsecutiveMissedPings}
+	switch stats.State {
+	case LinkHealthHealthy:
+		slog.Info("Link to peer switch is healthy again", attrs...)
+	case LinkHealthDegraded:
+		slog.Warn("Link to peer switch is degraded", attrs...)
+	case LinkHealthDead:
+		slog.Warn("Link to peer switch is dead, closing connection", attrs...)
+	}
+}
+
+// resolvePeerIPs 解析对端 switch 节点的所有 IP 地址
+func resolvePeerIPs(peer entities.PeerEndpoint, sigCtx context.Context) ([]net.IP, error) {
+	resolveCtx, cancel := context.WithTimeout(sigCtx, configs.SwitchPeerResolveTimeout*time.Second)
+	defer cancel()
+	return utils.ResolveHostIPs(resolveCtx, peer.Host)
+}
+
+// connectPeer 连接到一个对端 switch 节点并维护该连接，直至连接断开
+//
+// 对端地址可以是域名，每次连接前都会重新解析，并依次尝试解析出的所有地址；
+// 连接期间也会定期重新解析，如果当前连接的地址不再属于该域名则断开重连
+//
+// 返回 (connected, exit, err): connected 表示是否成功建立了连接，exit 表示是否不应再重连该节点
 //
-// peerAddr: 另一个 switch 节点的地址
-// peerPort: 另一个 switch 节点的端口
+// peer: 对端 switch 节点
 // tcpConnHub: 维护 TCP 连接的管理器
 // switchDataChan: 传递交换数据的通道
-// errChan: 错误通道，用于传递运行时错误
 // sigCtx: 中断信号上下文，用于优雅关闭协程
-func connectPeer(peerAddr string, peerPort string, tcpConnHub *TCPConnectionHub, switchDataChan chan *entities.SwitchMessage, errChan chan<- error, sigCtx context.Context) {
-	// 没有配置 peerAddr 或 peerPort 则不启动转发协程
-	if peerAddr == "" || peerPort == "" {
-		slog.Info("Peer address or port not provided, switch forwarder will not be started")
-		return
+func connectPeer(peer entities.PeerEndpoint, tcpConnHub *TCPConnectionHub, switchDataChan chan *entities.SwitchMessage, sigCtx context.Context) (bool, bool, error) {
+	port, err := strconv.Atoi(peer.Port)
+	if err != nil {
+		return false, true, fmt.Errorf("Invalid peer port: %v", err)
 	}
-	// 建立 TCP 连接重试计数器
-	var retryCount int
-	port, err := strconv.Atoi(peerPort)
+	// 每次连接前都重新解析对端地址，以应对动态 DNS
+	peerIps, err := resolvePeerIPs(peer, sigCtx)
 	if err != nil {
-		errChan <- fmt.Errorf("Invalid peer port: %v", err)
-		return
+		slog.Warn("Failed to resolve peer switch address", "peer", peer.String(), "error", err)
+		return false, false, nil
 	}
-	for {
-		exit, err := func() (bool, error) {
-			// 和另一个 switch 端建立 TCP 连接
-			conn, tcpErr := net.DialTCP("tcp", nil, &net.TCPAddr{
-				IP:   net.ParseIP(peerAddr),
-				Port: port,
-			})
-			if tcpErr != nil {
-				return false, tcpErr
-			}
-			// 用于通知中断监听协程退出的管道
-			connDone := make(chan struct{})
-			// 资源释放
-			defer func() {
-				close(connDone)
+	// 依次尝试解析出的每个地址，直至成功建立 TCP 连接
+	var conn *net.TCPConn
+	dialer := net.Dialer{Timeout: configs.SwitchPeerDialTimeout * time.Second}
+	for _, ip := range peerIps {
+		rawConn, tcpErr := dialer.DialContext(sigCtx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		if tcpErr != nil {
+			slog.Debug("Failed to dial peer switch", "peer", peer.String(), "ip", ip.String(), "error", tcpErr)
+			continue
+		}
+		conn = rawConn.(*net.TCPConn)
+		// 设置连接的一些传输层属性
+		conn.SetKeepAlive(true)
+		conn.SetKeepAlivePeriod(configs.TCPConnHeartbeatInterval * time.Second)
+		break
+	}
+	if conn == nil {
+		return false, false, nil
+	}
+	// 用于通知中断监听协程退出的管道
+	connDone := make(chan struct{})
+	// 资源释放，可能提前调用
+	releaseConn := sync.OnceFunc(func() {
+		close(connDone)
+		conn.Close()
+	})
+	defer releaseConn()
+	// 中断信号监听协程，同时定期重新解析对端地址
+	go func() {
+		resolveTicker := time.NewTicker(configs.SwitchPeerResolveInterval * time.Second)
+		defer resolveTicker.Stop()
+		connectedIp := conn.RemoteAddr().(*net.TCPAddr).IP
+		for {
+			select {
+			case <-sigCtx.Done():
+				// 接到退出信号
 				conn.Close()
-			}()
-			// 中断信号监听协程
-			go func() {
-				for {
-					select {
-					case <-sigCtx.Done():
-						// 接到退出信号
-						conn.Close()
-						return
-					case <-connDone:
-						// 退出协程
-						return
-					}
+				return
+			case <-connDone:
+				// 退出协程
+				return
+			case <-resolveTicker.C:
+				newIps, err := resolvePeerIPs(peer, sigCtx)
+				if err != nil {
+					// 解析失败时保持现有连接
+					slog.Debug("Failed to re-resolve peer switch address, keeping current connection", "peer", peer.String(), "error", err)
+					continue
+				}
+				if !utils.ContainsIP(newIps, connectedIp) {
+					// 地址已变化，断开连接以便重连到新地址
+					slog.Info("Peer switch address changed, reconnecting", "peer", peer.String(), "oldIp", connectedIp.String(), "newIps", newIps)
+					conn.Close()
+					return
 				}
-			}()
-			// 成功建立连接，重试计数重置
-			retryCount = 0
-			// 添加连接到管理器
-			sendChan, err := tcpConnHub.AddConnection(conn)
-			if err != nil {
-				// 添加失败，说明连接已存在或者超过最大连接数，这种情况下退出
-				slog.Warn("Failed to create TCP connection to peer switch", "peerAddr", peerAddr, "peerPort", peerPort, "error", err)
-				return true, nil
-			}
-			slog.Info("Established TCP connection to peer switch", "peerAddr", peerAddr, "peerPort", peerPort)
-			// 处理并维持连接
-			handleTCPConnection(conn, sendChan, switchDataChan, tcpConnHub, sigCtx)
-			if sigCtx.Err() != nil {
-				// 收到退出信号，优雅退出
-				slog.Debug("Peer connection exiting gracefully", "peerAddr", peerAddr, "peerPort", peerPort)
-				return true, nil
-			}
-			// 连接意外断开，继续重试
-			return false, nil
-		}()
-		if exit {
-			// 收到退出信号，终止协程
-			if err != nil {
-				errChan <- err
 			}
-			return
 		}
-		// 意外退出，继续重试
-		retryCount++
-		if configs.GetSwitchPeerConnectMaxRetries() < 0 {
-			// 如果为负数则无限重试
-			slog.Info("Retrying to connect to peer switch", "peerAddr", peerAddr, "peerPort", peerPort, "interval", configs.SwitchPeerConnectRetryInterval, "retryCount", retryCount, "maxRetries", "unlimited")
-		} else {
-			if retryCount > configs.GetSwitchPeerConnectMaxRetries() {
-				// 重试次数过多
-				errChan <- fmt.Errorf("Exceeded maximum retries (%d) to connect to peer switch at %s:%s", configs.GetSwitchPeerConnectMaxRetries(), peerAddr, peerPort)
-				return
-			}
-			slog.Info("Retrying to connect to peer switch", "peerAddr", peerAddr, "peerPort", peerPort, "interval", configs.SwitchPeerConnectRetryInterval, "retryCount", retryCount, "maxRetries", configs.GetSwitchPeerConnectMaxRetries())
+	}()
+	// 先完成认证握手 (预共享密钥握手或 TLS 握手)，确认对端身份并协商会话密钥
+	linkConn, linkCipher, err := secureOutboundLink(conn, peer.Host)
+	if err != nil {
+		logHandshakeError(conn, err)
+		return false, false, nil
+	}
+	// 交换 HELLO，确认对端节点身份和兼容性
+	peerHello, err := exchangeHello(linkConn, linkCipher, tcpConnHub.NodeID())
+	if err != nil {
+		if errors.Is(err, errSelfConnection) {
+			// 连接到了自己，不再重连
+			slog.Error("Peer address points to this switch itself, gave up", "peer", peer.String())
+			return false, true, nil
+		}
+		slog.Warn("Failed to exchange HELLO with peer switch", "peer", peer.String(), "error", err)
+		return false, false, nil
+	}
+	// 添加连接到管理器
+	cwc, err := tcpConnHub.AddConnection(linkConn, peerHello, true)
+	if errors.Is(err, errDuplicateLink) {
+		// 和该节点之间已有一条更优先的链路 (比如对方也连接了本节点)，等它断开后再重连
+		slog.Info("Already linked to peer switch through another connection, waiting for it to close", "peer", peer.String(), "peerNodeId", peerHello.NodeId)
+		// 先关闭这条重复的连接，等待期间不占用套接字
+		linkConn.Close()
+		releaseConn()
+		select {
+		case <-tcpConnHub.NodeLinkDone(peerHello.NodeId):
+			return true, false, nil
+		case <-sigCtx.Done():
+			return true, true, nil
 		}
-		time.Sleep(configs.SwitchPeerConnectRetryInterval * time.Second)
 	}
+	if err != nil {
+		// 添加失败，说明连接已存在或者超过最大连接数，这种情况下不再重连
+		slog.Warn("Failed to create TCP connection to peer switch", "peer", peer.String(), "error", err)
+		return false, true, nil
+	}
+	slog.Info("Established TCP connection to peer switch", "peer", peer.String(), "remoteAddr", conn.RemoteAddr().String(), "priority", peer.Priority, "peerNodeId", peerHello.NodeId, "peerNodeName", peerHello.NodeName, "peerSite", peerHello.Site, "peerVersion", peerHello.SoftwareVersion)
+	// 处理并维持连接
+	handleTCPConnection(cwc, linkCipher, switchDataChan, tcpConnHub, sigCtx)
+	if sigCtx.Err() != nil {
+		// 收到退出信号，优雅退出
+		slog.Debug("Peer connection exiting gracefully", "peer", peer.String())
+		return true, true, nil
+	}
+	// 连接意外断开，可以重连
+	stats := cwc.Health.Stats()
+	slog.Info("Lost TCP connection to peer switch", "peer", peer.String(), "state", stats.State, "srtt", stats.RTT, "pingsSent", stats.PingsSent, "missedPings", stats.MissedPings, "droppedMessages", cwc.SendQueue.Dropped())
+	return true, false, nil
 }
 
 // setUpTCPServer 通过 TCP 接收来自其他节点的交换数据
@@ -361,6 +560,8 @@ func setUpTCPServer(servPort string, tcpConnHub *TCPConnectionHub, dataChan chan
 				}
 			}()
 			slog.Info("TCP Server listening on port", "port", servPort)
+			// 限制同时进行中的入站握手数，防止大量不完成握手的连接耗尽协程和文件描述符
+			pendingHandshakes := make(chan struct{}, configs.GetMaxPendingHandshakes())
 			// 接受连接
 			for {
 				tcpListener.SetDeadline(time.Now().Add(configs.TCPAcceptTimeout * time.Second))
@@ -373,17 +574,53 @@ func setUpTCPServer(servPort string, tcpConnHub *TCPConnectionHub, dataChan chan
 					}
 					continue
 				}
-				// 添加连接到管理器
-				sendChan, err := tcpConnHub.AddConnection(conn)
-				if err != nil {
-					// 添加失败，说明连接已存在或者超过最大连接数
-					slog.Warn("Failed to add TCP connection", "remoteAddr", conn.RemoteAddr().String(), "error", err)
+				// 设置连接的一些传输层属性
+				conn.SetKeepAlive(true)
+				conn.SetKeepAlivePeriod(configs.TCPConnHeartbeatInterval * time.Second)
+				select {
+				case pendingHandshakes <- struct{}{}:
+				default:
+					// 进行中的握手过多，立即关闭新连接
+					slog.Debug("Too many pending handshakes, closing incoming connection", "remoteAddr", conn.RemoteAddr().String(), "maxPendingHandshakes", cap(pendingHandshakes))
 					conn.Close()
 					continue
 				}
-				// 处理连接
-				go handleTCPConnection(conn, sendChan, dataChan, tcpConnHub, sigCtx)
-				slog.Info("Accepted TCP connection", "remoteAddr", conn.RemoteAddr().String())
+				// 在单独的协程中完成认证握手，避免阻塞接受其他连接
+				go func() {
+					linkConn, linkCipher, err := secureInboundLink(conn)
+					if err != nil {
+						<-pendingHandshakes
+						logHandshakeError(conn, err)
+						conn.Close()
+						return
+					}
+					// 交换 HELLO，确认对端节点身份和兼容性
+					peerHello, err := exchangeHello(linkConn, linkCipher, tcpConnHub.NodeID())
+					// 握手结束，释放名额
+					<-pendingHandshakes
+					if err != nil {
+						slog.Warn("Failed to exchange HELLO with peer switch, closing connection", "remoteAddr", conn.RemoteAddr().String(), "error", err)
+						conn.Close()
+						return
+					}
+					// 添加连接到管理器
+					cwc, err := tcpConnHub.AddConnection(linkConn, peerHello, false)
+					if errors.Is(err, errDuplicateLink) {
+						// 和该节点之间已有一条更优先的链路 (比如本节点也连接了对方)
+						slog.Info("Already linked to peer switch through another connection, closing the duplicate one", "remoteAddr", conn.RemoteAddr().String(), "peerNodeId", peerHello.NodeId)
+						conn.Close()
+						return
+					}
+					if err != nil {
+						// 添加失败，说明连接已存在或者超过最大连接数
+						slog.Warn("Failed to add TCP connection", "remoteAddr", conn.RemoteAddr().String(), "peerNodeId", peerHello.NodeId, "error", err)
+						conn.Close()
+						return
+					}
+					slog.Info("Accepted TCP connection", "remoteAddr", conn.RemoteAddr().String(), "peerNodeId", peerHello.NodeId, "peerNodeName", peerHello.NodeName, "peerSite", peerHello.Site, "peerVersion", peerHello.SoftwareVersion)
+					// 处理连接
+					handleTCPConnection(cwc, linkCipher, dataChan, tcpConnHub, sigCtx)
+				}()
 			}
 		}()
 		if exit {
@@ -0,0 +1,178 @@
+package services
+
+// TCP 数据帧读写模块
+//
+// 认证握手的数据帧格式: [ 1 字节的数据类型 | 4 字节的大端数据长度 | 数据 ]
+//
+// 认证握手完成后的数据帧格式: [ 1 字节的数据类型 | 8 字节的密钥 ID | 4 字节的大端数据长度 | 数据 ]
+//
+// 认证握手完成后，所有数据帧 (包括心跳包) 的数据部分都会被加密，密钥 ID 记录了加密该数据帧的密钥 (未加密时全为 0)，
+// 数据帧头部 (包括密钥 ID) 和每个方向的序列号会一并被认证
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/somebottle/localsend-switch/entities"
+	"github.com/somebottle/localsend-switch/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// TCP 连接上传输的数据类型
+const (
+	// DiscoveryMessage 数据
+	frameTypeDiscovery byte = 0x01
+	// 心跳包 (数据为空)
+	frameTypeHeartbeat byte = 0x02
+	// 会话密钥轮换通知，发送方发出该帧后即切换到新的发送密钥
+	frameTypeRekey byte = 0x03
+	// HELLO，认证完成后双方发出的第一个数据帧，携带节点信息和能力
+	frameTypeHello byte = 0x04
+	// ping，对端收到后回复 pong
+	frameTypePing byte = 0x05
+	// pong，带回 ping 的序号
+	frameTypePong byte = 0x06
+	// 对端交换，携带本节点其他链路另一端节点的可连接地址
+	frameTypePeerExchange byte = 0x07
+	// 链路状态，用于计算无环的转发树
+	frameTypeLinkState byte = 0x08
+	// 认证握手: 发起方的挑战
+	frameTypeAuthChallenge byte = 0x10
+	// 认证握手: 接收方的响应
+	frameTypeAuthResponse byte = 0x11
+	// 认证握手: 发起方的确认
+	frameTypeAuthConfirm byte = 0x12
+)
+
+// frameHeaderSize 认证握手数据帧的头部长度
+const frameHeaderSize = 5
+
+// sealedFrameHeaderSize 认证握手完成后数据帧的头部长度
+const sealedFrameHeaderSize = 1 + utils.SecretKeyIDSize + 4
+
+// putSealedFrameHeader 写入认证握手完成后的数据帧头部
+//
+// header: 头部，长度为 sealedFrameHeaderSize
+// dataType: 数据类型
+// keyID: 加密数据帧的密钥 ID，未加密时为空
+// dataLength: 数据长度
+func putSealedFrameHeader(header []byte, dataType byte, keyID string, dataLength int) {
+	header[0] = dataType
+	clear(header[1 : 1+utils.SecretKeyIDSize])
+	// 密钥 ID 都是由 utils.DeriveSecretKeyID 生成的，解码不会出错
+	hex.Decode(header[1:1+utils.SecretKeyIDSize], []byte(keyID))
+	binary.BigEndian.PutUint32(header[1+utils.SecretKeyIDSize:sealedFrameHeaderSize], uint32(dataLength))
+}
+
+// writeFrame 向连接写入一个完整的明文数据帧，仅用于认证握手
+//
+// conn: 目标连接
+// dataType: 数据类型
+// payload: 数据
+func writeFrame(conn net.Conn, dataType byte, payload []byte) error {
+	frame := make([]byte, frameHeaderSize+len(payload))
+	frame[0] = dataType
+	binary.BigEndian.PutUint32(frame[1:frameHeaderSize], uint32(len(payload)))
+	copy(frame[frameHeaderSize:], payload)
+	return utils.WriteAllBytes(conn, frame)
+}
+
+// readFrame 从连接读取一个完整的明文数据帧，仅用于认证握手
+//
+// conn: 来源连接
+// maxSize: 允许的最大数据长度，超过则返回错误
+func readFrame(conn net.Conn, maxSize uint32) (byte, []byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return 0, nil, err
+	}
+	dataLength := binary.BigEndian.Uint32(header[1:frameHeaderSize])
+	if dataLength > maxSize {
+		return 0, nil, fmt.Errorf("Frame too large (%d bytes)", dataLength)
+	}
+	payload := make([]byte, dataLength)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+// sealFrame 用连接的加密工具构造一个完整的数据帧
+//
+// linkCipher: 本连接的加密工具
+// dataType: 数据类型
+// payload: 数据 (明文)
+func sealFrame(linkCipher *utils.LinkCipher, dataType byte, payload []byte) ([]byte, error) {
+	sealedLength := len(payload) + linkCipher.Overhead()
+	frame := make([]byte, sealedFrameHeaderSize, sealedFrameHeaderSize+sealedLength)
+	putSealedFrameHeader(frame, dataType, linkCipher.SendKeyID(), sealedLength)
+	return linkCipher.Seal(frame, frame[:sealedFrameHeaderSize], payload)
+}
+
+// encodeSwitchMessageFrame 预先把交换消息编码为明文数据帧并保存在消息中，之后发给多条链路时不必再逐条链路序列化
+//
+// 不加密的链路 (TLS 或未配置密钥) 可以直接发送这个数据帧；使用会话密钥的链路的密钥和序列号各不相同，只能共享序列化结果，仍需逐条链路加密
+//
+// msg: 交换消息，编码后不能再修改
+func encodeSwitchMessageFrame(msg *entities.SwitchMessage) error {
+	payloadLength := proto.Size(msg.Payload)
+	frame := make([]byte, sealedFrameHeaderSize, sealedFrameHeaderSize+payloadLength)
+	putSealedFrameHeader(frame, frameTypeDiscovery, "", payloadLength)
+	frame, err := proto.MarshalOptions{UseCachedSize: true}.MarshalAppend(frame, msg.Payload)
+	if err != nil {
+		return err
+	}
+	msg.Frame = frame
+	return nil
+}
+
+// sealSwitchMessageFrame 构造要在某条链路上发送的交换消息数据帧
+//
+// 消息已经预先编码时，不加密的链路直接使用共享的数据帧，加密的链路只需加密共享的序列化结果
+//
+// linkCipher: 本连接的加密工具
+// msg: 交换消息
+func sealSwitchMessageFrame(linkCipher *utils.LinkCipher, msg *entities.SwitchMessage) ([]byte, error) {
+	if msg.Frame == nil {
+		payload, err := proto.Marshal(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return sealFrame(linkCipher, frameTypeDiscovery, payload)
+	}
+	if linkCipher.Disabled() {
+		return msg.Frame, nil
+	}
+	return sealFrame(linkCipher, frameTypeDiscovery, msg.Frame[sealedFrameHeaderSize:])
+}
+
+// readSealedFrame 从连接读取一个数据帧，并按数据帧记录的密钥 ID 用连接的加密工具解密和校验
+//
+// 返回数据类型、加密该数据帧的密钥 ID 和数据，返回的数据可能引用 buf，在下一次读取前有效
+//
+// conn: 来源连接
+// linkCipher: 本连接的加密工具
+// buf: 读取用的缓冲区，其长度即允许的最大数据长度
+func readSealedFrame(conn net.Conn, linkCipher *utils.LinkCipher, buf []byte) (byte, string, []byte, error) {
+	var header [sealedFrameHeaderSize]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return 0, "", nil, err
+	}
+	keyID := hex.EncodeToString(header[1 : 1+utils.SecretKeyIDSize])
+	dataLength := binary.BigEndian.Uint32(header[1+utils.SecretKeyIDSize : sealedFrameHeaderSize])
+	if dataLength > uint32(len(buf)) {
+		return 0, "", nil, fmt.Errorf("Frame too large (%d bytes)", dataLength)
+	}
+	sealed := buf[:dataLength]
+	if _, err := io.ReadFull(conn, sealed); err != nil {
+		return 0, "", nil, err
+	}
+	payload, err := linkCipher.Open(keyID, header[:], sealed)
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("Failed to authenticate frame (type 0x%02x, key ID %s), corrupted, replayed or reordered: %w", header[0], keyID, err)
+	}
+	return header[0], keyID, payload, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/entities"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestSwitchMessage 构造一个和实际转发的发现包大小相近的交换消息
+func newTestSwitchMessage() *entities.SwitchMessage {
+	return &entities.SwitchMessage{
+		Payload: &switchdata.DiscoveryMessage{
+			SwitchId:        "B2xCxaXkkK3QnyAJ",
+			DiscoverySeq:    42,
+			DiscoveryTtl:    configs.MaxDiscoveryMessageTTL - 1,
+			Alias:           "Nice Orange",
+			Version:         "2.1",
+			DeviceModel:     "Linux",
+			DeviceType:      "desktop",
+			Fingerprint:     "8f0c3a2e7b5d4f6a9c1e3b5d7f9a1c3e5b7d9f1a3c5e7b9d1f3a5c7e9b1d3f5a",
+			Port:            53317,
+			Protocol:        "https",
+			Download:        true,
+			OriginalAddr:    "192.168.1.20",
+			OriginTimestamp: 1790000000000,
+			OriginPublicKey: bytes.Repeat([]byte{0x01}, 32),
+			OriginSignature: bytes.Repeat([]byte{0x02}, 64),
+			OriginNodeName:  "office",
+			OriginSite:      "hq",
+			Path: []*switchdata.DiscoveryHop{
+				{NodeId: "B2xCxaXkkK3QnyAJ"},
+				{NodeId: "UTaYggK075yhur8C"},
+			},
+		},
+	}
+}
+
+// newTestLinkCipherPair 创建一对互为收发方的加密工具
+func newTestLinkCipherPair(t testing.TB) (*utils.LinkCipher, *utils.LinkCipher) {
+	keyA, keyB := make([]byte, 32), make([]byte, 32)
+	rand.Read(keyA)
+	rand.Read(keyB)
+	local, err := utils.NewLinkCipher(utils.DeriveSecretKeyID("secret"), keyA, keyB)
+	if err != nil {
+		t.Fatalf("Failed to create link cipher: %v", err)
+	}
+	remote, err := utils.NewLinkCipher(utils.DeriveSecretKeyID("secret"), keyB, keyA)
+	if err != nil {
+		t.Fatalf("Failed to create link cipher: %v", err)
+	}
+	return local, remote
+}
+
+// TestSealSwitchMessageFrame 预先编码的数据帧和逐条链路编码的数据帧内容一致，加密链路上也能被对端解密
+func TestSealSwitchMessageFrame(t *testing.T) {
+	perLink := newTestSwitchMessage()
+	shared := newTestSwitchMessage()
+	if err := encodeSwitchMessageFrame(shared); err != nil {
+		t.Fatalf("Failed to encode switch message: %v", err)
+	}
+
+	plaintext := utils.NewPlaintextLinkCipher()
+	expected, err := sealSwitchMessageFrame(plaintext, perLink)
+	if err != nil {
+		t.Fatalf("Failed to seal switch message: %v", err)
+	}
+	frame, err := sealSwitchMessageFrame(plaintext, shared)
+	if err != nil {
+		t.Fatalf("Failed to seal switch message: %v", err)
+	}
+	if !bytes.Equal(frame, expected) {
+		t.Fatalf("Shared plaintext frame differs from per-link frame")
+	}
+
+	// 每条加密链路的密钥和序列号各不相同，连续发送多次都要能被对端解密
+	for range 3 {
+		local, remote := newTestLinkCipherPair(t)
+		for range 3 {
+			frame, err := sealSwitchMessageFrame(local, shared)
+			if err != nil {
+				t.Fatalf("Failed to seal switch message: %v", err)
+			}
+			if frame[0] != frameTypeDiscovery {
+				t.Fatalf("Unexpected frame type 0x%02x", frame[0])
+			}
+			payload, err := remote.Open(hex.EncodeToString(frame[1:1+utils.SecretKeyIDSize]), frame[:sealedFrameHeaderSize], frame[sealedFrameHeaderSize:])
+			if err != nil {
+				t.Fatalf("Failed to open sealed frame: %v", err)
+			}
+			decoded := &switchdata.DiscoveryMessage{}
+			if err := proto.Unmarshal(payload, decoded); err != nil {
+				t.Fatalf("Failed to unmarshal switch message: %v", err)
+			}
+			if !proto.Equal(decoded, shared.Payload) {
+				t.Fatalf("Decoded switch message differs from the original")
+			}
+		}
+	}
+}
+
+// BenchmarkSwitchMessageFanOut 比较把一条交换消息发给大量链路时，逐条链路编码和共享预先编码的数据帧的开销
+func BenchmarkSwitchMessageFanOut(b *testing.B) {
+	for _, numLinks := range []int{10, 100, configs.MaxTCPConnections} {
+		for _, encrypted := range []bool{false, true} {
+			ciphers := make([]*utils.LinkCipher, numLinks)
+			for i := range ciphers {
+				if encrypted {
+					ciphers[i], _ = newTestLinkCipherPair(b)
+				} else {
+					ciphers[i] = utils.NewPlaintextLinkCipher()
+				}
+			}
+			mode := "plaintext"
+			if encrypted {
+				mode = "psk"
+			}
+			for _, preEncoded := range []bool{false, true} {
+				encoding := "per-link"
+				if preEncoded {
+					encoding = "shared"
+				}
+				b.Run(fmt.Sprintf("links=%d/%s/%s", numLinks, mode, encoding), func(b *testing.B) {
+					b.ReportAllocs()
+					for b.Loop() {
+						msg := newTestSwitchMessage()
+						if preEncoded {
+							if err := encodeSwitchMessageFrame(msg); err != nil {
+								b.Fatal(err)
+							}
+						}
+						for _, linkCipher := range ciphers {
+							if _, err := sealSwitchMessageFrame(linkCipher, msg); err != nil {
+								b.Fatal(err)
+							}
+						}
+					}
+					b.ReportMetric(float64(b.N*numLinks)/b.Elapsed().Seconds(), "frames/s")
+				})
+			}
+		}
+	}
+}
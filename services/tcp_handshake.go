@@ -0,0 +1,210 @@
+package services
+
+// TCP 连接认证握手模块
+//
+// 配置了密钥时，连接建立后双方需要先完成挑战-响应握手，证明彼此持有相同的密钥，之后连接才会被加入连接管理器
+//
+// 密钥环中可以有多个密钥 (主密钥和额外接受的密钥)，发起方总是使用主密钥，并在挑战中带上其密钥 ID，
+// 接收方据此从密钥环中选出同一密钥，因此可以逐个节点地滚动更换密钥
+//
+// 握手时双方还会为各自密钥环中的每个密钥派生一对会话密钥，之后每个数据帧都记录了加密它所用的密钥 ID，接收方按帧选择会话密钥；
+// 连接先使用握手所用的密钥，得知对端也持有本机的主密钥后 (见 HELLO)，本机发送的数据帧即改用主密钥，不必重新建立连接
+//
+// 握手流程:
+//  1. 发起方 -> 接收方: AuthChallenge { 发起方随机数, 发起方临时公钥, 密钥 ID }
+//  2. 接收方 -> 发起方: AuthResponse { 接收方随机数, 接收方临时公钥, 接收方证明 }
+//  3. 发起方 -> 接收方: AuthConfirm { 发起方证明 }
+//
+// 证明覆盖了密钥 ID、双方的随机数和临时公钥，握手成功后双方用 X25519 密钥交换的结果派生出本连接的会话密钥
+//
+// 发起方即使发现接收方的证明不正确也会发出自己的证明，这样双方都能在日志中记录密钥不匹配
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// errSecretKeyMismatch 表示对端持有的密钥和本机不同
+var errSecretKeyMismatch = errors.New("Secret key mismatch")
+
+// remoteIPString 获得连接对端的 IP 地址字符串，用于日志
+func remoteIPString(conn net.Conn) string {
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+	return conn.RemoteAddr().String()
+}
+
+// secretKeyRing 返回本机的密钥环: 主密钥和额外接受的密钥
+func secretKeyRing() []string {
+	return append([]string{configs.GetSwitchDataSecret()}, configs.GetAcceptedSwitchDataSecrets()...)
+}
+
+// secretKeyRingIDs 返回本机密钥环中各密钥的 ID，没有配置密钥时为空
+func secretKeyRingIDs() []string {
+	keyIDs := make([]string, 0)
+	for _, secret := range secretKeyRing() {
+		if secret != "" {
+			keyIDs = append(keyIDs, utils.DeriveSecretKeyID(secret))
+		}
+	}
+	return keyIDs
+}
+
+// lookupSecretByKeyID 根据密钥 ID 从密钥环 (主密钥和额外接受的密钥) 中找到对应的密钥
+func lookupSecretByKeyID(keyID string) (string, bool) {
+	for _, secret := range secretKeyRing() {
+		if secret != "" && utils.DeriveSecretKeyID(secret) == keyID {
+			return secret, true
+		}
+	}
+	return "", false
+}
+
+// readHandshakeMessage 读取指定类型的握手数据帧并反序列化
+func readHandshakeMessage(conn net.Conn, expectedType byte, msg proto.Message) error {
+	dataType, payload, err := readFrame(conn, configs.TCPHandshakeFrameMaxSize)
+	if err != nil {
+		return fmt.Errorf("Failed to read handshake frame: %w", err)
+	}
+	if dataType != expectedType {
+		return fmt.Errorf("Unexpected frame type 0x%02x during handshake (is the secret key configured on both sides?)", dataType)
+	}
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return fmt.Errorf("Failed to unmarshal handshake message: %w", err)
+	}
+	return nil
+}
+
+// writeHandshakeMessage 序列化握手消息并写入数据帧
+func writeHandshakeMessage(conn net.Conn, dataType byte, msg proto.Message) error {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal handshake message: %w", err)
+	}
+	if err := writeFrame(conn, dataType, payload); err != nil {
+		return fmt.Errorf("Failed to write handshake frame: %w", err)
+	}
+	return nil
+}
+
+// authenticateAsInitiator 作为连接发起方完成认证握手，返回本连接的加密工具
+//
+// 未配置密钥时直接返回不加密的 LinkCipher
+//
+// conn: 刚建立的连接
+func authenticateAsInitiator(conn net.Conn) (*utils.LinkCipher, error) {
+	secret := configs.GetSwitchDataSecret()
+	if secret == "" {
+		return utils.NewPlaintextLinkCipher(), nil
+	}
+	// 发起方总是使用主密钥
+	return initiateHandshake(conn, secret)
+}
+
+// initiateHandshake 作为连接发起方用指定的密钥完成认证握手
+//
+// conn: 刚建立的连接
+// secret: 发起方使用的密钥
+func initiateHandshake(conn net.Conn, secret string) (*utils.LinkCipher, error) {
+	// 握手必须在限定时间内完成
+	conn.SetDeadline(time.Now().Add(configs.TCPHandshakeTimeout * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	keyID := utils.DeriveSecretKeyID(secret)
+	authKey := utils.DeriveHandshakeAuthKey(secret)
+	initiatorNonce, err := utils.GenerateHandshakeNonce()
+	if err != nil {
+		return nil, err
+	}
+	ephemeralKey, err := utils.GenerateEphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+	initiatorPublicKey := ephemeralKey.PublicKey().Bytes()
+	// 1. 发出挑战
+	if err := writeHandshakeMessage(conn, frameTypeAuthChallenge, &switchdata.AuthChallenge{Nonce: initiatorNonce, EphemeralPublicKey: initiatorPublicKey, KeyId: keyID}); err != nil {
+		return nil, err
+	}
+	// 2. 接收响应并校验
+	response := &switchdata.AuthResponse{}
+	if err := readHandshakeMessage(conn, frameTypeAuthResponse, response); err != nil {
+		return nil, err
+	}
+	transcript := utils.BuildHandshakeTranscript([]byte(keyID), initiatorNonce, initiatorPublicKey, response.Nonce, response.EphemeralPublicKey)
+	responderVerified := utils.VerifyHandshakeProof(authKey, utils.HandshakeRoleResponder, transcript, response.Proof)
+	// 3. 无论校验结果如何都发出自己的证明，让对端也能发现密钥不匹配
+	initiatorProof := utils.ComputeHandshakeProof(authKey, utils.HandshakeRoleInitiator, transcript)
+	if err := writeHandshakeMessage(conn, frameTypeAuthConfirm, &switchdata.AuthConfirm{Proof: initiatorProof}); err != nil {
+		return nil, err
+	}
+	if !responderVerified {
+		return nil, errSecretKeyMismatch
+	}
+	return utils.DeriveLinkCipher(ephemeralKey, response.EphemeralPublicKey, secret, secretKeyRing(), transcript, utils.HandshakeRoleInitiator)
+}
+
+// authenticateAsResponder 作为连接接收方完成认证握手，返回本连接的加密工具
+//
+// 未配置密钥时直接返回不加密的 LinkCipher
+//
+// conn: 刚接受的连接
+func authenticateAsResponder(conn net.Conn) (*utils.LinkCipher, error) {
+	secret := configs.GetSwitchDataSecret()
+	if secret == "" {
+		return utils.NewPlaintextLinkCipher(), nil
+	}
+	// 未在限定时间内完成认证的连接会被关闭
+	conn.SetDeadline(time.Now().Add(configs.TCPHandshakeTimeout * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	// 1. 接收挑战
+	challenge := &switchdata.AuthChallenge{}
+	if err := readHandshakeMessage(conn, frameTypeAuthChallenge, challenge); err != nil {
+		return nil, err
+	}
+	// 根据密钥 ID 选择密钥，找不到时仍用主密钥完成握手，让双方都能发现密钥不匹配
+	if matchedSecret, found := lookupSecretByKeyID(challenge.KeyId); found {
+		secret = matchedSecret
+	}
+	authKey := utils.DeriveHandshakeAuthKey(secret)
+	// 2. 发出响应
+	responderNonce, err := utils.GenerateHandshakeNonce()
+	if err != nil {
+		return nil, err
+	}
+	ephemeralKey, err := utils.GenerateEphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+	responderPublicKey := ephemeralKey.PublicKey().Bytes()
+	transcript := utils.BuildHandshakeTranscript([]byte(challenge.KeyId), challenge.Nonce, challenge.EphemeralPublicKey, responderNonce, responderPublicKey)
+	responderProof := utils.ComputeHandshakeProof(authKey, utils.HandshakeRoleResponder, transcript)
+	if err := writeHandshakeMessage(conn, frameTypeAuthResponse, &switchdata.AuthResponse{Nonce: responderNonce, Proof: responderProof, EphemeralPublicKey: responderPublicKey}); err != nil {
+		return nil, err
+	}
+	// 3. 接收确认并校验
+	confirm := &switchdata.AuthConfirm{}
+	if err := readHandshakeMessage(conn, frameTypeAuthConfirm, confirm); err != nil {
+		return nil, err
+	}
+	if !utils.VerifyHandshakeProof(authKey, utils.HandshakeRoleInitiator, transcript, confirm.Proof) {
+		return nil, fmt.Errorf("%w (key ID of peer: %s)", errSecretKeyMismatch, challenge.KeyId)
+	}
+	return utils.DeriveLinkCipher(ephemeralKey, challenge.EphemeralPublicKey, secret, secretKeyRing(), transcript, utils.HandshakeRoleResponder)
+}
+
+// logHandshakeError 记录认证握手失败的原因
+func logHandshakeError(conn net.Conn, err error) {
+	if errors.Is(err, errSecretKeyMismatch) {
+		slog.Warn(fmt.Sprintf("Secret key mismatch with %s, closing connection", remoteIPString(conn)), "remoteAddr", conn.RemoteAddr().String(), "localKeyID", utils.DeriveSecretKeyID(configs.GetSwitchDataSecret()), "error", err)
+		return
+	}
+	slog.Warn("Authentication handshake with peer switch failed, closing connection", "remoteAddr", conn.RemoteAddr().String(), "error", err)
+}
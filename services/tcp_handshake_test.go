@@ -0,0 +1,164 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/utils"
+)
+
+// handshakeResult 一端认证握手的结果
+type handshakeResult struct {
+	linkCipher *utils.LinkCipher
+	err        error
+}
+
+// setSecretKeyRingForTest 临时设置本机的主密钥和额外接受的密钥，测试结束后恢复
+func setSecretKeyRingForTest(t *testing.T, primary string, accepted []string) {
+	prevPrimary, prevAccepted := configs.GetSwitchDataSecret(), configs.GetAcceptedSwitchDataSecrets()
+	configs.SetSwitchDataSecret(primary)
+	configs.SetAcceptedSwitchDataSecrets(accepted)
+	t.Cleanup(func() {
+		configs.SetSwitchDataSecret(prevPrimary)
+		configs.SetAcceptedSwitchDataSecrets(prevAccepted)
+	})
+}
+
+// runTestHandshake 发起方用指定密钥、接收方用本机配置的密钥环完成一次认证握手
+func runTestHandshake(initiatorSecret string) (initiator handshakeResult, responder handshakeResult) {
+	initiatorConn, responderConn := net.Pipe()
+	defer initiatorConn.Close()
+	defer responderConn.Close()
+	done := make(chan handshakeResult, 1)
+	go func() {
+		linkCipher, err := authenticateAsResponder(responderConn)
+		done <- handshakeResult{linkCipher, err}
+	}()
+	linkCipher, err := initiateHandshake(initiatorConn, initiatorSecret)
+	return handshakeResult{linkCipher, err}, <-done
+}
+
+// assertLinkCiphersPaired 两端握手得到的加密工具能互相解密对方的数据帧
+func assertLinkCiphersPaired(t *testing.T, a *utils.LinkCipher, b *utils.LinkCipher) {
+	t.Helper()
+	for _, pair := range [][2]*utils.LinkCipher{{a, b}, {b, a}} {
+		header := make([]byte, sealedFrameHeaderSize)
+		putSealedFrameHeader(header, frameTypeHeartbeat, pair[0].SendKeyID(), 0)
+		sealed, err := pair[0].Seal(nil, header, []byte("ping"))
+		if err != nil {
+			t.Fatalf("Failed to seal frame: %v", err)
+		}
+		opened, err := pair[1].Open(pair[0].SendKeyID(), header, sealed)
+		if err != nil {
+			t.Fatalf("Failed to open frame sealed by peer: %v", err)
+		}
+		if !bytes.Equal(opened, []byte("ping")) {
+			t.Fatalf("Opened frame %q differs from sealed one", opened)
+		}
+	}
+}
+
+// TestHandshakeKeyRing 接收方接受密钥环中任意密钥发起的握手，拒绝密钥 ID 不在密钥环中的握手
+func TestHandshakeKeyRing(t *testing.T) {
+	setSecretKeyRingForTest(t, "new-secret", []string{"old-secret"})
+	for _, secret := range []string{"new-secret", "old-secret"} {
+		t.Run("accept "+secret, func(t *testing.T) {
+			initiator, responder := runTestHandshake(secret)
+			if initiator.err != nil || responder.err != nil {
+				t.Fatalf("Handshake with key %s failed: initiator %v, responder %v", utils.DeriveSecretKeyID(secret), initiator.err, responder.err)
+			}
+			assertLinkCiphersPaired(t, initiator.linkCipher, responder.linkCipher)
+		})
+	}
+	t.Run("reject unknown key ID", func(t *testing.T) {
+		initiator, responder := runTestHandshake("unknown-secret")
+		if !errors.Is(initiator.err, errSecretKeyMismatch) {
+			t.Errorf("Expected initiator to detect key mismatch, got %v", initiator.err)
+		}
+		if !errors.Is(responder.err, errSecretKeyMismatch) {
+			t.Errorf("Expected responder to reject unknown key ID, got %v", responder.err)
+		}
+	})
+}
+
+// TestHandshakeWrongSecret 双方密钥不同时握手失败，且两端都能发现密钥不匹配
+func TestHandshakeWrongSecret(t *testing.T) {
+	setSecretKeyRingForTest(t, "secret", nil)
+	initiator, responder := runTestHandshake("wrong-secret")
+	if !errors.Is(initiator.err, errSecretKeyMismatch) {
+		t.Errorf("Expected initiator to detect key mismatch, got %v", initiator.err)
+	}
+	if !errors.Is(responder.err, errSecretKeyMismatch) {
+		t.Errorf("Expected responder to detect key mismatch, got %v", responder.err)
+	}
+	if initiator.linkCipher != nil || responder.linkCipher != nil {
+		t.Errorf("Link cipher was returned after a failed handshake")
+	}
+}
+
+// TestHelloSwitchesToPrimaryKey 连接用额外接受的密钥握手后，双方得知对端也持有主密钥，即在同一连接上改用主密钥发送，对端逐帧选择会话密钥
+func TestHelloSwitchesToPrimaryKey(t *testing.T) {
+	setSecretKeyRingForTest(t, "new-secret", []string{"old-secret"})
+	// 发起方仍以旧的主密钥握手
+	initiator, responder := runTestHandshake("old-secret")
+	if initiator.err != nil || responder.err != nil {
+		t.Fatalf("Handshake failed: initiator %v, responder %v", initiator.err, responder.err)
+	}
+	oldKeyID, newKeyID := utils.DeriveSecretKeyID("old-secret"), utils.DeriveSecretKeyID("new-secret")
+	if initiator.linkCipher.SendKeyID() != oldKeyID || responder.linkCipher.SendKeyID() != oldKeyID {
+		t.Fatalf("Expected both ends to start with key %s", oldKeyID)
+	}
+
+	// 双方会同时发出 HELLO，需要带缓冲的连接
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	initiatorConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer initiatorConn.Close()
+	responderConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	defer responderConn.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := exchangeHello(responderConn, responder.linkCipher, "responder")
+		done <- err
+	}()
+	if _, err := exchangeHello(initiatorConn, initiator.linkCipher, "initiator"); err != nil {
+		t.Fatalf("Initiator failed to exchange HELLO: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Responder failed to exchange HELLO: %v", err)
+	}
+	if initiator.linkCipher.SendKeyID() != newKeyID || responder.linkCipher.SendKeyID() != newKeyID {
+		t.Fatalf("Expected both ends to switch to primary key %s, got %s and %s", newKeyID, initiator.linkCipher.SendKeyID(), responder.linkCipher.SendKeyID())
+	}
+
+	// 之后的数据帧记录了新的密钥 ID，对端据此解密
+	buf := make([]byte, configs.TCPHandshakeFrameMaxSize)
+	for _, pair := range [][2]*utils.LinkCipher{{initiator.linkCipher, responder.linkCipher}, {responder.linkCipher, initiator.linkCipher}} {
+		frame, err := sealFrame(pair[0], frameTypeHeartbeat, []byte("ping"))
+		if err != nil {
+			t.Fatalf("Failed to seal frame: %v", err)
+		}
+		if _, err := initiatorConn.Write(frame); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+		dataType, keyID, payload, err := readSealedFrame(responderConn, pair[1], buf)
+		if err != nil {
+			t.Fatalf("Failed to read frame sealed with the primary key: %v", err)
+		}
+		if dataType != frameTypeHeartbeat || keyID != newKeyID || !bytes.Equal(payload, []byte("ping")) {
+			t.Errorf("Unexpected frame type 0x%02x, key ID %s, payload %q", dataType, keyID, payload)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+// 链路 HELLO 模块
+//
+// 认证握手完成后，双方发出的第一个数据帧都是 HELLO，告知对方自己的节点 ID、名称、站点、服务端口、软件版本、链路协议版本、支持的特性以及密钥环中各密钥的 ID；
+// 连接管理器据此拒绝不兼容的对端，并合并同一对节点之间的重复链路；对端也持有本机的主密钥时，本机之后发送的数据帧改用主密钥加密
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"github.com/somebottle/localsend-switch/utils"
+	"google.golang.org/protobuf/proto"
+)
+
+// 链路特性，在 HELLO 帧中告知对端
+const (
+	// 发现包带有发起节点的签名
+	linkFeatureSignedAnnouncements = "signed-announcements"
+	// 支持 ping / pong 帧，用于测量往返时间和判断链路存活
+	linkFeaturePing = "ping"
+	// 支持对端交换帧，互相告知其他节点的可连接地址
+	linkFeaturePeerExchange = "peer-exchange"
+	// 支持链路状态帧，用于计算无环的转发树
+	linkFeatureLinkState = "link-state"
+)
+
+// localLinkFeatures 本节点支持的链路特性
+var localLinkFeatures = []string{
+	linkFeatureSignedAnnouncements,
+	linkFeaturePing,
+	linkFeaturePeerExchange,
+	linkFeatureLinkState,
+}
+
+// peerSupportsFeature 判断对端是否在 HELLO 中声明了支持某个链路特性
+//
+// peer: 对端的 HELLO 信息
+// feature: 链路特性
+func peerSupportsFeature(peer *switchdata.Hello, feature string) bool {
+	return slices.Contains(peer.Features, feature)
+}
+
+// errSelfConnection 表示连接到了本节点自己
+var errSelfConnection = errors.New("Connected to this switch itself")
+
+// buildLocalHello 构造本节点的 HELLO 消息
+//
+// nodeId: 本节点唯一标识符
+func buildLocalHello(nodeId string) *switchdata.Hello {
+	return &switchdata.Hello{
+		NodeId:          nodeId,
+		NodeName:        configs.GetNodeName(),
+		SoftwareVersion: configs.AppVersion,
+		ProtocolVersion: configs.LinkProtocolVersion,
+		Features:        localLinkFeatures,
+		Site:            configs.GetNodeSite(),
+		ListenPort:      uint32(configs.GetServicePort()),
+		KeyIds:          secretKeyRingIDs(),
+	}
+}
+
+// exchangeHello 在认证完成的链路上和对端交换 HELLO 帧，并检查对端是否兼容
+//
+// conn: 认证完成的连接
+// linkCipher: 本连接的加密工具
+// nodeId: 本节点唯一标识符
+func exchangeHello(conn net.Conn, linkCipher *utils.LinkCipher, nodeId string) (*switchdata.Hello, error) {
+	// HELLO 交换必须在限定时间内完成
+	conn.SetDeadline(time.Now().Add(configs.TCPHandshakeTimeout * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	// 双方同时发出自己的 HELLO，再读取对方的
+	payload, err := proto.Marshal(buildLocalHello(nodeId))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal HELLO: %w", err)
+	}
+	frame, err := sealFrame(linkCipher, frameTypeHello, payload)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to seal HELLO frame: %w", err)
+	}
+	if err := utils.WriteAllBytes(conn, frame); err != nil {
+		return nil, fmt.Errorf("Failed to send HELLO: %w", err)
+	}
+	buf := make([]byte, configs.TCPHandshakeFrameMaxSize)
+	dataType, _, peerPayload, err := readSealedFrame(conn, linkCipher, buf)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read HELLO: %w", err)
+	}
+	if dataType != frameTypeHello {
+		return nil, fmt.Errorf("Expected HELLO frame but got type 0x%02x, the peer switch may be running an incompatible version", dataType)
+	}
+	peerHello := &switchdata.Hello{}
+	if err := proto.Unmarshal(peerPayload, peerHello); err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal HELLO: %w", err)
+	}
+	// 检查对端是否兼容
+	if peerHello.NodeId == "" {
+		return nil, errors.New("Peer switch did not provide its node ID in HELLO")
+	}
+	if peerHello.NodeId == nodeId {
+		return nil, errSelfConnection
+	}
+	if peerHello.ProtocolVersion < configs.LinkProtocolMinVersion {
+		return nil, fmt.Errorf("Incompatible link protocol version %d of peer switch %s (%s, version %s), at least version %d is required", peerHello.ProtocolVersion, peerHello.NodeId, peerHello.NodeName, peerHello.SoftwareVersion, configs.LinkProtocolMinVersion)
+	}
+	// 对端也持有本机的主密钥时，之后发送的数据帧改用主密钥 (连接可能是用对端的主密钥握手的)
+	if primaryKeyID := utils.DeriveSecretKeyID(configs.GetSwitchDataSecret()); !linkCipher.Disabled() && linkCipher.SendKeyID() != primaryKeyID && slices.Contains(peerHello.KeyIds, primaryKeyID) {
+		if linkCipher.SelectSendKey(primaryKeyID) {
+			slog.Info("Switched to primary secret key for sending to peer switch", "remoteAddr", conn.RemoteAddr().String(), "peerNodeId", peerHello.NodeId, "keyID", primaryKeyID)
+		}
+	}
+	return peerHello, nil
+}
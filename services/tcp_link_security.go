@@ -0,0 +1,103 @@
+package services
+
+// TCP 链路安全模块，按配置的模式用预共享密钥握手或 TLS 1.3 双向证书认证来保护 switch 之间的链路
+//
+// TLS 模式下链路本身已由 TLS 加密和认证，数据帧不再额外加密；
+// both 模式下服务端根据连接的第一个字节判断对端使用的模式 (TLS 记录以 0x16 开头，而预共享密钥握手以 0x10 开头)，
+// 只接受这两种握手，不会退回明文链路
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/somebottle/localsend-switch/configs"
+	"github.com/somebottle/localsend-switch/utils"
+)
+
+// tlsRecordTypeHandshake TLS 握手记录的类型字节，即 ClientHello 的第一个字节
+const tlsRecordTypeHandshake byte = 0x16
+
+// errNoLinkHandshake 表示 both 模式下对端既没有发起 TLS 握手，也没有发起预共享密钥握手
+var errNoLinkHandshake = errors.New("No TLS or PSK handshake received from peer")
+
+// bufferedConn 带读缓冲的连接，用于在不丢失数据的前提下预读连接的第一个字节
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read 从缓冲区读取数据
+func (bc *bufferedConn) Read(b []byte) (int, error) {
+	return bc.reader.Read(b)
+}
+
+// secureOutboundLink 保护主动发起的连接，返回之后用于读写的连接及其加密工具
+//
+// conn: 刚建立的 TCP 连接
+// serverName: 对端 switch 的主机名，TLS 模式下作为 SNI 发送 (对端证书不和它比对，而是按主题 / SAN 授权)
+func secureOutboundLink(conn net.Conn, serverName string) (net.Conn, *utils.LinkCipher, error) {
+	if configs.GetLinkSecurityMode() != configs.LinkSecurityModeTLS {
+		linkCipher, err := authenticateAsInitiator(conn)
+		return conn, linkCipher, err
+	}
+	tlsConfig := configs.GetLinkTLSConfig().Clone()
+	tlsConfig.ServerName = serverName
+	tlsConn := tls.Client(conn, tlsConfig)
+	ctx, cancel := context.WithTimeout(context.Background(), configs.TCPHandshakeTimeout*time.Second)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	return tlsConn, utils.NewPlaintextLinkCipher(), nil
+}
+
+// secureInboundLink 保护接受的连接，返回之后用于读写的连接及其加密工具
+//
+// conn: 刚接受的 TCP 连接
+func secureInboundLink(conn net.Conn) (net.Conn, *utils.LinkCipher, error) {
+	switch configs.GetLinkSecurityMode() {
+	case configs.LinkSecurityModeTLS:
+		return acceptTLSLink(conn)
+	case configs.LinkSecurityModeBoth:
+		// 预读第一个字节判断对端使用的模式
+		bc := &bufferedConn{Conn: conn, reader: bufio.NewReader(conn)}
+		conn.SetReadDeadline(time.Now().Add(configs.TCPHandshakeTimeout * time.Second))
+		firstByte, err := bc.reader.Peek(1)
+		conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			// 未配置密钥的对端连接后不会立即发送数据，同样拒绝
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, nil, errNoLinkHandshake
+			}
+			return nil, nil, err
+		}
+		if firstByte[0] == tlsRecordTypeHandshake {
+			return acceptTLSLink(bc)
+		}
+		if configs.GetSwitchDataSecret() == "" {
+			// 本机没有密钥时无法完成预共享密钥握手，否则会退回明文链路
+			return nil, nil, errNoLinkHandshake
+		}
+		linkCipher, err := authenticateAsResponder(bc)
+		return bc, linkCipher, err
+	default:
+		linkCipher, err := authenticateAsResponder(conn)
+		return conn, linkCipher, err
+	}
+}
+
+// acceptTLSLink 作为服务端完成 TLS 握手
+func acceptTLSLink(conn net.Conn) (net.Conn, *utils.LinkCipher, error) {
+	tlsConn := tls.Server(conn, configs.GetLinkTLSConfig())
+	ctx, cancel := context.WithTimeout(context.Background(), configs.TCPHandshakeTimeout*time.Second)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, nil, err
+	}
+	return tlsConn, utils.NewPlaintextLinkCipher(), nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/somebottle/localsend-switch/configs"
+)
+
+// setLinkSecurityForTest 临时设置链路安全模式和密钥，测试结束后恢复
+func setLinkSecurityForTest(t *testing.T, mode string, secret string) {
+	prevMode, prevSecret := configs.GetLinkSecurityMode(), configs.GetSwitchDataSecret()
+	configs.SetLinkSecurityMode(mode)
+	configs.SetSwitchDataSecret(secret)
+	t.Cleanup(func() {
+		configs.SetLinkSecurityMode(prevMode)
+		configs.SetSwitchDataSecret(prevSecret)
+	})
+}
+
+// TestSecureInboundLinkBothRejectsSilentClient both 模式下连接后不发起任何握手的对端会被拒绝，而不是退回明文链路
+func TestSecureInboundLinkBothRejectsSilentClient(t *testing.T) {
+	setLinkSecurityForTest(t, configs.LinkSecurityModeBoth, "secret")
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	// 对端保持连接但不发送任何数据，直到握手超时
+	linkConn, linkCipher, err := secureInboundLink(local)
+	if !errors.Is(err, errNoLinkHandshake) {
+		t.Fatalf("Expected silent client to be rejected, got conn %v, cipher %v, error %v", linkConn, linkCipher, err)
+	}
+}
+
+// TestSecureInboundLinkBothRejectsPlaintext both 模式下本机没有密钥时，非 TLS 的入站连接会被拒绝
+func TestSecureInboundLinkBothRejectsPlaintext(t *testing.T) {
+	setLinkSecurityForTest(t, configs.LinkSecurityModeBoth, "")
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go remote.Write([]byte{frameTypeAuthChallenge})
+	if _, _, err := secureInboundLink(local); !errors.Is(err, errNoLinkHandshake) {
+		t.Fatalf("Expected non-TLS link to be rejected without a secret key, got error %v", err)
+	}
+}
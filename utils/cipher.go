@@ -1,95 +1,409 @@
 package utils
 
 // 对交换信息根据密钥进行加密和解密的模块
+//
+// 配置的密钥 (PSK) 只用于连接建立时的认证握手，每个连接会通过 X25519 密钥交换和 HKDF 派生出独立的会话密钥，
+// 因此即使密钥日后泄露，之前记录下来的流量也无法被解密 (前向保密)
 
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
-	"sync"
+	"fmt"
+	"time"
+)
+
+// 握手证明中区分双方角色的标签
+const (
+	HandshakeRoleInitiator = "initiator"
+	HandshakeRoleResponder = "responder"
+)
 
-	"github.com/somebottle/localsend-switch/configs"
+const (
+	// handshakeAuthKeyLabel 用于从密钥派生握手认证密钥
+	handshakeAuthKeyLabel = "localsend-switch link auth v1"
+	// secretKeyIDLabel 用于从密钥派生密钥 ID
+	secretKeyIDLabel = "localsend-switch key id v1"
+	// sessionKeyLabel 用于从密钥交换结果派生会话密钥
+	sessionKeyLabel = "localsend-switch session v1"
+	// sessionRekeyLabel 用于从旧的会话密钥派生新的会话密钥
+	sessionRekeyLabel = "localsend-switch rekey v1"
+	// sessionKeySize 会话密钥长度 (AES-256)
+	sessionKeySize = 32
 )
 
-// SwitchDataCipherUtil 提供 Switch 数据加密和解密功能 (单例)
-type SwitchDataCipherUtil struct {
-	aead     cipher.AEAD
-	disabled bool // 是否没有启用加密功能，若未启用数据会原样输出
+// SecretKeyIDSize 密钥 ID 的长度 (字节)，数据帧头部中携带的是未经编码的密钥 ID
+const SecretKeyIDSize = 8
+
+// linkSession 由密钥环中某个密钥派生出的一对会话密钥
+type linkSession struct {
+	sendKey  []byte
+	sendAEAD cipher.AEAD
+	recvKey  []byte
+	recvAEAD cipher.AEAD
 }
 
-var (
-	switchDataCipherUtilInstance *SwitchDataCipherUtil
-	once                         sync.Once
-)
+// newLinkSession 根据两个方向的会话密钥创建 linkSession
+func newLinkSession(sendKey []byte, recvKey []byte) (*linkSession, error) {
+	sendAEAD, err := newGCM(sendKey)
+	if err != nil {
+		return nil, err
+	}
+	recvAEAD, err := newGCM(recvKey)
+	if err != nil {
+		return nil, err
+	}
+	return &linkSession{
+		sendKey:  sendKey,
+		sendAEAD: sendAEAD,
+		recvKey:  recvKey,
+		recvAEAD: recvAEAD,
+	}, nil
+}
 
-// Encrypt 使用 AEAD 加密数据，如果没有配置密钥这里是空操作
-func (sdcu *SwitchDataCipherUtil) Encrypt(payload []byte) ([]byte, error) {
-	if sdcu.disabled {
-		// 未启用加密功能，直接返回原始数据
-		return payload, nil
+// LinkCipher 提供单个连接上的数据加密和解密功能
+//
+// 握手时会为双方密钥环中的每个密钥各派生一对会话密钥 (两个方向各一个)，每个数据帧都记录了加密它所用的密钥 ID，
+// 接收方按数据帧中的密钥 ID 选择会话密钥，因此发送方可以随时改用另一个密钥，而不必重新建立连接
+//
+// 每个方向都维护一个从 0 开始递增的序列号，序列号不在链路上传输，而是作为 nonce 并和数据帧头部一起绑定进 AEAD 的附加数据，
+// 因此被重排、重放或反射回来的数据帧都无法通过校验
+//
+// 发送方向只会被连接的发送协程使用，接收方向只会被接收协程使用，因此不需要加锁
+type LinkCipher struct {
+	disabled        bool                    // 是否没有启用加密功能，若未启用数据会原样输出
+	sessions        map[string]*linkSession // key: 密钥 ID
+	sendKeyID       string                  // 当前发送所用的密钥 ID
+	sendSeq         uint64                  // 下一个发送的数据帧序列号
+	sendKeyBornAt   time.Time               // 当前发送密钥的启用时间
+	sendKeyUseCount uint64                  // 当前发送密钥已加密的数据数
+	recvSeq         uint64                  // 期望接收的下一个数据帧序列号
+}
+
+// newGCM 根据密钥创建 AES-256-GCM 实例
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
 	}
-	nonce := make([]byte, sdcu.aead.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
+	return cipher.NewGCM(block)
+}
+
+// NewPlaintextLinkCipher 创建一个不加密的 LinkCipher，用于没有配置密钥的情况
+func NewPlaintextLinkCipher() *LinkCipher {
+	return &LinkCipher{
+		disabled: true,
+	}
+}
+
+// NewLinkCipher 根据某个密钥派生出的两个方向的会话密钥创建 LinkCipher，发送时使用该密钥
+//
+// keyID: 会话密钥对应的密钥 ID
+// sendKey: 本机发送数据用的会话密钥
+// recvKey: 本机接收数据用的会话密钥
+func NewLinkCipher(keyID string, sendKey []byte, recvKey []byte) (*LinkCipher, error) {
+	session, err := newLinkSession(sendKey, recvKey)
+	if err != nil {
 		return nil, err
 	}
-	// [nonce || 密文]
-	encrypted := sdcu.aead.Seal(nonce, nonce, payload, nil)
-	return encrypted, nil
+	return &LinkCipher{
+		sessions:      map[string]*linkSession{keyID: session},
+		sendKeyID:     keyID,
+		sendKeyBornAt: time.Now(),
+	}, nil
 }
 
-// Decrypt 使用 AEAD 解密数据，如果没有配置密钥这里是空操作
-func (sdcu *SwitchDataCipherUtil) Decrypt(encrypted []byte) ([]byte, error) {
-	if sdcu.disabled {
+// AddSessionKeys 加入由另一个密钥派生出的两个方向的会话密钥，需要在连接的收发协程启动前调用
+//
+// keyID: 会话密钥对应的密钥 ID
+// sendKey: 本机发送数据用的会话密钥
+// recvKey: 本机接收数据用的会话密钥
+func (lc *LinkCipher) AddSessionKeys(keyID string, sendKey []byte, recvKey []byte) error {
+	session, err := newLinkSession(sendKey, recvKey)
+	if err != nil {
+		return err
+	}
+	lc.sessions[keyID] = session
+	return nil
+}
+
+// Disabled 返回是否没有启用加密功能
+func (lc *LinkCipher) Disabled() bool {
+	return lc.disabled
+}
+
+// SendKeyID 返回当前发送所用的密钥 ID，没有启用加密功能时为空
+func (lc *LinkCipher) SendKeyID() string {
+	return lc.sendKeyID
+}
+
+// SelectSendKey 之后发送的数据改用指定密钥派生出的会话密钥，没有该密钥的会话密钥时返回 false
+//
+// keyID: 密钥 ID
+func (lc *LinkCipher) SelectSendKey(keyID string) bool {
+	if lc.disabled {
+		return false
+	}
+	if _, exists := lc.sessions[keyID]; !exists {
+		return false
+	}
+	if keyID != lc.sendKeyID {
+		lc.sendKeyID = keyID
+		lc.sendKeyBornAt = time.Now()
+		lc.sendKeyUseCount = 0
+	}
+	return true
+}
+
+// Overhead 返回加密后数据相比原数据增加的长度
+func (lc *LinkCipher) Overhead() int {
+	if lc.disabled {
+		return 0
+	}
+	return lc.sessions[lc.sendKeyID].sendAEAD.Overhead()
+}
+
+// sequenceNonce 由序列号构造 AEAD nonce: [ 4 字节 0 | 8 字节大端序列号 ]
+//
+// 每个方向的密钥都是独立的，序列号又不会重复，因此 nonce 不会被重用
+func sequenceNonce(aead cipher.AEAD, seq uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], seq)
+	return nonce
+}
+
+// sequenceAdditionalData 构造 AEAD 附加数据: [ 数据帧头部 | 8 字节大端序列号 ]
+func sequenceAdditionalData(header []byte, seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte{}, header...), seq)
+}
+
+// Seal 使用当前发送密钥的会话密钥加密数据，并认证数据帧头部和发送序列号，加密结果追加到 dst 之后
+//
+// 如果没有配置密钥，数据会原样追加到 dst 之后
+//
+// dst: 追加的目标切片
+// header: 该数据的数据帧头部 (明文传输，但会被认证)，其中应带有 SendKeyID 返回的密钥 ID
+// payload: 要加密的数据
+func (lc *LinkCipher) Seal(dst []byte, header []byte, payload []byte) ([]byte, error) {
+	if lc.disabled {
+		// 未启用加密功能，直接返回原始数据
+		return append(dst, payload...), nil
+	}
+	sendAEAD := lc.sessions[lc.sendKeyID].sendAEAD
+	sealed := sendAEAD.Seal(dst, sequenceNonce(sendAEAD, lc.sendSeq), payload, sequenceAdditionalData(header, lc.sendSeq))
+	lc.sendSeq++
+	lc.sendKeyUseCount++
+	return sealed, nil
+}
+
+// Open 使用数据帧记录的密钥对应的接收会话密钥解密数据，并校验数据帧头部和接收序列号
+//
+// 如果没有配置密钥，数据会原样返回
+//
+// keyID: 数据帧记录的密钥 ID
+// header: 该数据的数据帧头部
+// sealed: 加密的数据
+func (lc *LinkCipher) Open(keyID string, header []byte, sealed []byte) ([]byte, error) {
+	if lc.disabled {
 		// 未启用加密功能，直接返回原始数据
-		return encrypted, nil
+		return sealed, nil
 	}
-	nonceSize := sdcu.aead.NonceSize()
-	if len(encrypted) < nonceSize {
+	session, exists := lc.sessions[keyID]
+	if !exists {
+		return nil, fmt.Errorf("Unknown key ID %s", keyID)
+	}
+	if len(sealed) < session.recvAEAD.Overhead() {
 		return nil, errors.New("Ciphertext too short")
 	}
-	// 分离 nonce 和密文
-	nonce, ciphertext := encrypted[:nonceSize], encrypted[nonceSize:]
-	// 解密
-	decrypted, err := sdcu.aead.Open(nil, nonce, ciphertext, nil)
+	opened, err := session.recvAEAD.Open(nil, sequenceNonce(session.recvAEAD, lc.recvSeq), sealed, sequenceAdditionalData(header, lc.recvSeq))
+	if err != nil {
+		return nil, err
+	}
+	lc.recvSeq++
+	return opened, nil
+}
+
+// SendKeyNeedsRotation 判断当前发送的会话密钥是否已经使用太久 / 太多次，需要轮换
+//
+// maxAge: 会话密钥最长使用时间
+// maxUses: 会话密钥最多加密的数据数
+func (lc *LinkCipher) SendKeyNeedsRotation(maxAge time.Duration, maxUses uint64) bool {
+	if lc.disabled {
+		return false
+	}
+	return time.Since(lc.sendKeyBornAt) >= maxAge || lc.sendKeyUseCount >= maxUses
+}
+
+// ratchetKey 从旧的会话密钥单向派生出新的会话密钥，旧密钥无法从新密钥反推
+func ratchetKey(oldKey []byte) ([]byte, error) {
+	return hkdf.Expand(sha256.New, oldKey, sessionRekeyLabel, sessionKeySize)
+}
+
+// RotateSendKey 轮换当前发送的会话密钥
+func (lc *LinkCipher) RotateSendKey() error {
+	if lc.disabled {
+		return nil
+	}
+	session := lc.sessions[lc.sendKeyID]
+	newKey, err := ratchetKey(session.sendKey)
+	if err != nil {
+		return err
+	}
+	newAEAD, err := newGCM(newKey)
+	if err != nil {
+		return err
+	}
+	session.sendKey, session.sendAEAD = newKey, newAEAD
+	lc.sendKeyBornAt = time.Now()
+	lc.sendKeyUseCount = 0
+	return nil
+}
+
+// RotateRecvKey 轮换指定密钥的接收会话密钥，需要和对端发送方向的轮换保持同步
+//
+// keyID: 对端轮换的发送密钥的 ID
+func (lc *LinkCipher) RotateRecvKey(keyID string) error {
+	if lc.disabled {
+		return nil
+	}
+	session, exists := lc.sessions[keyID]
+	if !exists {
+		return fmt.Errorf("Unknown key ID %s", keyID)
+	}
+	newKey, err := ratchetKey(session.recvKey)
 	if err != nil {
+		return err
+	}
+	newAEAD, err := newGCM(newKey)
+	if err != nil {
+		return err
+	}
+	session.recvKey, session.recvAEAD = newKey, newAEAD
+	return nil
+}
+
+// DeriveHandshakeAuthKey 从交换数据加密密钥派生出连接认证握手用的 HMAC 密钥
+//
+// 和加密数据的密钥分开派生，避免同一密钥用于不同用途
+func DeriveHandshakeAuthKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(handshakeAuthKeyLabel))
+	return mac.Sum(nil)
+}
+
+// DeriveSecretKeyID 从交换数据加密密钥派生出密钥 ID (十六进制编码)，用于在握手时和每个数据帧中告知对端所用的密钥
+//
+// 密钥 ID 是单向派生的，不会泄露密钥本身
+func DeriveSecretKeyID(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(secretKeyIDLabel))
+	return hex.EncodeToString(mac.Sum(nil)[:SecretKeyIDSize])
+}
+
+// GenerateHandshakeNonce 生成握手用的随机数
+func GenerateHandshakeNonce() ([]byte, error) {
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
 		return nil, err
 	}
-	return decrypted, nil
+	return nonce, nil
+}
+
+// GenerateEphemeralKey 生成握手用的临时 X25519 密钥对
+func GenerateEphemeralKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
 }
 
-// getCipherUtilInstance 获取 SwitchDataCipherUtil 单例实例
-func GetSwitchDataCipherUtilInstance() *SwitchDataCipherUtil {
-	var err error
-	once.Do(func() {
-		secret := configs.GetSwitchDataSecret()
-		if secret == "" {
-			// 未设置密钥，禁用加密功能
-			switchDataCipherUtilInstance = &SwitchDataCipherUtil{
-				disabled: true,
-			}
-			return
+// BuildHandshakeTranscript 把握手中双方交换的数据按顺序编码为转录，用于计算证明和派生会话密钥
+//
+// 每一项前面都带有 4 字节的大端长度，避免不同字段拼接产生歧义
+func BuildHandshakeTranscript(parts ...[]byte) []byte {
+	transcript := make([]byte, 0)
+	for _, part := range parts {
+		transcript = binary.BigEndian.AppendUint32(transcript, uint32(len(part)))
+		transcript = append(transcript, part...)
+	}
+	return transcript
+}
+
+// ComputeHandshakeProof 计算握手中一方持有密钥的证明，即 HMAC(authKey, role || transcript)
+//
+// authKey: 握手认证密钥
+// role: 证明方的角色 (HandshakeRoleInitiator / HandshakeRoleResponder)
+// transcript: 握手转录
+func ComputeHandshakeProof(authKey []byte, role string, transcript []byte) []byte {
+	mac := hmac.New(sha256.New, authKey)
+	mac.Write([]byte(role))
+	mac.Write(transcript)
+	return mac.Sum(nil)
+}
+
+// VerifyHandshakeProof 以常数时间校验握手证明
+func VerifyHandshakeProof(authKey []byte, role string, transcript []byte, proof []byte) bool {
+	expected := ComputeHandshakeProof(authKey, role, transcript)
+	return hmac.Equal(expected, proof)
+}
+
+// DeriveLinkCipher 根据 X25519 密钥交换的结果派生出连接的会话密钥，发送时使用握手所用的密钥
+//
+// 密钥环中的每个密钥各派生一对会话密钥 = HKDF(共享密钥, salt=该密钥的握手认证密钥, info=标签 || 转录)，两个方向的密钥不同；
+// 只有同样持有某个密钥的对端才能派生出对应的会话密钥
+//
+// privateKey: 本机的临时私钥
+// peerPublicKey: 对端的临时公钥
+// handshakeSecret: 握手所用的密钥
+// keyRing: 本机密钥环中的其他密钥
+// transcript: 握手转录
+// role: 本机在握手中的角色
+func DeriveLinkCipher(privateKey *ecdh.PrivateKey, peerPublicKey []byte, handshakeSecret string, keyRing []string, transcript []byte, role string) (*LinkCipher, error) {
+	peerKey, err := ecdh.X25519().NewPublicKey(peerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := privateKey.ECDH(peerKey)
+	if err != nil {
+		return nil, err
+	}
+	// deriveSessionKeys 派生由某个密钥认证的一对会话密钥，返回 (本机发送用, 本机接收用)
+	deriveSessionKeys := func(secret string) ([]byte, []byte, error) {
+		keyMaterial, err := hkdf.Key(sha256.New, sharedSecret, DeriveHandshakeAuthKey(secret), sessionKeyLabel+string(transcript), sessionKeySize*2)
+		if err != nil {
+			return nil, nil, err
 		}
-		// 生成 AES-256-GCM 密钥
-		key := sha256.Sum256([]byte(secret))
-		block, errBlock := aes.NewCipher(key[:])
-		if errBlock != nil {
-			err = errBlock
-			return
+		// 前半部分用于 发起方 -> 接收方，后半部分用于 接收方 -> 发起方
+		initiatorKey, responderKey := keyMaterial[:sessionKeySize], keyMaterial[sessionKeySize:]
+		if role == HandshakeRoleInitiator {
+			return initiatorKey, responderKey, nil
+		}
+		return responderKey, initiatorKey, nil
+	}
+	sendKey, recvKey, err := deriveSessionKeys(handshakeSecret)
+	if err != nil {
+		return nil, err
+	}
+	linkCipher, err := NewLinkCipher(DeriveSecretKeyID(handshakeSecret), sendKey, recvKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range keyRing {
+		keyID := DeriveSecretKeyID(secret)
+		if secret == "" || keyID == linkCipher.sendKeyID {
+			continue
 		}
-		aead, errAead := cipher.NewGCM(block)
-		if errAead != nil {
-			err = errAead
-			return
+		sendKey, recvKey, err := deriveSessionKeys(secret)
+		if err != nil {
+			return nil, err
 		}
-		switchDataCipherUtilInstance = &SwitchDataCipherUtil{
-			aead:     aead,
-			disabled: false,
+		if err := linkCipher.AddSessionKeys(keyID, sendKey, recvKey); err != nil {
+			return nil, err
 		}
-	})
-	if err != nil {
-		panic(err)
 	}
-	return switchDataCipherUtilInstance
+	return linkCipher, nil
 }
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// testFrameHeader 测试用的数据帧头部
+var testFrameHeader = []byte{0x01, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x00, 0x00, 0x00, 0x20}
+
+// testKeyID 测试用的密钥 ID
+const testKeyID = "0102030405060708"
+
+// newTestLinkCipherPair 创建一对互为收发方的加密工具
+func newTestLinkCipherPair(t *testing.T) (*LinkCipher, *LinkCipher) {
+	keyA, keyB := make([]byte, sessionKeySize), make([]byte, sessionKeySize)
+	rand.Read(keyA)
+	rand.Read(keyB)
+	local, err := NewLinkCipher(testKeyID, keyA, keyB)
+	if err != nil {
+		t.Fatalf("Failed to create link cipher: %v", err)
+	}
+	remote, err := NewLinkCipher(testKeyID, keyB, keyA)
+	if err != nil {
+		t.Fatalf("Failed to create link cipher: %v", err)
+	}
+	return local, remote
+}
+
+// assertLinkCipherRoundTrip sender 加密的数据帧能被 receiver 解密
+func assertLinkCipherRoundTrip(t *testing.T, sender *LinkCipher, receiver *LinkCipher, payload string) {
+	t.Helper()
+	sealed, err := sender.Seal(nil, testFrameHeader, []byte(payload))
+	if err != nil {
+		t.Fatalf("Failed to seal frame: %v", err)
+	}
+	opened, err := receiver.Open(sender.SendKeyID(), testFrameHeader, sealed)
+	if err != nil {
+		t.Fatalf("Failed to open frame %q: %v", payload, err)
+	}
+	if !bytes.Equal(opened, []byte(payload)) {
+		t.Fatalf("Opened frame %q, expected %q", opened, payload)
+	}
+}
+
+// TestLinkCipherRotateKeys 两端同步轮换会话密钥后仍能互相解密，新旧密钥不能混用
+func TestLinkCipherRotateKeys(t *testing.T) {
+	local, remote := newTestLinkCipherPair(t)
+	assertLinkCipherRoundTrip(t, local, remote, "before rotation")
+	assertLinkCipherRoundTrip(t, remote, local, "before rotation")
+
+	for range 3 {
+		// 对应 rekey 帧: 发送方轮换发送密钥，接收方收到后轮换接收密钥
+		if err := local.RotateSendKey(); err != nil {
+			t.Fatalf("Failed to rotate send key: %v", err)
+		}
+		if err := remote.RotateRecvKey(testKeyID); err != nil {
+			t.Fatalf("Failed to rotate recv key: %v", err)
+		}
+		assertLinkCipherRoundTrip(t, local, remote, "local to remote after rotation")
+		// 另一个方向的密钥不受影响
+		assertLinkCipherRoundTrip(t, remote, local, "remote to local")
+		if err := remote.RotateSendKey(); err != nil {
+			t.Fatalf("Failed to rotate send key: %v", err)
+		}
+		if err := local.RotateRecvKey(testKeyID); err != nil {
+			t.Fatalf("Failed to rotate recv key: %v", err)
+		}
+		assertLinkCipherRoundTrip(t, remote, local, "remote to local after rotation")
+		assertLinkCipherRoundTrip(t, local, remote, "local to remote")
+	}
+
+	// 只有发送方轮换了密钥时，接收方无法解密
+	if err := local.RotateSendKey(); err != nil {
+		t.Fatalf("Failed to rotate send key: %v", err)
+	}
+	sealed, err := local.Seal(nil, testFrameHeader, []byte("unsynchronized"))
+	if err != nil {
+		t.Fatalf("Failed to seal frame: %v", err)
+	}
+	if _, err := remote.Open(testKeyID, testFrameHeader, sealed); err == nil {
+		t.Errorf("Frame sealed with a rotated key was opened with the old key")
+	}
+}
+
+// TestLinkCipherSelectSendKey 发送方改用另一个密钥后，接收方按数据帧记录的密钥 ID 选择会话密钥，不必重新建立连接
+func TestLinkCipherSelectSendKey(t *testing.T) {
+	const otherKeyID = "1112131415161718"
+	local, remote := newTestLinkCipherPair(t)
+	keyA, keyB := make([]byte, sessionKeySize), make([]byte, sessionKeySize)
+	rand.Read(keyA)
+	rand.Read(keyB)
+	if err := local.AddSessionKeys(otherKeyID, keyA, keyB); err != nil {
+		t.Fatalf("Failed to add session keys: %v", err)
+	}
+	if err := remote.AddSessionKeys(otherKeyID, keyB, keyA); err != nil {
+		t.Fatalf("Failed to add session keys: %v", err)
+	}
+	assertLinkCipherRoundTrip(t, local, remote, "before switching keys")
+
+	if local.SelectSendKey("2122232425262728") {
+		t.Fatalf("Switched to a key without session keys")
+	}
+	if !local.SelectSendKey(otherKeyID) || local.SendKeyID() != otherKeyID {
+		t.Fatalf("Failed to switch to key %s", otherKeyID)
+	}
+	assertLinkCipherRoundTrip(t, local, remote, "after switching keys")
+	// 另一个方向仍然使用原来的密钥
+	assertLinkCipherRoundTrip(t, remote, local, "other direction")
+
+	// 轮换的是当前发送所用密钥的会话密钥
+	if err := local.RotateSendKey(); err != nil {
+		t.Fatalf("Failed to rotate send key: %v", err)
+	}
+	if err := remote.RotateRecvKey(otherKeyID); err != nil {
+		t.Fatalf("Failed to rotate recv key: %v", err)
+	}
+	assertLinkCipherRoundTrip(t, local, remote, "after rotation")
+
+	// 数据帧记录的密钥 ID 和加密所用的不一致，或者接收方没有该密钥时都会被拒绝
+	sealed, err := local.Seal(nil, testFrameHeader, []byte("payload"))
+	if err != nil {
+		t.Fatalf("Failed to seal frame: %v", err)
+	}
+	if _, err := remote.Open(testKeyID, testFrameHeader, sealed); err == nil {
+		t.Errorf("Frame was opened with the session keys of another key")
+	}
+	if _, err := remote.Open("2122232425262728", testFrameHeader, sealed); err == nil {
+		t.Errorf("Frame with an unknown key ID was accepted")
+	}
+}
+
+// TestLinkCipherRejectsTamperedFrames 被重放、重排、篡改头部或反射回来的数据帧都会被拒绝
+func TestLinkCipherRejectsTamperedFrames(t *testing.T) {
+	seal := func(t *testing.T, lc *LinkCipher, payload string) []byte {
+		sealed, err := lc.Seal(nil, testFrameHeader, []byte(payload))
+		if err != nil {
+			t.Fatalf("Failed to seal frame: %v", err)
+		}
+		return sealed
+	}
+
+	t.Run("replayed frame", func(t *testing.T) {
+		local, remote := newTestLinkCipherPair(t)
+		frame := seal(t, local, "first")
+		if _, err := remote.Open(testKeyID, testFrameHeader, frame); err != nil {
+			t.Fatalf("Failed to open frame: %v", err)
+		}
+		if _, err := remote.Open(testKeyID, testFrameHeader, frame); err == nil {
+			t.Errorf("Replayed frame was accepted")
+		}
+	})
+
+	t.Run("reordered frames", func(t *testing.T) {
+		local, remote := newTestLinkCipherPair(t)
+		_ = seal(t, local, "first")
+		second := seal(t, local, "second")
+		if _, err := remote.Open(testKeyID, testFrameHeader, second); err == nil {
+			t.Errorf("Frame received out of order was accepted")
+		}
+	})
+
+	t.Run("modified header", func(t *testing.T) {
+		for i := range testFrameHeader {
+			local, remote := newTestLinkCipherPair(t)
+			frame := seal(t, local, "payload")
+			header := bytes.Clone(testFrameHeader)
+			header[i] ^= 0x01
+			if _, err := remote.Open(testKeyID, header, frame); err == nil {
+				t.Errorf("Frame with modified header byte %d was accepted", i)
+			}
+		}
+	})
+
+	t.Run("modified payload", func(t *testing.T) {
+		local, remote := newTestLinkCipherPair(t)
+		frame := seal(t, local, "payload")
+		frame[0] ^= 0x01
+		if _, err := remote.Open(testKeyID, testFrameHeader, frame); err == nil {
+			t.Errorf("Frame with modified payload was accepted")
+		}
+	})
+
+	t.Run("reflected frame", func(t *testing.T) {
+		local, _ := newTestLinkCipherPair(t)
+		frame := seal(t, local, "payload")
+		if _, err := local.Open(testKeyID, testFrameHeader, frame); err == nil {
+			t.Errorf("Frame reflected back to its sender was accepted")
+		}
+	})
+}
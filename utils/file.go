@@ -0,0 +1,55 @@
+package utils
+
+// 文件读写相关的工具函数
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic 原子地写入文件
+//
+// 先写入同一目录下的临时文件并刷入磁盘，再重命名为目标文件，这样即使写入途中崩溃或断电，目标文件也只会是旧的或新的完整内容
+//
+// path: 目标文件路径
+// data: 文件内容
+// perm: 文件权限
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file: %w", err)
+	}
+	tmpPath := tmpFile.Name()
+	// 出错时清理临时文件
+	success := false
+	defer func() {
+		if !success {
+			tmpFile.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+	if _, err := tmpFile.Write(data); err != nil {
+		return fmt.Errorf("Failed to write temporary file: %w", err)
+	}
+	if err := tmpFile.Chmod(perm); err != nil {
+		return fmt.Errorf("Failed to set permissions of temporary file: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		return fmt.Errorf("Failed to sync temporary file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("Failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("Failed to rename temporary file: %w", err)
+	}
+	success = true
+	// 尽量把目录项的变化也刷入磁盘 (部分平台不支持同步目录，忽略错误)
+	if dirFile, err := os.Open(dir); err == nil {
+		dirFile.Sync()
+		dirFile.Close()
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package utils
+
+// 节点身份相关的工具，每个 switch 节点持有一个持久化的 Ed25519 密钥对，用于对其发起的发现包和生成的链路状态签名
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+)
+
+// 签名内容的前缀标签，避免签名被挪作他用
+const (
+	discoverySignatureLabel = "localsend-switch discovery v1"
+	linkStateSignatureLabel = "localsend-switch link state v1"
+)
+
+// NodeIdentity 本节点的 Ed25519 身份
+type NodeIdentity struct {
+	privateKey ed25519.PrivateKey
+}
+
+// LoadOrCreateNodeIdentity 从文件载入节点身份私钥，文件不存在时生成一个新的并保存
+//
+// 私钥以 PKCS#8 PEM 格式存储，文件权限为 0600
+//
+// path: 私钥文件路径
+func LoadOrCreateNodeIdentity(path string) (*NodeIdentity, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(pemBytes)
+		if block == nil {
+			return nil, fmt.Errorf("No PEM data found in node identity file '%s'", path)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse node identity file '%s': %w", path, err)
+		}
+		privateKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("Node identity file '%s' does not contain an Ed25519 private key", path)
+		}
+		return &NodeIdentity{privateKey: privateKey}, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("Failed to read node identity file '%s': %w", path, err)
+	}
+	// 文件不存在，生成新的身份
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to generate node identity: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode node identity: %w", err)
+	}
+	pemBytes = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, pemBytes, 0600); err != nil {
+		return nil, fmt.Errorf("Failed to write node identity file '%s': %w", path, err)
+	}
+	return &NodeIdentity{privateKey: privateKey}, nil
+}
+
+// PublicKey 返回节点身份公钥
+func (ni *NodeIdentity) PublicKey() ed25519.PublicKey {
+	return ni.privateKey.Public().(ed25519.PublicKey)
+}
+
+// PublicKeyString 返回 Base64 编码的节点身份公钥，可用于配置受信任节点
+func (ni *NodeIdentity) PublicKeyString() string {
+	return EncodeNodePublicKey(ni.PublicKey())
+}
+
+// SignDiscoveryMessage 对发现包签名，并把公钥和签名填入发现包
+//
+// 需要在发现包的受签名字段都填写完毕后调用
+func (ni *NodeIdentity) SignDiscoveryMessage(msg *switchdata.DiscoveryMessage) {
+	msg.OriginPublicKey = ni.PublicKey()
+	msg.OriginSignature = ed25519.Sign(ni.privateKey, discoverySigningPayload(msg))
+}
+
+// discoverySigningPayload 构造发现包的签名内容
+//
+// 包含转发过程中不会被修改的所有字段: 发起节点 ID、序列号、时间戳、原始地址、端口、协议、客户端指纹、客户端的别名、版本、设备型号、设备类型和是否支持下载 (保活和撤回中为空)、
+// 发起节点的名称和站点、发现包类型、客户端信息版本以及是否为征求，每一项前面带有 4 字节大端长度；
+// TTL 和转发路径在转发途中会变化，因此不在签名范围内
+func discoverySigningPayload(msg *switchdata.DiscoveryMessage) []byte {
+	payload := []byte(discoverySignatureLabel)
+	for _, field := range []string{
+		msg.SwitchId,
+		strconv.FormatUint(msg.DiscoverySeq, 10),
+		strconv.FormatInt(msg.OriginTimestamp, 10),
+		msg.OriginalAddr,
+		strconv.FormatInt(int64(msg.Port), 10),
+		msg.Protocol,
+		msg.Fingerprint,
+		msg.Alias,
+		msg.Version,
+		msg.DeviceModel,
+		msg.DeviceType,
+		strconv.FormatBool(msg.Download),
+		msg.OriginNodeName,
+		msg.OriginSite,
+		msg.Kind.String(),
+		strconv.FormatUint(msg.ClientVersion, 10),
+		strconv.FormatBool(msg.Solicit),
+	} {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(field)))
+		payload = append(payload, field...)
+	}
+	return payload
+}
+
+// VerifyDiscoveryMessageSignature 用发现包自带的公钥校验其签名
+//
+// 只能证明发现包没有被篡改，公钥本身是否可信需要另外判断
+func VerifyDiscoveryMessageSignature(msg *switchdata.DiscoveryMessage) error {
+	if len(msg.OriginPublicKey) == 0 || len(msg.OriginSignature) == 0 {
+		return errors.New("Switch message is not signed")
+	}
+	if len(msg.OriginPublicKey) != ed25519.PublicKeySize {
+		return errors.New("Invalid public key size in switch message")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(msg.OriginPublicKey), discoverySigningPayload(msg), msg.OriginSignature) {
+		return errors.New("Invalid signature in switch message")
+	}
+	return nil
+}
+
+// SignLinkState 对链路状态签名，并把公钥和签名填入链路状态
+func (ni *NodeIdentity) SignLinkState(state *switchdata.LinkState) {
+	state.PublicKey = ni.PublicKey()
+	state.Signature = ed25519.Sign(ni.privateKey, linkStateSigningPayload(state))
+}
+
+// linkStateSigningPayload 构造链路状态的签名内容
+//
+// 包含节点 ID、序列号和排序后的各相邻节点 ID，每一项前面带有 4 字节大端长度
+func linkStateSigningPayload(state *switchdata.LinkState) []byte {
+	payload := []byte(linkStateSignatureLabel)
+	neighbors := slices.Clone(state.Neighbors)
+	slices.Sort(neighbors)
+	for _, field := range append([]string{state.NodeId, strconv.FormatUint(state.Seq, 10)}, neighbors...) {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(field)))
+		payload = append(payload, field...)
+	}
+	return payload
+}
+
+// VerifyLinkStateSignature 用链路状态自带的公钥校验其签名
+//
+// 只能证明链路状态没有被篡改，公钥本身是否可信需要另外判断
+func VerifyLinkStateSignature(state *switchdata.LinkState) error {
+	if len(state.PublicKey) == 0 || len(state.Signature) == 0 {
+		return errors.New("Link state is not signed")
+	}
+	if len(state.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("Invalid public key size in link state")
+	}
+	if !ed25519.Verify(ed25519.PublicKey(state.PublicKey), linkStateSigningPayload(state), state.Signature) {
+		return errors.New("Invalid signature in link state")
+	}
+	return nil
+}
+
+// EncodeNodePublicKey 把节点公钥编码为 Base64 字符串
+func EncodeNodePublicKey(publicKey []byte) string {
+	return base64.StdEncoding.EncodeToString(publicKey)
+}
+
+// DecodeNodePublicKey 解析 Base64 编码的节点公钥
+func DecodeNodePublicKey(encoded string) (ed25519.PublicKey, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("Invalid Ed25519 public key size: %d", len(publicKey))
+	}
+	return ed25519.PublicKey(publicKey), nil
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	switchdata "github.com/somebottle/localsend-switch/generated/switchdata/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestSignedDiscoveryMessage 生成一个用随机身份签名的完整公告
+func newTestSignedDiscoveryMessage(t *testing.T) *switchdata.DiscoveryMessage {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate identity: %v", err)
+	}
+	msg := &switchdata.DiscoveryMessage{
+		SwitchId:        "origin",
+		DiscoverySeq:    42,
+		DiscoveryTtl:    8,
+		Alias:           "Laptop",
+		Version:         "2.1",
+		DeviceModel:     "ThinkPad",
+		DeviceType:      "desktop",
+		Fingerprint:     "fingerprint",
+		Port:            53317,
+		Protocol:        "https",
+		Download:        true,
+		OriginalAddr:    "192.168.1.10",
+		OriginTimestamp: 1700000000000,
+		OriginNodeName:  "node",
+		OriginSite:      "site",
+		Kind:            switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE,
+		ClientVersion:   3,
+		Solicit:         true,
+	}
+	(&NodeIdentity{privateKey: privateKey}).SignDiscoveryMessage(msg)
+	return msg
+}
+
+// TestVerifyDiscoveryMessageSignatureTampered 篡改任意一个受签名的字段后签名校验都会失败
+func TestVerifyDiscoveryMessageSignatureTampered(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(msg *switchdata.DiscoveryMessage)
+	}{
+		{"SwitchId", func(msg *switchdata.DiscoveryMessage) { msg.SwitchId = "forged" }},
+		{"DiscoverySeq", func(msg *switchdata.DiscoveryMessage) { msg.DiscoverySeq++ }},
+		{"Alias", func(msg *switchdata.DiscoveryMessage) { msg.Alias = "Forged" }},
+		{"Version", func(msg *switchdata.DiscoveryMessage) { msg.Version = "2.0" }},
+		{"DeviceModel", func(msg *switchdata.DiscoveryMessage) { msg.DeviceModel = "Forged" }},
+		{"DeviceType", func(msg *switchdata.DiscoveryMessage) { msg.DeviceType = "mobile" }},
+		{"Fingerprint", func(msg *switchdata.DiscoveryMessage) { msg.Fingerprint = "forged" }},
+		{"Port", func(msg *switchdata.DiscoveryMessage) { msg.Port = 8080 }},
+		{"Protocol", func(msg *switchdata.DiscoveryMessage) { msg.Protocol = "http" }},
+		{"Download", func(msg *switchdata.DiscoveryMessage) { msg.Download = false }},
+		{"OriginalAddr", func(msg *switchdata.DiscoveryMessage) { msg.OriginalAddr = "10.0.0.66" }},
+		{"OriginTimestamp", func(msg *switchdata.DiscoveryMessage) { msg.OriginTimestamp++ }},
+		{"OriginNodeName", func(msg *switchdata.DiscoveryMessage) { msg.OriginNodeName = "forged" }},
+		{"OriginSite", func(msg *switchdata.DiscoveryMessage) { msg.OriginSite = "forged" }},
+		{"Kind", func(msg *switchdata.DiscoveryMessage) { msg.Kind++ }},
+		{"ClientVersion", func(msg *switchdata.DiscoveryMessage) { msg.ClientVersion++ }},
+		{"Solicit", func(msg *switchdata.DiscoveryMessage) { msg.Solicit = false }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := newTestSignedDiscoveryMessage(t)
+			if err := VerifyDiscoveryMessageSignature(msg); err != nil {
+				t.Fatalf("Signature of untampered message is invalid: %v", err)
+			}
+			tt.tamper(msg)
+			if err := VerifyDiscoveryMessageSignature(msg); err == nil {
+				t.Errorf("Signature is still valid after tampering with %s", tt.name)
+			}
+		})
+	}
+}
+
+// TestVerifyDiscoveryMessageSignatureForwarded 转发途中修改 TTL 和转发路径不影响签名
+func TestVerifyDiscoveryMessageSignatureForwarded(t *testing.T) {
+	msg := newTestSignedDiscoveryMessage(t)
+	forwarded := proto.Clone(msg).(*switchdata.DiscoveryMessage)
+	forwarded.DiscoveryTtl--
+	AppendDiscoveryPathHop(forwarded, "relay")
+	if err := VerifyDiscoveryMessageSignature(forwarded); err != nil {
+		t.Errorf("Signature of forwarded message is invalid: %v", err)
+	}
+}
@@ -1,9 +1,16 @@
 package utils
 
 import (
+	"context"
+	"fmt"
 	"log"
+	"math/rand/v2"
 	"net"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/somebottle/localsend-switch/entities"
 )
 
 // IsIpv6 判断给定的地址是否为 IPv6 地址
@@ -29,6 +36,38 @@ func GetOutboundIP() (net.IP, error) {
 	return localAddr.IP, nil
 }
 
+// ResolveHostIPs 把主机名解析为所有 IP 地址 (A / AAAA 记录)，如果 host 本身就是 IP 地址则直接返回
+//
+// ctx: 解析用的上下文，可用于超时控制
+// host: 主机名或 IP 地址
+func ResolveHostIPs(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(ipAddrs))
+	for _, ipAddr := range ipAddrs {
+		ips = append(ips, ipAddr.IP)
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("No IP address found for host '%s'", host)
+	}
+	return ips, nil
+}
+
+// ContainsIP 判断 IP 列表中是否包含指定 IP
+func ContainsIP(ips []net.IP, ip net.IP) bool {
+	for _, candidate := range ips {
+		if candidate.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetInterfaceByIP 根据给定的 IP 地址获取对应的网络接口
 // 返回 (*net.Interface, error)：找到的网络接口指针，如果未找到则返回 nil；如果发生错误，返回错误
 func GetInterfaceByIP(ip net.IP) (*net.Interface, error) {
@@ -75,4 +114,66 @@ func ParsePort(portStr string) (uint16, error) {
 		return 0, err
 	}
 	return uint16(port), nil
-}
\ No newline at end of file
+}
+
+// SplitCommaSeparated 把以逗号分隔的列表拆分为各项，去除每一项两端的空白并忽略空项
+func SplitCommaSeparated(list string) []string {
+	items := make([]string, 0)
+	for item := range strings.SplitSeq(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParsePeerEndpoints 解析以逗号分隔的对端 switch 地址列表
+//
+// 每一项可以是 host、host:port 或 [IPv6]:port 的形式，没有端口的项使用 defaultPort；
+// 列表中越靠前的项优先级越高
+//
+// peerList: 对端地址列表，如 "10.0.0.1,backup.example.com:7762"
+// defaultPort: 默认端口
+func ParsePeerEndpoints(peerList string, defaultPort string) ([]entities.PeerEndpoint, error) {
+	endpoints := make([]entities.PeerEndpoint, 0)
+	for _, item := range SplitCommaSeparated(peerList) {
+		host, port, err := net.SplitHostPort(item)
+		if err != nil {
+			// 没有端口，整项作为主机地址 (可能是不带方括号的 IPv6 地址)
+			host = strings.TrimSuffix(strings.TrimPrefix(item, "["), "]")
+			port = defaultPort
+		}
+		if host == "" {
+			return nil, fmt.Errorf("Empty host in peer address '%s'", item)
+		}
+		if port == "" {
+			return nil, fmt.Errorf("No port specified for peer address '%s'", item)
+		}
+		if _, err := ParsePort(port); err != nil {
+			return nil, fmt.Errorf("Invalid port in peer address '%s': %w", item, err)
+		}
+		endpoints = append(endpoints, entities.PeerEndpoint{
+			Host:     host,
+			Port:     port,
+			Priority: len(endpoints),
+		})
+	}
+	return endpoints, nil
+}
+
+// BackoffWithJitter 计算第 attempt 次重试前的等待时间 (指数退避 + 随机抖动)
+//
+// 等待时间在 [d/2, d] 之间随机，其中 d = min(maxInterval, baseInterval * 2^(attempt-1))
+//
+// baseInterval: 初始间隔
+// maxInterval: 最大间隔
+// attempt: 重试次数，从 1 开始
+func BackoffWithJitter(baseInterval time.Duration, maxInterval time.Duration, attempt int) time.Duration {
+	d := baseInterval
+	for i := 1; i < attempt && d < maxInterval; i++ {
+		d *= 2
+	}
+	d = min(d, maxInterval)
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
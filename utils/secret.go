@@ -0,0 +1,125 @@
+package utils
+
+// 密钥读取和生成相关的工具
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/somebottle/localsend-switch/configs"
+)
+
+// ReadSecretKeyFile 从文件读取密钥，去除两端的空白 (比如末尾的换行符)
+//
+// 在支持的平台上，其他用户可读的文件会被拒绝
+//
+// path: 密钥文件路径
+func ReadSecretKeyFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if err := checkSecretFilePermissions(info); err != nil {
+		return "", fmt.Errorf("Refused to use secret key file '%s': %w", path, err)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimSpace(string(content))
+	if secret == "" {
+		return "", fmt.Errorf("Secret key file '%s' is empty", path)
+	}
+	return secret, nil
+}
+
+// ReadSecretKeyCredential 从 systemd 凭据目录 ($CREDENTIALS_DIRECTORY) 读取指定名称的密钥
+//
+// 返回 (secret, found, err)，没有设置凭据目录或凭据不存在时 found 为 false
+//
+// name: 凭据名称
+func ReadSecretKeyCredential(name string) (string, bool, error) {
+	credentialsDir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if credentialsDir == "" {
+		return "", false, nil
+	}
+	secret, err := ReadSecretKeyFile(filepath.Join(credentialsDir, name))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return secret, true, nil
+}
+
+// 密钥的来源
+const (
+	// 没有配置密钥
+	SecretKeySourceNone = ""
+	// 直接通过 --secret-key 或环境变量传入
+	SecretKeySourceValue = "value"
+	// 从 --secret-key-file 指定的文件读取
+	SecretKeySourceFile = "file"
+	// 从 systemd 凭据读取
+	SecretKeySourceCredential = "credential"
+)
+
+// LoadSecretKey 按配置确定交换数据加密密钥
+//
+// 直接传入的密钥和密钥文件只能有一个；都没有时尝试从 systemd 凭据读取，仍然没有时返回空密钥
+//
+// 返回 (secret, source, err)
+//
+// secretKey: 直接传入的密钥
+// secretKeyFile: 密钥文件路径
+// credentialName: systemd 凭据名称
+func LoadSecretKey(secretKey string, secretKeyFile string, credentialName string) (string, string, error) {
+	if secretKeyFile != "" {
+		if secretKey != "" {
+			return "", SecretKeySourceNone, errors.New("Only one of secret key (--secret-key) and secret key file (--secret-key-file) can be provided")
+		}
+		secret, err := ReadSecretKeyFile(secretKeyFile)
+		if err != nil {
+			return "", SecretKeySourceNone, fmt.Errorf("Failed to read secret key file '%s': %w", secretKeyFile, err)
+		}
+		return secret, SecretKeySourceFile, nil
+	}
+	if secretKey != "" {
+		return secretKey, SecretKeySourceValue, nil
+	}
+	secret, found, err := ReadSecretKeyCredential(credentialName)
+	if err != nil {
+		return "", SecretKeySourceNone, fmt.Errorf("Failed to read secret key from systemd credential '%s': %w", credentialName, err)
+	}
+	if found {
+		return secret, SecretKeySourceCredential, nil
+	}
+	return "", SecretKeySourceNone, nil
+}
+
+// CheckSecretKeyStrength 拒绝过短的交换数据加密密钥
+//
+// 密钥 ID 以明文出现在握手和每个数据帧中，截获到它的人可以离线地逐个尝试常见的密码，因此密钥必须是高熵的随机字符串 (比如由 keygen 生成)
+//
+// secret: 密钥
+func CheckSecretKeyStrength(secret string) error {
+	if len(secret) < configs.MinSecretKeyLength {
+		return fmt.Errorf("Secret key is too short (%d characters), it must be at least %d characters long, use 'keygen' to generate a strong random key", len(secret), configs.MinSecretKeyLength)
+	}
+	return nil
+}
+
+// GenerateSecretKey 生成一个随机的强密钥 (32 字节随机数的 Base64 URL 编码)
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// checkSecretFilePermissions 在非 UNIX 系统上不检查密钥文件的权限
+//
+// 这类系统 (如 Windows) 的文件权限不以权限位表示
+func checkSecretFilePermissions(info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCheckSecretKeyStrength 过短的密钥被拒绝，keygen 生成的密钥可以使用
+func TestCheckSecretKeyStrength(t *testing.T) {
+	generated, err := GenerateSecretKey()
+	if err != nil {
+		t.Fatalf("Failed to generate secret key: %v", err)
+	}
+	tests := []struct {
+		name   string
+		secret string
+		reject bool
+	}{
+		{name: "generated", secret: generated},
+		{name: "minimum length", secret: strings.Repeat("k", 16)},
+		{name: "too short", secret: "el_psy_kongroo", reject: true},
+		{name: "single character", secret: "k", reject: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckSecretKeyStrength(tt.secret)
+			if tt.reject && err == nil {
+				t.Errorf("Expected secret key of %d characters to be rejected", len(tt.secret))
+			}
+			if !tt.reject && err != nil {
+				t.Errorf("Expected secret key of %d characters to be accepted, got %v", len(tt.secret), err)
+			}
+		})
+	}
+}
+
+// TestLoadSecretKey 直接传入的密钥、密钥文件和 systemd 凭据的优先级
+func TestLoadSecretKey(t *testing.T) {
+	const credentialName = "localsend-switch-secret-key"
+	writeSecret := func(t *testing.T, dir string, name string, secret string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+			t.Fatalf("Failed to write secret key: %v", err)
+		}
+		return path
+	}
+	tests := []struct {
+		name string
+		// 直接传入的密钥
+		value string
+		// 是否使用密钥文件
+		useFile bool
+		// 是否设置凭据目录，以及凭据目录中是否有密钥
+		credentialsDir bool
+		credential     bool
+		wantSecret     string
+		wantSource     string
+		wantErr        bool
+	}{
+		{name: "nothing configured", wantSource: SecretKeySourceNone},
+		{name: "value", value: "from-value", wantSecret: "from-value", wantSource: SecretKeySourceValue},
+		{name: "file", useFile: true, wantSecret: "from-file", wantSource: SecretKeySourceFile},
+		{name: "both value and file", value: "from-value", useFile: true, wantErr: true},
+		{name: "credential", credentialsDir: true, credential: true, wantSecret: "from-credential", wantSource: SecretKeySourceCredential},
+		{name: "credentials directory without the credential", credentialsDir: true, wantSource: SecretKeySourceNone},
+		{name: "value takes precedence over credential", value: "from-value", credentialsDir: true, credential: true, wantSecret: "from-value", wantSource: SecretKeySourceValue},
+		{name: "file takes precedence over credential", useFile: true, credentialsDir: true, credential: true, wantSecret: "from-file", wantSource: SecretKeySourceFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			secretKeyFile := ""
+			if tt.useFile {
+				secretKeyFile = writeSecret(t, dir, "secret.key", "from-file")
+			}
+			t.Setenv("CREDENTIALS_DIRECTORY", "")
+			if tt.credentialsDir {
+				credentialsDir := filepath.Join(dir, "credentials")
+				if err := os.Mkdir(credentialsDir, 0700); err != nil {
+					t.Fatalf("Failed to create credentials directory: %v", err)
+				}
+				if tt.credential {
+					writeSecret(t, credentialsDir, credentialName, "from-credential")
+				}
+				t.Setenv("CREDENTIALS_DIRECTORY", credentialsDir)
+			}
+			secret, source, err := LoadSecretKey(tt.value, secretKeyFile, credentialName)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got secret %q from %q", secret, source)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to load secret key: %v", err)
+			}
+			if secret != tt.wantSecret || source != tt.wantSource {
+				t.Errorf("Got secret %q from %q, expected %q from %q", secret, source, tt.wantSecret, tt.wantSource)
+			}
+		})
+	}
+}
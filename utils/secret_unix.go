@@ -0,0 +1,16 @@
+//go:build unix
+
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+// checkSecretFilePermissions 检查密钥文件的权限，拒绝其他用户 (others) 可读的文件
+func checkSecretFilePermissions(info os.FileInfo) error {
+	if info.Mode().Perm()&0o004 != 0 {
+		return errors.New("File is readable by other users, please restrict its permissions (e.g. chmod 600)")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestReadSecretKeyFilePermissions 其他用户可读的密钥文件被拒绝
+func TestReadSecretKeyFilePermissions(t *testing.T) {
+	tests := []struct {
+		perm   os.FileMode
+		reject bool
+	}{
+		{perm: 0600},
+		{perm: 0640},
+		{perm: 0644, reject: true},
+		{perm: 0604, reject: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.perm.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "secret.key")
+			if err := os.WriteFile(path, []byte("q3Vt8xJmR2wLp6KdN9bYf4HsZc7GaE1u\n"), 0600); err != nil {
+				t.Fatalf("Failed to write secret key file: %v", err)
+			}
+			// 不受 umask 影响
+			if err := os.Chmod(path, tt.perm); err != nil {
+				t.Fatalf("Failed to change permissions of secret key file: %v", err)
+			}
+			secret, err := ReadSecretKeyFile(path)
+			if tt.reject && err == nil {
+				t.Errorf("Expected secret key file with permissions %o to be refused", tt.perm)
+			}
+			if !tt.reject && (err != nil || secret != "q3Vt8xJmR2wLp6KdN9bYf4HsZc7GaE1u") {
+				t.Errorf("Expected secret key file with permissions %o to be read, got %q, %v", tt.perm, secret, err)
+			}
+		})
+	}
+}
@@ -5,8 +5,12 @@ package utils
 import (
 	"crypto/rand"
 	"errors"
+	"fmt"
 	"net"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/somebottle/localsend-switch/configs"
 	"github.com/somebottle/localsend-switch/entities"
@@ -25,6 +29,31 @@ func GenerateRandomSwitchID() string {
 	return string(randomBytes)
 }
 
+// LoadOrCreateNodeID 从文件载入节点 ID，文件不存在时生成一个新的并保存，使节点 ID 在重启后保持不变
+//
+// 新文件原子地写入，文件权限为 0600，中途崩溃也不会留下残缺的节点 ID
+//
+// path: 节点 ID 文件路径
+func LoadOrCreateNodeID(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err == nil {
+		nodeId := strings.TrimSpace(string(content))
+		if nodeId == "" || strings.ContainsFunc(nodeId, func(r rune) bool { return !strings.ContainsRune(ID_LETTERS, r) }) {
+			return "", fmt.Errorf("Invalid node ID in file '%s'", path)
+		}
+		return nodeId, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("Failed to read node ID file '%s': %w", path, err)
+	}
+	// 文件不存在，生成新的节点 ID
+	nodeId := GenerateRandomSwitchID()
+	if err := WriteFileAtomic(path, []byte(nodeId+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("Failed to write node ID file '%s': %w", path, err)
+	}
+	return nodeId, nil
+}
+
 // GetDiscoveryId 从交换消息中获取唯一的发现包 ID，格式为 SwitchId_DiscoverySeq
 func GetDiscoveryId(switchMsg *entities.SwitchMessage) string {
 	var discoveryId string = switchMsg.Payload.SwitchId + "_" + strconv.FormatUint(switchMsg.Payload.DiscoverySeq, 10)
@@ -50,25 +79,89 @@ func SwitchMessageToLocalSendClientInfo(switchMsg *entities.SwitchMessage) (*ent
 	return clientInfo, nil
 }
 
+// AppendDiscoveryPathHop 把本节点追加到发现包的转发路径末尾
+//
+// msg: 发现包
+// nodeId: 本节点 ID
+func AppendDiscoveryPathHop(msg *switchdata.DiscoveryMessage, nodeId string) {
+	hop := &switchdata.DiscoveryHop{NodeId: nodeId}
+	if configs.GetPathTimestamps() {
+		hop.Timestamp = time.Now().UnixMilli()
+	}
+	msg.Path = append(msg.Path, hop)
+}
+
+// DiscoveryPathContains 判断发现包是否已经经过某个节点
+//
+// msg: 发现包
+// nodeId: 节点 ID
+func DiscoveryPathContains(msg *switchdata.DiscoveryMessage, nodeId string) bool {
+	for _, hop := range msg.Path {
+		if hop.NodeId == nodeId {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatDiscoveryPath 把发现包的转发路径格式化为便于阅读的字符串，用于日志
+//
+// 形如 "nodeA > nodeB(+12ms) > nodeC"，记录了时间的一跳会标出和上一个时间点 (第一跳则为发起时间) 的间隔
+//
+// msg: 发现包
+func FormatDiscoveryPath(msg *switchdata.DiscoveryMessage) string {
+	var builder strings.Builder
+	lastTimestamp := msg.OriginTimestamp
+	for i, hop := range msg.Path {
+		if i > 0 {
+			builder.WriteString(" > ")
+		}
+		builder.WriteString(hop.NodeId)
+		if hop.Timestamp != 0 {
+			if lastTimestamp != 0 {
+				fmt.Fprintf(&builder, "(%+dms)", hop.Timestamp-lastTimestamp)
+			}
+			lastTimestamp = hop.Timestamp
+		}
+	}
+	return builder.String()
+}
+
 // packLocalSendClientInfoIntoSwitchMessage 将 LocalSend 客户端信息打包进交换消息
 //
+// 保活 (REFRESH) 和撤回 (WITHDRAW) 类型的发现包只保留注册客户端所需的字段，省略别名、设备信息等
+//
 // nodeId: 节点 ID
 // discoverySeq: 发现包序列号
 // selfIP: 本机 IP 地址，用于填充 original_addr 字段
-func PackLocalSendClientInfoIntoSwitchMessage(clientInfo *entities.LocalSendClientInfo, nodeId string, discoverySeq uint64, selfIP net.IP) *entities.SwitchMessage {
+// kind: 发现包类型
+// clientVersion: 客户端信息的版本
+func PackLocalSendClientInfoIntoSwitchMessage(clientInfo *entities.LocalSendClientInfo, nodeId string, discoverySeq uint64, selfIP net.IP, kind switchdata.AnnouncementKind, clientVersion uint64) *entities.SwitchMessage {
 	discoveryMsg := &switchdata.DiscoveryMessage{
-		SwitchId:     nodeId,
-		DiscoverySeq: discoverySeq,
-		DiscoveryTtl: configs.MaxDiscoveryMessageTTL,
-		Alias:        clientInfo.Alias,
-		Version:      clientInfo.Version,
-		DeviceModel:  clientInfo.DeviceModel,
-		DeviceType:   clientInfo.DeviceType,
-		Fingerprint:  clientInfo.Fingerprint,
-		Port:         int32(clientInfo.Port),
-		Protocol:     clientInfo.Protocol,
-		Download:     clientInfo.Download,
-		OriginalAddr: selfIP.String(),
+		SwitchId:        nodeId,
+		DiscoverySeq:    discoverySeq,
+		DiscoveryTtl:    configs.MaxDiscoveryMessageTTL,
+		Alias:           clientInfo.Alias,
+		Version:         clientInfo.Version,
+		DeviceModel:     clientInfo.DeviceModel,
+		DeviceType:      clientInfo.DeviceType,
+		Fingerprint:     clientInfo.Fingerprint,
+		Port:            int32(clientInfo.Port),
+		Protocol:        clientInfo.Protocol,
+		Download:        clientInfo.Download,
+		OriginalAddr:    selfIP.String(),
+		OriginTimestamp: time.Now().UnixMilli(),
+		OriginNodeName:  configs.GetNodeName(),
+		OriginSite:      configs.GetNodeSite(),
+		Kind:            kind,
+		ClientVersion:   clientVersion,
+	}
+	if kind != switchdata.AnnouncementKind_ANNOUNCEMENT_KIND_ANNOUNCE {
+		discoveryMsg.Alias = ""
+		discoveryMsg.Version = ""
+		discoveryMsg.DeviceModel = ""
+		discoveryMsg.DeviceType = ""
+		discoveryMsg.Download = false
 	}
 	return &entities.SwitchMessage{
 		// SourceAddr 可以不用填，发送时只看 Payload
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestLoadOrCreateNodeID 首次生成的节点 ID 文件只有所有者可读写，重新载入得到同一节点 ID
+func TestLoadOrCreateNodeID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "node-id")
+	nodeId, err := LoadOrCreateNodeID(path)
+	if err != nil {
+		t.Fatalf("Failed to create node ID: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat node ID file: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("Node ID file has permissions %o, expected 600", info.Mode().Perm())
+	}
+	reloaded, err := LoadOrCreateNodeID(path)
+	if err != nil {
+		t.Fatalf("Failed to reload node ID: %v", err)
+	}
+	if reloaded != nodeId {
+		t.Errorf("Reloaded node ID %s, expected %s", reloaded, nodeId)
+	}
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA 测试用的 CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// CA 证书文件路径
+	certFile string
+}
+
+// writeTestPEM 把 PEM 块写入临时目录中的文件，返回文件路径
+func writeTestPEM(t *testing.T, name string, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// newTestCA 生成一个自签名的 CA
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+	return &testCA{cert: cert, key: key, certFile: writeTestPEM(t, "ca.pem", "CERTIFICATE", der)}
+}
+
+// issue 签发一个只有主题 CN、没有任何 SAN 的节点证书，返回证书和私钥文件路径
+func (ca *testCA) issue(t *testing.T, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate node key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("Failed to create node certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal node key: %v", err)
+	}
+	return writeTestPEM(t, commonName+".pem", "CERTIFICATE", der), writeTestPEM(t, commonName+".key", "PRIVATE KEY", keyDer)
+}
+
+// newTestLinkTLSConfig 用指定 CA 签发的证书构造链路 TLS 配置
+func newTestLinkTLSConfig(t *testing.T, ca *testCA, trustedCA *testCA, commonName string, allowedPeers []string) *tls.Config {
+	certFile, keyFile := ca.issue(t, commonName)
+	config, err := LoadLinkTLSConfig(certFile, keyFile, trustedCA.certFile, allowedPeers)
+	if err != nil {
+		t.Fatalf("Failed to load TLS config: %v", err)
+	}
+	return config
+}
+
+// runTestTLSHandshake 两端完成一次 TLS 握手，客户端以 IP 地址作为对端主机名
+func runTestTLSHandshake(t *testing.T, clientConfig *tls.Config, serverConfig *tls.Config) (clientErr error, serverErr error) {
+	// 一端拒绝握手时会发出告警，需要带缓冲的连接
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer clientConn.Close()
+	serverConn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	defer serverConn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	clientConn.SetDeadline(deadline)
+	serverConn.SetDeadline(deadline)
+
+	clientConfig = clientConfig.Clone()
+	clientConfig.ServerName = "192.168.1.20"
+	done := make(chan error, 1)
+	go func() {
+		server := tls.Server(serverConn, serverConfig)
+		err := server.Handshake()
+		serverConn.Close()
+		done <- err
+	}()
+	client := tls.Client(clientConn, clientConfig)
+	clientErr = client.Handshake()
+	clientConn.Close()
+	return clientErr, <-done
+}
+
+// TestLinkTLSConfig 对端证书只需由 CA 签发并满足允许的主题 / SAN，不和拨号的 IP 地址比对
+func TestLinkTLSConfig(t *testing.T) {
+	ca := newTestCA(t, "switch-ca")
+	otherCA := newTestCA(t, "other-ca")
+	server := newTestLinkTLSConfig(t, ca, ca, "switch-a", nil)
+
+	t.Run("certificate without IP SAN dialed by IP", func(t *testing.T) {
+		clientErr, serverErr := runTestTLSHandshake(t, newTestLinkTLSConfig(t, ca, ca, "switch-b", nil), server)
+		if clientErr != nil || serverErr != nil {
+			t.Fatalf("Handshake failed: client %v, server %v", clientErr, serverErr)
+		}
+	})
+	t.Run("allowed peer", func(t *testing.T) {
+		clientErr, serverErr := runTestTLSHandshake(t, newTestLinkTLSConfig(t, ca, ca, "switch-b", []string{"switch-a"}), server)
+		if clientErr != nil || serverErr != nil {
+			t.Fatalf("Handshake failed: client %v, server %v", clientErr, serverErr)
+		}
+	})
+	t.Run("peer not in allowed list", func(t *testing.T) {
+		clientErr, _ := runTestTLSHandshake(t, newTestLinkTLSConfig(t, ca, ca, "switch-b", []string{"switch-c"}), server)
+		if clientErr == nil {
+			t.Fatalf("Expected client to reject server not in the allowed peer list")
+		}
+	})
+	t.Run("server signed by another CA", func(t *testing.T) {
+		clientErr, _ := runTestTLSHandshake(t, newTestLinkTLSConfig(t, ca, ca, "switch-b", nil), newTestLinkTLSConfig(t, otherCA, ca, "switch-x", nil))
+		if clientErr == nil {
+			t.Fatalf("Expected client to reject server certificate signed by another CA")
+		}
+	})
+	t.Run("client signed by another CA", func(t *testing.T) {
+		_, serverErr := runTestTLSHandshake(t, newTestLinkTLSConfig(t, otherCA, ca, "switch-x", nil), server)
+		if serverErr == nil {
+			t.Fatalf("Expected server to reject client certificate signed by another CA")
+		}
+	})
+}